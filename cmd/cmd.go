@@ -2,22 +2,36 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 
 	"github.com/ottramst/gossm/internal"
 )
 
 const (
-	// commandWaitTime is the duration to wait for command execution results
-	commandWaitTime = 3 * time.Second
+	// defaultCommandTimeout is how long to wait for a command by default, matching the
+	// execution timeout of the default Run Command document
+	defaultCommandTimeout = time.Hour
+
+	// defaultCommandConcurrency is the default number of invocations polled at once
+	defaultCommandConcurrency = 10
+
+	// defaultConfirmThreshold is the number of targets above which a command must be confirmed
+	defaultConfirmThreshold = 5
 )
 
 var (
@@ -25,15 +39,46 @@ var (
 	cmdCommand = &cobra.Command{
 		Use:   "cmd",
 		Short: "Execute SSM Run Command on AWS instances",
-		Long:  "Execute AWS Systems Manager Run Command on selected instances with an interactive CLI",
-		Run:   runCommand,
+		Long: `Execute AWS Systems Manager Run Command on selected instances with an interactive CLI.
+
+Running a command on more than --confirm-threshold instances (default 5) asks you to type the
+number of instances to confirm. Scripts and pipelines, which can't answer, must pass --yes.
+
+gossm prints the command IDs and returns right away, leaving the command running; gossm cmd-status
+shows the results later. With --wait, gossm waits until the command finished on every instance, up
+to --timeout (default 1h), prints the results and exits with a non-zero status if it failed on any
+instance or didn't finish in time.
+
+Targets can also be read from a file or stdin with --targets-file, one instance ID or Name tag
+per line, which makes cmd composable with gossm ls and tools such as grep and jq.
+
+--all-targets runs the command on every discovered instance, narrowed only by --filter and the
+other discovery flags. It always asks for confirmation, whatever the threshold, unless --yes is
+passed as well.
+
+--ssm-target lets SSM resolve the instances by tag or resource group instead, so no instances are
+discovered and ec2:DescribeInstances isn't needed. As the matching instances aren't known up
+front, it requires --yes.
+
+Example:
+  gossm cmd -e "uptime"                                  # Interactive instance selection
+  gossm cmd -e "uptime" --wait                           # Wait for and print each instance's output
+  gossm cmd -e "uptime" -t i-1234                        # Run on a specific instance ID
+  gossm cmd -e "uptime" --group-by Role                  # Choose instances grouped by their Role tag
+  gossm ls -o json | jq -r '.[].instanceId' | gossm cmd -e "uptime" --targets-file - --yes
+  gossm cmd -e "df -h" --wait --output-dir ./df --files-only  # Save each instance's output to files
+  gossm cmd -e "yum -y update" --wait --timeout 30m      # Fail if the command takes longer than 30 minutes
+  gossm cmd -e "uptime" --ssm-target tag:Env=prod --yes  # Run on every instance tagged Env=prod
+  gossm cmd -e "yum -y update" --all-targets --yes      # Patch every connected instance
+`,
+		Run: runCommand,
 	}
 )
 
 // findSpecificTarget looks for a specific target by name
 func findSpecificTarget(ctx context.Context, targetName string) ([]*internal.Target, error) {
 	// Get all available instances
-	allInstances, err := internal.FindInstances(ctx, *credential.awsConfig)
+	allInstances, err := findInstances(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -49,6 +94,63 @@ func findSpecificTarget(ctx context.Context, targetName string) ([]*internal.Tar
 	return nil, fmt.Errorf("target instance '%s' not found", targetName)
 }
 
+// readTargetNames reads instance IDs or Name tags, one per line, from a file or "-" for stdin.
+// Blank lines and lines starting with # are skipped.
+func readTargetNames(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets file: %w", err)
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || slices.Contains(names, line) {
+			continue
+		}
+		names = append(names, line)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no targets in %s", path)
+	}
+
+	return names, nil
+}
+
+// matchTargets returns the instances whose ID or Name tag is one of names. A Name tag shared by
+// several instances selects all of them. Every name must match at least one instance.
+func matchTargets(instances map[string]*internal.Target, names []string) ([]*internal.Target, error) {
+	var targets []*internal.Target
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, key := range slices.Sorted(maps.Keys(instances)) {
+			instance := instances[key]
+			if instance.Name != name && instance.InstanceName != name {
+				continue
+			}
+			found = true
+			if !slices.Contains(targets, instance) {
+				targets = append(targets, instance)
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("target instances not found: %s", strings.Join(missing, ", "))
+	}
+	return targets, nil
+}
+
 // findTargetInstances identifies the instances to target for command execution
 func findTargetInstances(ctx context.Context) ([]*internal.Target, error) {
 	// Check if a specific target was specified
@@ -57,8 +159,49 @@ func findTargetInstances(ctx context.Context) ([]*internal.Target, error) {
 		return findSpecificTarget(ctx, argTarget)
 	}
 
+	// Resolve a list of targets, e.g. piped from gossm ls
+	if path := viper.GetString("cmd-targets-file"); path != "" {
+		names, err := readTargetNames(path)
+		if err != nil {
+			return nil, err
+		}
+		instances, err := findInstances(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return matchTargets(instances, names)
+	}
+
 	// If no specific target, prompt user to select targets
-	return internal.AskMultiTarget(ctx, *credential.awsConfig)
+	stopSpinner := internal.StartSpinner("Discovering instances")
+	instances, err := findInstances(ctx)
+	stopSpinner()
+	if err != nil {
+		return nil, err
+	}
+
+	// --all-targets and a --target-regex select every instance found
+	if viper.GetBool("cmd-all-targets") || viper.GetString("target-regex") != "" {
+		return sortedTargets(instances), nil
+	}
+	return internal.AskMultiTarget(instances, viper.GetString("cmd-group-by"))
+}
+
+// confirmTargets asks for confirmation before a command runs on more instances than the
+// confirmation threshold, or on all of them with --all-targets. Without a terminal to ask on,
+// --yes is required instead.
+func confirmTargets(execCommand string, count int) error {
+	allTargets := viper.GetBool("cmd-all-targets")
+	if viper.GetBool("cmd-yes") || (count <= viper.GetInt("cmd-confirm-threshold") && !allTargets) {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		if allTargets {
+			return fmt.Errorf("%w: --all-targets runs on every instance, which needs --yes when not run interactively", internal.ErrNotConfirmed)
+		}
+		return fmt.Errorf("%w: running on %d instances needs --yes when not run interactively", internal.ErrNotConfirmed, count)
+	}
+	return internal.ConfirmTargetCount(execCommand, count)
 }
 
 // displayCommandInfo shows information about the command to be executed
@@ -76,66 +219,284 @@ func displayCommandInfo(execCommand string, targets []*internal.Target) {
 	internal.PrintReady(execCommand, credential.awsConfig.Region, targetNames.String())
 }
 
-// displayCommandResults waits for and displays the results of command execution
-func displayCommandResults(ctx context.Context, sendOutput *ssm.SendCommandOutput) {
-	fmt.Printf("%s\n", color.YellowString("Waiting for command results..."))
+// groupTargetsByRegion groups targets by the region they run in
+func groupTargetsByRegion(targets []*internal.Target) map[string][]*internal.Target {
+	groups := make(map[string][]*internal.Target)
+	for _, target := range targets {
+		region := target.Region
+		if region == "" {
+			region = credential.awsConfig.Region
+		}
+		groups[region] = append(groups[region], target)
+	}
+	return groups
+}
+
+// printCloudWatchLocation shows where the command output can be followed in CloudWatch Logs
+func printCloudWatchLocation(region string, opts internal.RunCommandOptions, commands []ssmtypes.Command) {
+	logGroup := opts.LogGroup()
+	if logGroup == "" {
+		return
+	}
 
-	// Wait for command execution to complete
-	time.Sleep(commandWaitTime)
+	for _, command := range commands {
+		internal.Infof("Output is sent to CloudWatch log group %s, streams %s/<instance-id>/...",
+			logGroup, aws.ToString(command.CommandId))
+	}
+	internal.Infof("%s", internal.CloudWatchConsoleURL(region, logGroup))
+}
+
+// displayCommandResults waits for and displays the results of the commands on their instances,
+// returning an error if one failed on any instance or didn't finish before ctx expired
+func displayCommandResults(ctx context.Context, cfg aws.Config, commands []ssmtypes.Command, opts internal.CommandOutputOptions) error {
+	fmt.Printf("%s\n", color.YellowString("Waiting for command results..."))
 
 	// Create inputs for getting command results
 	var invocationInputs []*ssm.GetCommandInvocationInput
-	for _, instanceID := range sendOutput.Command.InstanceIds {
-		invocationInputs = append(invocationInputs, &ssm.GetCommandInvocationInput{
-			CommandId:  sendOutput.Command.CommandId,
-			InstanceId: aws.String(instanceID),
-		})
+	for _, command := range commands {
+		for _, instanceID := range command.InstanceIds {
+			invocationInputs = append(invocationInputs, &ssm.GetCommandInvocationInput{
+				CommandId:  command.CommandId,
+				InstanceId: aws.String(instanceID),
+			})
+		}
 	}
 
 	// Display command results
-	internal.PrintCommandInvocation(ctx, *credential.awsConfig, invocationInputs)
+	return internal.PrintCommandInvocation(ctx, cfg, invocationInputs, opts)
+}
+
+// sendToInstances asks for or finds the target instances and sends the command to them,
+// returning the commands sent to each region
+func sendToInstances(ctx context.Context, execCommand string, runOpts internal.RunCommandOptions) (map[string][]ssmtypes.Command, error) {
+	// Find target instances
+	targets, err := findTargetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Display command information and make sure a broad selection is intended
+	displayCommandInfo(execCommand, targets)
+	if err := confirmTargets(execCommand, len(targets)); err != nil {
+		return nil, err
+	}
+
+	// Send the command to the targets in each region
+	sent := make(map[string][]ssmtypes.Command)
+	for region, regionTargets := range groupTargetsByRegion(targets) {
+		cfg := credential.awsConfig.Copy()
+		cfg.Region = region
+
+		commands, err := internal.SendCommand(ctx, cfg, regionTargets, execCommand, runOpts)
+		sent[region] = commands
+		if err != nil {
+			return sent, err
+		}
+		printCloudWatchLocation(cfg.Region, runOpts, commands)
+	}
+	return sent, nil
+}
+
+// sendToSSMTargets sends the command to the instances matching the --ssm-target specs in each
+// region, which SSM resolves without instances being discovered first
+func sendToSSMTargets(ctx context.Context, execCommand string, specs []string, runOpts internal.RunCommandOptions) (map[string][]ssmtypes.Command, error) {
+	targets, err := internal.ParseSSMTargets(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	regions := credential.awsRegions
+	if len(regions) == 0 {
+		regions = []string{credential.awsConfig.Region}
+	}
+	internal.PrintReady(execCommand, strings.Join(regions, ", "), strings.Join(specs, " and "))
+
+	// How many instances match isn't known until SSM resolved the targets
+	if !viper.GetBool("cmd-yes") {
+		return nil, fmt.Errorf("%w: --ssm-target runs on every matching instance, which needs --yes", internal.ErrNotConfirmed)
+	}
+
+	sent := make(map[string][]ssmtypes.Command)
+	for _, region := range regions {
+		cfg := credential.awsConfig.Copy()
+		cfg.Region = region
+
+		command, err := internal.SendCommandToSSMTargets(ctx, cfg, targets, execCommand, runOpts)
+		if err != nil {
+			return sent, err
+		}
+		sent[region] = []ssmtypes.Command{command}
+		printCloudWatchLocation(cfg.Region, runOpts, sent[region])
+	}
+	return sent, nil
+}
+
+// resolveCommandInstances fills in the instances of commands sent with --ssm-target,
+// once SSM resolved their targets
+func resolveCommandInstances(ctx context.Context, cfg aws.Config, commands []ssmtypes.Command) error {
+	for i, command := range commands {
+		if len(command.InstanceIds) > 0 {
+			continue
+		}
+		instanceIDs, err := internal.WaitForCommandInstances(ctx, cfg, aws.ToString(command.CommandId))
+		if err != nil {
+			return fmt.Errorf("%s: %w", cfg.Region, err)
+		}
+		commands[i].InstanceIds = instanceIDs
+	}
+	return nil
+}
+
+// printCommandIDs prints the IDs of the commands sent to each region
+func printCommandIDs(sent map[string][]ssmtypes.Command) {
+	for _, region := range slices.Sorted(maps.Keys(sent)) {
+		for _, command := range sent[region] {
+			fmt.Printf("Command ID: %s (%s)\n", aws.ToString(command.CommandId), region)
+		}
+	}
+}
+
+// withCommandTimeout returns a context that expires after the timeout, or ctx itself for 0
+func withCommandTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
 }
 
 // runCommand executes the SSM Run Command operation
 func runCommand(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	ctx, stop := newSignalContext()
+	defer stop()
 
 	// Get the command to execute
 	execCommand := strings.TrimSpace(viper.GetString("cmd-exec"))
 	if execCommand == "" {
 		logErrorAndExit(fmt.Errorf("command execution failed: no command specified"))
 	}
-
-	// Find target instances
-	targets, err := findTargetInstances(ctx)
-	if err != nil {
-		logErrorAndExit(err)
+	if concurrency := viper.GetInt("cmd-concurrency"); concurrency < 1 {
+		logErrorAndExit(fmt.Errorf("invalid concurrency %d: must be at least 1", concurrency))
+	}
+	if timeout := viper.GetDuration("cmd-timeout"); timeout < 0 {
+		logErrorAndExit(fmt.Errorf("invalid timeout %s: must not be negative", timeout))
+	}
+	wait := viper.GetBool("cmd-wait")
+	if !wait && (viper.GetString("cmd-output-dir") != "" || viper.GetBool("cmd-files-only")) {
+		logErrorAndExit(fmt.Errorf("--output-dir and --files-only need the results, pass --wait"))
+	}
+	if !wait && cmd.Flags().Changed("timeout") {
+		logErrorAndExit(fmt.Errorf("--timeout limits how long --wait waits, pass --wait"))
 	}
 
-	// Display command information
-	displayCommandInfo(execCommand, targets)
+	// Create the output directory before anything runs, so results aren't lost
+	if outputDir := viper.GetString("cmd-output-dir"); outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0700); err != nil {
+			logErrorAndExit(fmt.Errorf("failed to create output directory: %w", err))
+		}
+	} else if viper.GetBool("cmd-files-only") {
+		logErrorAndExit(fmt.Errorf("--files-only requires --output-dir"))
+	}
 
-	// Send the command to the targets
-	sendOutput, err := internal.SendCommand(ctx, *credential.awsConfig, targets, execCommand)
+	// Send the command to the chosen instances, or let SSM resolve the targets
+	runOpts := internal.RunCommandOptions{
+		DocumentName:       viper.GetString("cmd-document"),
+		CommandParameter:   viper.GetString("cmd-document-parameter"),
+		CloudWatchLogGroup: viper.GetString("cmd-cloudwatch-log-group"),
+		CloudWatchDisabled: viper.GetBool("cmd-no-cloudwatch"),
+		OutputS3Bucket:     viper.GetString("cmd-output-s3-bucket"),
+	}
+	var sent map[string][]ssmtypes.Command
+	var err error
+	if specs := viper.GetStringSlice("cmd-ssm-target"); len(specs) > 0 {
+		sent, err = sendToSSMTargets(ctx, execCommand, specs, runOpts)
+	} else {
+		sent, err = sendToInstances(ctx, execCommand, runOpts)
+	}
 	if err != nil {
+		// Commands that were sent keep running, so point at their results
+		printCommandIDs(sent)
 		logErrorAndExit(err)
 	}
 
-	// Wait for and display command results
-	displayCommandResults(ctx, sendOutput)
+	// Leave the commands running for asynchronous workflows unless asked to wait
+	if !wait {
+		printCommandIDs(sent)
+		internal.Infof("Check the results with gossm cmd-status --command-id <id> -r <region>")
+		return
+	}
+
+	// Wait for and display command results, all regions sharing the deadline
+	ctx, cancel := withCommandTimeout(ctx, viper.GetDuration("cmd-timeout"))
+	defer cancel()
+
+	outputOpts := internal.CommandOutputOptions{
+		Concurrency: viper.GetInt("cmd-concurrency"),
+		OutputDir:   viper.GetString("cmd-output-dir"),
+		FilesOnly:   viper.GetBool("cmd-files-only"),
+	}
+	var errs []error
+	for _, region := range slices.Sorted(maps.Keys(sent)) {
+		cfg := credential.awsConfig.Copy()
+		cfg.Region = region
+		if err := resolveCommandInstances(ctx, cfg, sent[region]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := displayCommandResults(ctx, cfg, sent[region], outputOpts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		logErrorAndExit(errors.Join(errs...))
+	}
 }
 
 func init() {
 	// Define command flags
 	cmdCommand.Flags().StringP("exec", "e", "", "Command to execute on the target instances (required)")
 	cmdCommand.Flags().StringP("target", "t", "", "Target EC2 instance name (optional, will prompt if not specified)")
+	cmdCommand.Flags().String("group-by", "", "Group the instances to choose from by the value of this tag (e.g. Role), each group selectable as a whole")
+	cmdCommand.Flags().Bool("all-targets", false, "Run on every discovered instance, asking for confirmation unless --yes is passed")
+	cmdCommand.Flags().String("targets-file", "", "Read target instance IDs or Name tags from this file, one per line (- for stdin)")
+	cmdCommand.Flags().StringArray("ssm-target", nil, "Let SSM resolve the instances matching Key=Value[,Value...], e.g. tag:Env=prod, without discovering them (repeatable, all must match)")
+	cmdCommand.Flags().Int("concurrency", defaultCommandConcurrency, "Maximum number of instances whose results are polled at once")
+	cmdCommand.Flags().String("output-dir", "", "Also write each instance's output to <instance-id>.stdout and .stderr in this directory")
+	cmdCommand.Flags().Bool("files-only", false, "With --output-dir, only write output to files instead of printing it")
+	cmdCommand.Flags().String("document", "", "Run Command document to run the command with (default: AWS-RunShellScript)")
+	cmdCommand.Flags().String("document-parameter", "", "Parameter of the --document that receives the command (default: commands)")
+	cmdCommand.Flags().String("cloudwatch-log-group", "", "CloudWatch log group receiving the command output (default: /aws/ssm/<document>)")
+	cmdCommand.Flags().Bool("no-cloudwatch", false, "Don't send the command output to CloudWatch Logs")
+	cmdCommand.Flags().String("output-s3-bucket", "", "S3 bucket receiving the full command output, which SSM otherwise truncates at 24,000 characters")
+	cmdCommand.Flags().BoolP("yes", "y", false, "Run on any number of instances without asking for confirmation")
+	cmdCommand.Flags().Int("confirm-threshold", defaultConfirmThreshold, "Ask for confirmation when running on more instances than this")
+	cmdCommand.Flags().Bool("wait", false, "Wait for the command to finish on every instance and print the results, instead of returning the command IDs right away")
+	cmdCommand.Flags().Duration("timeout", defaultCommandTimeout, "With --wait, stop waiting after this long, failing if the command is still running (0 waits indefinitely)")
 
 	// Mark required flags
 	cmdCommand.MarkFlagRequired("exec")
+	cmdCommand.MarkFlagsMutuallyExclusive("target", "targets-file", "ssm-target", "all-targets")
+	cmdCommand.MarkFlagsMutuallyExclusive("group-by", "ssm-target", "all-targets")
+	cmdCommand.MarkFlagsMutuallyExclusive("cloudwatch-log-group", "no-cloudwatch")
 
 	// Bind flags to viper
 	viper.BindPFlag("cmd-exec", cmdCommand.Flags().Lookup("exec"))
 	viper.BindPFlag("cmd-target", cmdCommand.Flags().Lookup("target"))
+	viper.BindPFlag("cmd-group-by", cmdCommand.Flags().Lookup("group-by"))
+	viper.BindPFlag("cmd-all-targets", cmdCommand.Flags().Lookup("all-targets"))
+	viper.BindPFlag("cmd-targets-file", cmdCommand.Flags().Lookup("targets-file"))
+	viper.BindPFlag("cmd-ssm-target", cmdCommand.Flags().Lookup("ssm-target"))
+	viper.BindPFlag("cmd-concurrency", cmdCommand.Flags().Lookup("concurrency"))
+	viper.BindPFlag("cmd-output-dir", cmdCommand.Flags().Lookup("output-dir"))
+	viper.BindPFlag("cmd-files-only", cmdCommand.Flags().Lookup("files-only"))
+	viper.BindPFlag("cmd-document", cmdCommand.Flags().Lookup("document"))
+	viper.BindPFlag("cmd-document-parameter", cmdCommand.Flags().Lookup("document-parameter"))
+	viper.BindPFlag("cmd-cloudwatch-log-group", cmdCommand.Flags().Lookup("cloudwatch-log-group"))
+	viper.BindPFlag("cmd-no-cloudwatch", cmdCommand.Flags().Lookup("no-cloudwatch"))
+	viper.BindPFlag("cmd-output-s3-bucket", cmdCommand.Flags().Lookup("output-s3-bucket"))
+	viper.BindPFlag("cmd-yes", cmdCommand.Flags().Lookup("yes"))
+	viper.BindPFlag("cmd-confirm-threshold", cmdCommand.Flags().Lookup("confirm-threshold"))
+	viper.BindPFlag("cmd-wait", cmdCommand.Flags().Lookup("wait"))
+	viper.BindPFlag("cmd-timeout", cmdCommand.Flags().Lookup("timeout"))
 
 	// Add command to root
 	rootCmd.AddCommand(cmdCommand)
@@ -1 +1,93 @@
 package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+func TestReadTargetNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	if err := os.WriteFile(path, []byte("# web fleet\ni-0aaaaaaaaaaaaaaa1\n\n  web  \ni-0aaaaaaaaaaaaaaa1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := readTargetNames(path)
+	if err != nil {
+		t.Fatalf("readTargetNames() error = %v", err)
+	}
+	if want := []string{"i-0aaaaaaaaaaaaaaa1", "web"}; !slices.Equal(names, want) {
+		t.Errorf("readTargetNames() = %q, want %q", names, want)
+	}
+}
+
+func TestMatchTargets(t *testing.T) {
+	web1 := &internal.Target{Name: "i-0aaaaaaaaaaaaaaa1", InstanceName: "web"}
+	web2 := &internal.Target{Name: "i-0bbbbbbbbbbbbbbb2", InstanceName: "web"}
+	db := &internal.Target{Name: "i-0ccccccccccccccc3", InstanceName: "db"}
+	instances := map[string]*internal.Target{"web (1)": web1, "web (2)": web2, "db": db}
+
+	tests := []struct {
+		name    string
+		names   []string
+		want    []*internal.Target
+		wantErr bool
+	}{
+		{name: "instance ID", names: []string{"i-0ccccccccccccccc3"}, want: []*internal.Target{db}},
+		{name: "shared Name tag", names: []string{"web"}, want: []*internal.Target{web1, web2}},
+		{name: "ID and Name of the same instance", names: []string{"db", "i-0ccccccccccccccc3"}, want: []*internal.Target{db}},
+		{name: "unknown target", names: []string{"db", "cache"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchTargets(instances, tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("matchTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matchTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfirmTargetsAllTargets(t *testing.T) {
+	// Without a terminal to ask on, anything needing confirmation fails
+	stdin := os.Stdin
+	os.Stdin, _ = os.Open(os.DevNull)
+	t.Cleanup(func() {
+		os.Stdin.Close()
+		os.Stdin = stdin
+		viper.Set("cmd-all-targets", false)
+		viper.Set("cmd-yes", false)
+	})
+	viper.Set("cmd-confirm-threshold", defaultConfirmThreshold)
+
+	tests := []struct {
+		allTargets bool
+		yes        bool
+		count      int
+		wantErr    bool
+	}{
+		{count: 2},
+		{count: 6, wantErr: true},
+		{allTargets: true, count: 2, wantErr: true},
+		{allTargets: true, yes: true, count: 200},
+	}
+
+	for _, tt := range tests {
+		viper.Set("cmd-all-targets", tt.allTargets)
+		viper.Set("cmd-yes", tt.yes)
+		err := confirmTargets("uptime", tt.count)
+		if (err != nil) != tt.wantErr || err != nil && !errors.Is(err, internal.ErrNotConfirmed) {
+			t.Errorf("confirmTargets(all=%v, yes=%v, %d) error = %v, want error %v", tt.allTargets, tt.yes, tt.count, err, tt.wantErr)
+		}
+	}
+}
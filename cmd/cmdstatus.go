@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+var (
+	// cmdStatusCommand is the Cobra command for showing the results of a previously sent command
+	cmdStatusCommand = &cobra.Command{
+		Use:   "cmd-status",
+		Short: "Show the results of a previously sent Run Command",
+		Long: `Show the results of a command sent with gossm cmd, for example without --wait or from a
+session that was interrupted. Instances still running the command are waited for, up to --timeout.
+
+Command IDs are regional, so pass the region the command was sent in.
+
+Example:
+  gossm cmd-status --command-id 0b5bdf6e-1234-4d2f-9c4e-7f3a8e0d1c2b -r eu-west-1
+`,
+		Args: cobra.NoArgs,
+		Run:  runCommandStatus,
+	}
+)
+
+// runCommandStatus prints the results of a command on every instance it was sent to
+func runCommandStatus(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	commandID := strings.TrimSpace(viper.GetString("cmd-status-command-id"))
+	if commandID == "" {
+		logErrorAndExit(fmt.Errorf("no command ID specified"))
+	}
+	if concurrency := viper.GetInt("cmd-status-concurrency"); concurrency < 1 {
+		logErrorAndExit(fmt.Errorf("invalid concurrency %d: must be at least 1", concurrency))
+	}
+	if timeout := viper.GetDuration("cmd-status-timeout"); timeout < 0 {
+		logErrorAndExit(fmt.Errorf("invalid timeout %s: must not be negative", timeout))
+	}
+
+	instanceIDs, err := internal.ListCommandInstances(ctx, *credential.awsConfig, commandID)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	ctx, cancel := withCommandTimeout(ctx, viper.GetDuration("cmd-status-timeout"))
+	defer cancel()
+
+	commands := []ssmtypes.Command{{CommandId: aws.String(commandID), InstanceIds: instanceIDs}}
+	if err := displayCommandResults(ctx, *credential.awsConfig, commands, internal.CommandOutputOptions{
+		Concurrency: viper.GetInt("cmd-status-concurrency"),
+	}); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+func init() {
+	// Define command flags
+	cmdStatusCommand.Flags().String("command-id", "", "ID of the command to show the results of (required)")
+	cmdStatusCommand.Flags().Duration("timeout", defaultCommandTimeout, "Stop waiting for instances still running the command after this long (0 waits indefinitely)")
+	cmdStatusCommand.Flags().Int("concurrency", defaultCommandConcurrency, "Maximum number of instances whose results are polled at once")
+
+	// Mark required flags
+	cmdStatusCommand.MarkFlagRequired("command-id")
+
+	// Bind flags to viper
+	viper.BindPFlag("cmd-status-command-id", cmdStatusCommand.Flags().Lookup("command-id"))
+	viper.BindPFlag("cmd-status-timeout", cmdStatusCommand.Flags().Lookup("timeout"))
+	viper.BindPFlag("cmd-status-concurrency", cmdStatusCommand.Flags().Lookup("concurrency"))
+
+	// Add command to root
+	rootCmd.AddCommand(cmdStatusCommand)
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+const (
+	// pluginCheckTimeout bounds how long the plugin may take to report its version
+	pluginCheckTimeout = 10 * time.Second
+
+	// doctorInstanceResults is the smallest page DescribeInstanceInformation accepts
+	doctorInstanceResults = 5
+)
+
+var (
+	// doctorCommand is the Cobra command for diagnosing setup problems
+	doctorCommand = &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose plugin, credential and permission problems",
+		Long: `Check that gossm is set up correctly and report the result of each step.
+
+The checks cover the session-manager-plugin, AWS credentials for the selected profile,
+region resolution, the caller identity (sts:GetCallerIdentity) and the
+ssm:DescribeInstanceInformation permission. Each failure comes with a remediation hint.
+The command exits non-zero if any critical check fails.
+
+Example:
+  gossm doctor
+  gossm doctor -p production -r eu-west-1
+`,
+		Args: cobra.NoArgs,
+		Run:  runDoctor,
+	}
+)
+
+// doctorReport prints check results and remembers whether a critical check failed
+type doctorReport struct {
+	failed bool
+}
+
+// pass reports a successful check
+func (r *doctorReport) pass(name, detail string) {
+	fmt.Printf("%s %s: %s\n", color.GreenString("[pass]"), name, detail)
+}
+
+// warn reports a non-critical problem
+func (r *doctorReport) warn(name, detail, hint string) {
+	fmt.Printf("%s %s: %s\n", color.YellowString("[warn]"), name, detail)
+	if hint != "" {
+		fmt.Printf("  hint: %s\n", hint)
+	}
+}
+
+// fail reports a failed critical check, with a hint for known AWS errors or the given fallback
+func (r *doctorReport) fail(name string, err error, fallbackHint string) {
+	r.failed = true
+
+	err = internal.TranslateError(err)
+	fmt.Printf("%s %s: %v\n", color.RedString("[fail]"), name, err)
+
+	var hintErr *internal.HintError
+	if fallbackHint != "" && !errors.As(err, &hintErr) {
+		fmt.Printf("  hint: %s\n", fallbackHint)
+	}
+}
+
+// skip reports a check that could not run because an earlier one failed
+func (r *doctorReport) skip(name, reason string) {
+	fmt.Printf("%s %s: %s\n", color.HiBlackString("[skip]"), name, reason)
+}
+
+// runDoctor runs the setup checks in order
+func runDoctor(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	report := &doctorReport{}
+
+	checkPlugin(ctx, report)
+	awsConfig := checkCredentials(report)
+
+	if awsConfig == nil {
+		report.skip("region", "requires AWS credentials")
+		report.skip("caller identity", "requires AWS credentials")
+		report.skip("ssm permissions", "requires AWS credentials")
+	} else {
+		checkRegion(awsConfig, report)
+		checkCallerIdentity(ctx, *awsConfig, report)
+		checkSSMPermissions(ctx, *awsConfig, report)
+	}
+
+	if report.failed {
+		os.Exit(1)
+	}
+}
+
+// checkPlugin installs the session-manager-plugin if needed and verifies that it runs
+func checkPlugin(ctx context.Context, report *doctorReport) {
+	const name = "session-manager-plugin"
+	const hint = "check network access for the plugin download, or pin a version with GOSSM_PLUGIN_VERSION"
+
+	if err := installSsmPlugin(); err != nil {
+		report.fail(name, err, hint)
+		return
+	}
+
+	if err := internal.ValidatePlugin(credential.ssmPluginPath); err != nil {
+		report.fail(name, err, fmt.Sprintf("remove %s so gossm reinstalls it", credential.ssmPluginPath))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pluginCheckTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, credential.ssmPluginPath, "--version").Output()
+	if err != nil {
+		report.fail(name, fmt.Errorf("plugin at %s does not run: %w", credential.ssmPluginPath, err),
+			fmt.Sprintf("remove %s so gossm reinstalls it", credential.ssmPluginPath))
+		return
+	}
+
+	report.pass(name, fmt.Sprintf("version %s (%s)", strings.TrimSpace(string(output)), credential.ssmPluginPath))
+}
+
+// checkCredentials loads the AWS configuration for the profile and retrieves credentials
+func checkCredentials(report *doctorReport) *aws.Config {
+	const name = "credentials"
+
+	// A region list or "all" is resolved later, per command
+	region := getAWSRegion()
+	if isMultiRegion(region) {
+		region = ""
+	}
+
+	// Check the credentials other commands use
+	if awsSharedFile("credentials-file") == "" && useMFACredentialsFile(credentialWithMFA) {
+		internal.Infof("[Use] gossm default mfa credential file %s", credentialWithMFA)
+	}
+
+	awsConfig, creds, err := loadAWSConfig(credential.awsProfile, region)
+	if err != nil {
+		report.fail(name, err, fmt.Sprintf("configure credentials for profile '%s' (e.g. aws configure --profile %s), or select another profile with --profile",
+			credential.awsProfile, credential.awsProfile))
+		return nil
+	}
+
+	report.pass(name, fmt.Sprintf("profile '%s' (source: %s)", credential.awsProfile, creds.Source))
+	return awsConfig
+}
+
+// checkRegion reports which region commands will use. A missing region is not fatal,
+// as commands prompt for one, so the checks that follow fall back to a default region.
+func checkRegion(awsConfig *aws.Config, report *doctorReport) {
+	const name = "region"
+
+	if region := getAWSRegion(); isMultiRegion(region) {
+		report.pass(name, fmt.Sprintf("multiple regions (%s)", region))
+	} else if awsConfig.Region != "" {
+		report.pass(name, awsConfig.Region)
+		return
+	} else {
+		report.warn(name, "no region configured, commands will prompt for one",
+			"pass --region, set GOSSM_REGION, or set a region for the profile in ~/.aws/config")
+	}
+
+	awsConfig.Region = defaultDiscoveryRegion
+}
+
+// checkCallerIdentity verifies the credentials with sts:GetCallerIdentity
+func checkCallerIdentity(ctx context.Context, awsConfig aws.Config, report *doctorReport) {
+	const name = "caller identity"
+
+	identity, err := sts.NewFromConfig(awsConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		report.fail(name, err, "")
+		return
+	}
+
+	report.pass(name, aws.ToString(identity.Arn))
+}
+
+// checkSSMPermissions verifies that managed instances can be listed
+func checkSSMPermissions(ctx context.Context, awsConfig aws.Config, report *doctorReport) {
+	const name = "ssm permissions"
+
+	output, err := ssm.NewFromConfig(awsConfig).DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+		MaxResults: aws.Int32(doctorInstanceResults),
+	})
+	if err != nil {
+		report.fail(name, err, "")
+		return
+	}
+
+	if len(output.InstanceInformationList) == 0 {
+		report.warn(name, fmt.Sprintf("ssm:DescribeInstanceInformation allowed, but no managed instances in %s", awsConfig.Region),
+			"make sure the SSM agent is running on your instances and they have an instance profile with AmazonSSMManagedInstanceCore")
+		return
+	}
+
+	report.pass(name, fmt.Sprintf("ssm:DescribeInstanceInformation allowed, managed instances found in %s", awsConfig.Region))
+}
+
+func init() {
+	// Add command to root
+	rootCmd.AddCommand(doctorCommand)
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+const (
+	// defaultECSCommand is the command run in the container when none is given
+	defaultECSCommand = "/bin/sh"
+)
+
+var (
+	// ecsCommand is the Cobra command for starting an ECS Exec session
+	ecsCommand = &cobra.Command{
+		Use:   "ecs",
+		Short: "Start an interactive session in an ECS container",
+		Long: `Start an interactive session in a running ECS container using ECS Exec.
+
+Clusters, tasks and containers are listed for selection unless narrowed down with flags.
+Only tasks with ECS Exec enabled (--enable-execute-command) and a running exec agent are shown.
+
+Escape Sequences:
+  Enter ~.   Disconnect from the session (useful when network is stuck)
+  Enter ~#   Show the session ID, target and region
+  Enter ~?   List the supported escape sequences
+
+Example:
+  gossm ecs                                  # Interactive cluster and container selection
+  gossm ecs -c production --container app    # Select among the app containers of a cluster
+  gossm ecs -c production --task 0123abcd --command "bash -l"
+`,
+		Args:             cobra.NoArgs,
+		PersistentPreRun: setupPlugin,
+		Run:              runECSExec,
+	}
+)
+
+// runECSExec starts an ECS Exec session in the selected container
+func runECSExec(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
+
+	// Get target container
+	container, err := getECSContainer(ctx)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+	target := container.Target()
+
+	// Display information
+	internal.PrintReady("ecs", credential.awsConfig.Region, target)
+
+	// Start session
+	session, err := internal.ExecuteECSCommand(ctx, *credential.awsConfig, container, viper.GetString("ecs-command"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
+	trackSession(session.SessionId, target)
+
+	// Execute session
+	if err := executeECSSession(session, target); err != nil {
+		color.Red("%v", internal.TranslateError(err))
+	}
+
+	// Clean up
+	if err := terminateSession(ctx, session.SessionId); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+// getECSContainer selects the container from the flags, prompting for anything they leave open
+func getECSContainer(ctx context.Context) (*internal.ECSContainer, error) {
+	cluster := viper.GetString("ecs-cluster")
+	if cluster == "" {
+		clusters, err := internal.ListECSClusters(ctx, *credential.awsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the prompt if there is nothing to choose from
+		if len(clusters) == 1 {
+			cluster = clusters[0]
+		} else if cluster, err = internal.AskECSCluster(clusters); err != nil {
+			return nil, err
+		}
+	}
+
+	containers, err := internal.FindECSContainers(ctx, *credential.awsConfig, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	// Narrow down by task and container name
+	task, name := viper.GetString("ecs-task"), viper.GetString("ecs-container")
+	if task != "" || name != "" {
+		containers = filterECSContainers(containers, task, name)
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("%w in cluster '%s' matching the task and container", internal.ErrNoContainers, cluster)
+		}
+		if len(containers) == 1 {
+			return containers[0], nil
+		}
+	}
+
+	return internal.AskECSContainer(containers)
+}
+
+// filterECSContainers returns the containers matching the task ID or ARN and the container name.
+// An empty value matches everything.
+func filterECSContainers(containers []*internal.ECSContainer, task, name string) []*internal.ECSContainer {
+	// Accept a full task ARN as well as the ID
+	task = task[strings.LastIndex(task, "/")+1:]
+
+	var matches []*internal.ECSContainer
+	for _, container := range containers {
+		if (task == "" || container.TaskID == task) && (name == "" || container.Name == name) {
+			matches = append(matches, container)
+		}
+	}
+	return matches
+}
+
+// executeECSSession connects to the ECS Exec session using the SSM plugin
+func executeECSSession(session *ecstypes.Session, target string) error {
+	// Marshal session to JSON
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	// The plugin only needs the target for ECS sessions
+	paramsJSON, err := json.Marshal(map[string]string{"Target": target})
+	if err != nil {
+		return fmt.Errorf("failed to marshal session parameters: %w", err)
+	}
+
+	// Execute the session
+	return internal.CallProcess(
+		pluginProcessOptions(session.SessionId, target),
+		credential.ssmPluginPath,
+		string(sessionJSON),
+		credential.awsConfig.Region,
+		"StartSession",
+		credential.awsProfile,
+		string(paramsJSON),
+	)
+}
+
+func init() {
+	// Define command flags
+	ecsCommand.Flags().StringP("cluster", "c", "", "ECS cluster name (default: interactive selection)")
+	ecsCommand.Flags().String("task", "", "Task ID or ARN")
+	ecsCommand.Flags().String("container", "", "Container name")
+	ecsCommand.Flags().String("command", defaultECSCommand, "Command to run in the container")
+
+	// Bind flags to viper
+	viper.BindPFlag("ecs-cluster", ecsCommand.Flags().Lookup("cluster"))
+	viper.BindPFlag("ecs-task", ecsCommand.Flags().Lookup("task"))
+	viper.BindPFlag("ecs-container", ecsCommand.Flags().Lookup("container"))
+	viper.BindPFlag("ecs-command", ecsCommand.Flags().Lookup("command"))
+
+	// Add command to root
+	rootCmd.AddCommand(ecsCommand)
+}
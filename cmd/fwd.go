@@ -23,16 +23,21 @@ const (
 var (
 	// fwdCommand is the Cobra command for SSM port forwarding
 	fwdCommand = &cobra.Command{
-		Use:   "fwd",
-		Short: "Forward ports from local machine to remote AWS instances",
-		Long:  "Create port forwarding tunnels from your local machine to AWS instances using AWS Systems Manager",
-		Run:   runPortForwarding,
+		Use:              "fwd",
+		Short:            "Forward ports from local machine to remote AWS instances",
+		Long:             "Create port forwarding tunnels from your local machine to AWS instances using AWS Systems Manager",
+		PersistentPreRun: setupPlugin,
+		Run:              runPortForwarding,
 	}
 )
 
 // runPortForwarding executes the port forwarding operation
 func runPortForwarding(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
 
 	// Get target instance
 	target, err := getTargetInstance(ctx)
@@ -61,6 +66,11 @@ func runPortForwarding(cmd *cobra.Command, args []string) {
 
 // getTargetInstance retrieves the target instance for port forwarding
 func getTargetInstance(ctx context.Context) (*internal.Target, error) {
+	// An instance ID skips discovery entirely
+	if target, err := instanceIDTarget("fwd-instance-id"); err != nil || target != nil {
+		return target, err
+	}
+
 	// Check if target was specified via command line
 	argTarget := strings.TrimSpace(viper.GetString("fwd-target"))
 	if argTarget != "" {
@@ -68,18 +78,74 @@ func getTargetInstance(ctx context.Context) (*internal.Target, error) {
 	}
 
 	// If no target specified, prompt user to select
-	return internal.AskTarget(ctx, *credential.awsConfig)
+	return askTarget(ctx)
+}
+
+// askTarget discovers instances and prompts the user to select one, unless the default target
+// matches one of them
+func askTarget(ctx context.Context) (*internal.Target, error) {
+	stopSpinner := internal.StartSpinner("Discovering instances")
+	instances, err := findInstances(ctx)
+	stopSpinner()
+	if err != nil {
+		return nil, err
+	}
+
+	if target := defaultTarget(instances); target != nil {
+		useTargetRegion(target)
+		return target, nil
+	}
+
+	// A --target-regex matching a single instance selects it
+	if viper.GetString("target-regex") != "" && len(instances) == 1 {
+		for _, target := range instances {
+			useTargetRegion(target)
+			return target, nil
+		}
+	}
+
+	target, err := internal.AskTarget(instances)
+	if err != nil {
+		return nil, err
+	}
+
+	useTargetRegion(target)
+	return target, nil
+}
+
+// defaultTarget returns the instance matching the default target (GOSSM_DEFAULT_TARGET or the
+// default-target config key) by ID or Name tag. It returns nil if no default target is set or it
+// doesn't match exactly one instance, so the user is asked instead.
+func defaultTarget(instances map[string]*internal.Target) *internal.Target {
+	name := strings.TrimSpace(viper.GetString("default-target"))
+	if name == "" {
+		return nil
+	}
+
+	targets, err := matchTargets(instances, []string{name})
+	if err != nil {
+		internal.Warnf("Default target '%s' not found, choose an instance", name)
+		return nil
+	}
+	if len(targets) > 1 {
+		internal.Warnf("Default target '%s' matches %d instances, choose one", name, len(targets))
+		return nil
+	}
+
+	internal.Debugf("Using default target %s", name)
+	return targets[0]
 }
 
 // findSpecificInstance looks for a specific instance by name
 func findSpecificInstance(ctx context.Context, targetName string) (*internal.Target, error) {
-	instances, err := internal.FindInstances(ctx, *credential.awsConfig)
+	instances, err := findInstances(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find instances: %w", err)
 	}
 
 	for _, instance := range instances {
 		if instance.Name == targetName {
+			useTargetRegion(instance)
 			return instance, nil
 		}
 	}
@@ -111,18 +177,24 @@ func GetPortConfiguration() (localPort, remotePort string, err error) {
 
 // startPortForwardingSession creates and starts an SSM port forwarding session
 func startPortForwardingSession(ctx context.Context, target *internal.Target, localPort, remotePort string) error {
+	// Merge extra session parameters with the forwarding parameters
+	parameters, err := mergeSessionParameters(map[string][]string{
+		"portNumber":      {remotePort},
+		"localPortNumber": {localPort},
+	}, viper.GetStringSlice("fwd-param"))
+	if err != nil {
+		return err
+	}
+
 	// Prepare SSM input for port forwarding
 	sessionInput := &ssm.StartSessionInput{
 		DocumentName: aws.String(documentNamePortForwarding),
-		Parameters: map[string][]string{
-			"portNumber":      {remotePort},
-			"localPortNumber": {localPort},
-		},
-		Target: aws.String(target.Name),
+		Parameters:   parameters,
+		Target:       aws.String(target.Name),
 	}
 
 	// Create the session
-	session, err := internal.CreateStartSession(ctx, *credential.awsConfig, sessionInput)
+	session, err := startSession(ctx, sessionInput)
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -140,6 +212,7 @@ func startPortForwardingSession(ctx context.Context, target *internal.Target, lo
 
 	// Call the SSM plugin to start the port forwarding
 	if err := internal.CallProcess(
+		pluginProcessOptions(session.SessionId, target.Name),
 		credential.ssmPluginPath,
 		string(sessionJSON),
 		credential.awsConfig.Region,
@@ -147,13 +220,11 @@ func startPortForwardingSession(ctx context.Context, target *internal.Target, lo
 		credential.awsProfile,
 		string(paramsJSON),
 	); err != nil {
-		color.Red("[err] %v", err.Error())
+		color.Red("[err] %v", internal.TranslateError(err))
 	}
 
 	// Clean up by terminating the session
-	if err := internal.DeleteStartSession(ctx, *credential.awsConfig, &ssm.TerminateSessionInput{
-		SessionId: session.SessionId,
-	}); err != nil {
+	if err := terminateSession(ctx, session.SessionId); err != nil {
 		return fmt.Errorf("failed to terminate session: %w", err)
 	}
 
@@ -165,11 +236,17 @@ func init() {
 	fwdCommand.Flags().StringP("remote", "z", "", "Remote port to forward to (e.g., 8080)")
 	fwdCommand.Flags().StringP("local", "l", "", "Local port to use (defaults to remote port if not specified)")
 	fwdCommand.Flags().StringP("target", "t", "", "Target EC2 instance name (will prompt if not specified)")
+	fwdCommand.Flags().String("instance-id", "", "Connect to this instance ID (i-... or mi-...) without discovering instances")
+	fwdCommand.Flags().StringArray("param", nil, "Extra session document parameter as key=value (repeatable)")
+
+	fwdCommand.MarkFlagsMutuallyExclusive("target", "instance-id")
 
 	// Bind flags to viper
 	viper.BindPFlag("fwd-remote-port", fwdCommand.Flags().Lookup("remote"))
 	viper.BindPFlag("fwd-local-port", fwdCommand.Flags().Lookup("local"))
 	viper.BindPFlag("fwd-target", fwdCommand.Flags().Lookup("target"))
+	viper.BindPFlag("fwd-param", fwdCommand.Flags().Lookup("param"))
+	viper.BindPFlag("fwd-instance-id", fwdCommand.Flags().Lookup("instance-id"))
 
 	// Add command to root
 	rootCmd.AddCommand(fwdCommand)
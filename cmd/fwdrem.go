@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -23,16 +25,21 @@ const (
 var (
 	// fwdremCommand is the Cobra command for SSM port forwarding to a remote host
 	fwdremCommand = &cobra.Command{
-		Use:   "fwdrem",
-		Short: "Forward ports to a remote host through an AWS instance",
-		Long:  "Create port forwarding tunnels to a remote host through an AWS instance using AWS Systems Manager",
-		Run:   runRemotePortForwarding,
+		Use:              "fwdrem",
+		Short:            "Forward ports to a remote host through an AWS instance",
+		Long:             "Create port forwarding tunnels to a remote host through an AWS instance using AWS Systems Manager",
+		PersistentPreRun: setupPlugin,
+		Run:              runRemotePortForwarding,
 	}
 )
 
 // runRemotePortForwarding executes the remote host port forwarding operation
 func runRemotePortForwarding(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
 
 	// Get target instance to proxy through
 	target, err := getProxyInstance(ctx)
@@ -40,14 +47,8 @@ func runRemotePortForwarding(cmd *cobra.Command, args []string) {
 		logErrorAndExit(err)
 	}
 
-	// Get port configuration
-	localPort, remotePort, err := GetPortConfiguration()
-	if err != nil {
-		logErrorAndExit(err)
-	}
-
-	// Get remote host to connect to
-	host, err := getRemoteHost()
+	// Get remote host and port configuration
+	host, localPort, remotePort, err := getRemoteEndpoint()
 	if err != nil {
 		logErrorAndExit(err)
 	}
@@ -67,6 +68,11 @@ func runRemotePortForwarding(cmd *cobra.Command, args []string) {
 
 // getProxyInstance retrieves the target instance to proxy through
 func getProxyInstance(ctx context.Context) (*internal.Target, error) {
+	// An instance ID skips discovery entirely
+	if target, err := instanceIDTarget("fwdrem-instance-id"); err != nil || target != nil {
+		return target, err
+	}
+
 	// Check if target was specified via command line
 	argTarget := strings.TrimSpace(viper.GetString("fwd-target"))
 	if argTarget != "" {
@@ -74,18 +80,19 @@ func getProxyInstance(ctx context.Context) (*internal.Target, error) {
 	}
 
 	// If no target specified, prompt user to select
-	return internal.AskTarget(ctx, *credential.awsConfig)
+	return askTarget(ctx)
 }
 
 // findSpecificProxyInstance looks for a specific instance by name
 func findSpecificProxyInstance(ctx context.Context, targetName string) (*internal.Target, error) {
-	instances, err := internal.FindInstances(ctx, *credential.awsConfig)
+	instances, err := findInstances(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find instances: %w", err)
 	}
 
 	for _, instance := range instances {
 		if instance.Name == targetName {
+			useTargetRegion(instance)
 			return instance, nil
 		}
 	}
@@ -93,6 +100,49 @@ func findSpecificProxyInstance(ctx context.Context, targetName string) (*interna
 	return nil, fmt.Errorf("proxy instance '%s' not found", targetName)
 }
 
+// getRemoteEndpoint determines the remote host and port to connect to and the local port,
+// from --to host:port if given, and otherwise from the separate flags or prompts
+func getRemoteEndpoint() (host, localPort, remotePort string, err error) {
+	to := strings.TrimSpace(viper.GetString("fwdrem-to"))
+	if to == "" {
+		if localPort, remotePort, err = GetPortConfiguration(); err != nil {
+			return "", "", "", err
+		}
+		host, err = getRemoteHost()
+		return host, localPort, remotePort, err
+	}
+
+	if host, remotePort, err = parseHostPort(to); err != nil {
+		return "", "", "", err
+	}
+
+	// Use the same port locally unless specified
+	localPort = strings.TrimSpace(viper.GetString("fwd-local-port"))
+	if localPort == "" {
+		localPort = remotePort
+	}
+
+	return host, localPort, remotePort, nil
+}
+
+// parseHostPort splits a host:port value, such as internal-db:5432 or [fd00::1]:5432,
+// validating both parts
+func parseHostPort(value string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(value)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid remote address '%s' (expected host:port)", value)
+	}
+
+	if host == "" {
+		return "", "", fmt.Errorf("invalid remote address '%s': missing host", value)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid remote address '%s': invalid port '%s'", value, port)
+	}
+
+	return host, port, nil
+}
+
 // getRemoteHost determines the remote host to connect to
 func getRemoteHost() (string, error) {
 	// Check if host was specified via command line
@@ -107,19 +157,25 @@ func getRemoteHost() (string, error) {
 
 // startRemoteHostPortForwardingSession creates and starts an SSM port forwarding session to a remote host
 func startRemoteHostPortForwardingSession(ctx context.Context, target *internal.Target, localPort, remotePort, host string) error {
+	// Merge extra session parameters with the forwarding parameters
+	parameters, err := mergeSessionParameters(map[string][]string{
+		"portNumber":      {remotePort},
+		"localPortNumber": {localPort},
+		"host":            {host},
+	}, viper.GetStringSlice("fwdrem-param"))
+	if err != nil {
+		return err
+	}
+
 	// Prepare SSM input for port forwarding
 	sessionInput := &ssm.StartSessionInput{
 		DocumentName: aws.String(documentNameRemotePortForwarding),
-		Parameters: map[string][]string{
-			"portNumber":      {remotePort},
-			"localPortNumber": {localPort},
-			"host":            {host},
-		},
-		Target: aws.String(target.Name),
+		Parameters:   parameters,
+		Target:       aws.String(target.Name),
 	}
 
 	// Create the session
-	session, err := internal.CreateStartSession(ctx, *credential.awsConfig, sessionInput)
+	session, err := startSession(ctx, sessionInput)
 	if err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
 	}
@@ -137,6 +193,7 @@ func startRemoteHostPortForwardingSession(ctx context.Context, target *internal.
 
 	// Call the SSM plugin to start the port forwarding
 	if err := internal.CallProcess(
+		pluginProcessOptions(session.SessionId, target.Name),
 		credential.ssmPluginPath,
 		string(sessionJSON),
 		credential.awsConfig.Region,
@@ -144,13 +201,11 @@ func startRemoteHostPortForwardingSession(ctx context.Context, target *internal.
 		credential.awsProfile,
 		string(paramsJSON),
 	); err != nil {
-		color.Red("[err] %v", err.Error())
+		color.Red("[err] %v", internal.TranslateError(err))
 	}
 
 	// Clean up by terminating the session
-	if err := internal.DeleteStartSession(ctx, *credential.awsConfig, &ssm.TerminateSessionInput{
-		SessionId: session.SessionId,
-	}); err != nil {
+	if err := terminateSession(ctx, session.SessionId); err != nil {
 		return fmt.Errorf("failed to terminate session: %w", err)
 	}
 
@@ -163,13 +218,24 @@ func init() {
 	fwdremCommand.Flags().StringP("remote", "z", "", "Remote port on the target host to forward to (e.g., 8080)")
 	fwdremCommand.Flags().StringP("local", "l", "", "Local port to use (defaults to remote port if not specified)")
 	fwdremCommand.Flags().StringP("target", "t", "", "AWS EC2 instance to proxy through (will prompt if not specified)")
+	fwdremCommand.Flags().String("instance-id", "", "Proxy through this instance ID (i-... or mi-...) without discovering instances")
+	fwdremCommand.Flags().StringArray("param", nil, "Extra session document parameter as key=value (repeatable)")
 	fwdremCommand.Flags().StringP("host", "a", "", "Remote host address to connect to (e.g., internal-db)")
+	fwdremCommand.Flags().String("to", "", "Remote host and port to connect to as host:port (e.g., internal-db:5432)")
+
+	// --to replaces --host and --remote
+	fwdremCommand.MarkFlagsMutuallyExclusive("to", "host")
+	fwdremCommand.MarkFlagsMutuallyExclusive("to", "remote")
+	fwdremCommand.MarkFlagsMutuallyExclusive("target", "instance-id")
 
 	// Bind flags to viper
 	viper.BindPFlag("fwd-remote-port", fwdremCommand.Flags().Lookup("remote"))
 	viper.BindPFlag("fwd-local-port", fwdremCommand.Flags().Lookup("local"))
 	viper.BindPFlag("fwd-target", fwdremCommand.Flags().Lookup("target"))
+	viper.BindPFlag("fwdrem-param", fwdremCommand.Flags().Lookup("param"))
 	viper.BindPFlag("fwd-host", fwdremCommand.Flags().Lookup("host"))
+	viper.BindPFlag("fwdrem-to", fwdremCommand.Flags().Lookup("to"))
+	viper.BindPFlag("fwdrem-instance-id", fwdremCommand.Flags().Lookup("instance-id"))
 
 	// Add command to root
 	rootCmd.AddCommand(fwdremCommand)
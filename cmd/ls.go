@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+const (
+	// lsOutputTable prints instances as an aligned table
+	lsOutputTable = "table"
+
+	// lsOutputJSON prints instances as a JSON array
+	lsOutputJSON = "json"
+)
+
+var (
+	// lsCommand is the Cobra command for listing SSM-connected instances
+	lsCommand = &cobra.Command{
+		Use:   "ls",
+		Short: "List instances reachable through SSM",
+		Long: `List the running instances with a connected SSM agent, along with their platform,
+instance type and launch time. Discovery honors --region (including lists and "all") and --filter.
+
+Examples:
+  gossm ls                        # Table of instances in the current region
+  gossm ls -r all --output json   # JSON array of instances in every enabled region
+`,
+		Args: cobra.NoArgs,
+		Run:  runListInstances,
+	}
+)
+
+// runListInstances prints the discovered instances in the requested format
+func runListInstances(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	output := viper.GetString("ls-output")
+	if output != lsOutputTable && output != lsOutputJSON {
+		logErrorAndExit(fmt.Errorf("invalid output format '%s' (use %s or %s)", output, lsOutputTable, lsOutputJSON))
+	}
+
+	// An empty list is a valid result here rather than an error
+	instances, err := findInstances(ctx)
+	empty := errors.Is(err, internal.ErrNoInstances) || errors.Is(err, internal.ErrNoMatchingInstances)
+	if err != nil && !empty {
+		logErrorAndExit(err)
+	}
+	targets := sortedTargets(instances)
+
+	if output == lsOutputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(targets); err != nil {
+			logErrorAndExit(internal.WrapError(err))
+		}
+		return
+	}
+
+	if empty {
+		message := err.Error()
+		color.Yellow("%s", strings.ToUpper(message[:1])+message[1:])
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tINSTANCE ID\tREGION\tAZ\tPLATFORM\tTYPE\tLAUNCHED\tPRIVATE DNS")
+	for _, target := range targets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			target.InstanceName,
+			target.Name,
+			target.Region,
+			target.AvailabilityZone,
+			target.PlatformType,
+			target.InstanceType,
+			target.LaunchTime.Local().Format(time.DateTime),
+			target.PrivateDomain,
+		)
+	}
+	w.Flush()
+}
+
+// sortedTargets returns the instances ordered by region, name and instance ID
+func sortedTargets(instances map[string]*internal.Target) []*internal.Target {
+	targets := make([]*internal.Target, 0, len(instances))
+	for _, target := range instances {
+		targets = append(targets, target)
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.InstanceName != b.InstanceName {
+			return a.InstanceName < b.InstanceName
+		}
+		return a.Name < b.Name
+	})
+
+	return targets
+}
+
+func init() {
+	// Define command flags
+	lsCommand.Flags().StringP("output", "o", lsOutputTable,
+		`Output format, "table" or "json"`)
+
+	// Bind flags to viper
+	viper.BindPFlag("ls-output", lsCommand.Flags().Lookup("output"))
+
+	// Add command to root
+	rootCmd.AddCommand(lsCommand)
+}
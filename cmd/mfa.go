@@ -2,16 +2,22 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
 )
 
 const (
@@ -24,8 +30,8 @@ const (
 	// defaultMFADuration is the default duration for MFA credentials in seconds (6 hours)
 	defaultMFADuration = 21600
 
-	// mfaTimeout is the maximum time allowed for MFA operations
-	mfaTimeout = 60 * time.Second
+	// defaultMFATimeout is the default maximum time allowed for each AWS request of the mfa command
+	defaultMFATimeout = 60 * time.Second
 )
 
 var (
@@ -39,25 +45,44 @@ credentials to the file ~/.aws/credentials_mfa.
 You can export AWS_SHARED_CREDENTIALS_FILE environment variable to point to this file
 for convenient use with AWS CLI and other tools that use AWS SDK.
 
+If the token code is not passed as an argument, you will be prompted for it
+with masked input so it never ends up in your shell history.
+
+--export also prints the credentials as shell export statements for tools that only read
+the environment. The output contains the secret key, so evaluate it directly rather than
+copying it into a command line that lands in your shell history.
+
 Example:
-  gossm mfa 123456     # Authenticate with MFA code 123456
+  gossm mfa                          # Prompt for the MFA code
+  gossm mfa 123456                   # Authenticate with MFA code 123456
+  eval "$(gossm mfa --export)"       # Set the credentials in the current shell
 `,
-		Args: cobra.ExactArgs(1),
+		Args: cobra.MaximumNArgs(1),
 		Run:  runMFAAuthentication,
 	}
 )
 
 // runMFAAuthentication executes the MFA authentication process
 func runMFAAuthentication(cmd *cobra.Command, args []string) {
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), mfaTimeout)
-	defer cancel()
+	// Create a context that is canceled on interrupt, each request adds the --timeout
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	if timeout := viper.GetDuration("mfa-timeout"); timeout <= 0 {
+		logErrorAndExit(fmt.Errorf("invalid timeout %s: must be positive", timeout))
+	}
 
 	// Get and validate the MFA code
-	code := strings.TrimSpace(args[0])
+	code, err := getMFACode(args)
+	if err != nil {
+		logErrorAndExit(err)
+	}
 	if code == "" {
 		logErrorAndExit(fmt.Errorf("invalid MFA code: code cannot be empty"))
 	}
+	if err := internal.ValidateMFACode(code); err != nil {
+		logErrorAndExit(fmt.Errorf("invalid MFA code: %w", err))
+	}
 
 	// Get MFA device identifier
 	device, err := getMFADevice(ctx)
@@ -79,10 +104,29 @@ func runMFAAuthentication(cmd *cobra.Command, args []string) {
 		logErrorAndExit(err)
 	}
 
+	// Print the credentials for eval, with the messages on stderr so they aren't evaluated
+	if viper.GetBool("mfa-export") {
+		color.Output = os.Stderr
+		displayMFASuccessMessage(sessionToken.Credentials.Expiration)
+		internal.Warnf("The exports contain your secret key; use eval \"$(gossm mfa --export)\" instead of pasting them so they stay out of your shell history")
+		fmt.Print(credentialExports(sessionToken.Credentials))
+		return
+	}
+
 	// Display success message and instructions
 	displayMFASuccessMessage(sessionToken.Credentials.Expiration)
 }
 
+// getMFACode returns the MFA code from the arguments, prompting for it if absent
+func getMFACode(args []string) (string, error) {
+	if len(args) > 0 {
+		return strings.TrimSpace(args[0]), nil
+	}
+
+	// Prompt with masked input so the code doesn't land in shell history
+	return internal.AskMFACode()
+}
+
 // getMFADevice returns the MFA device ARN to use
 func getMFADevice(ctx context.Context) (string, error) {
 	// Check if device was specified via command line
@@ -91,9 +135,48 @@ func getMFADevice(ctx context.Context) (string, error) {
 		return device, nil
 	}
 
-	// If not specified, get the user's virtual MFA device
+	// Look up the MFA devices registered for the caller
+	client := iam.NewFromConfig(*credential.awsConfig)
+	var output *iam.ListMFADevicesOutput
+	err := withMFATimeout(ctx, func(ctx context.Context) (err error) {
+		output, err = client.ListMFADevices(ctx, &iam.ListMFADevicesInput{})
+		return err
+	})
+	if err != nil {
+		// Without iam:ListMFADevices permission, fall back to the virtual MFA device
+		if isAccessDeniedError(err) {
+			return getVirtualMFADevice(ctx)
+		}
+		return "", fmt.Errorf("failed to list MFA devices: %w", err)
+	}
+
+	// Collect the serial numbers of the registered devices
+	serials := make([]string, 0, len(output.MFADevices))
+	for _, mfaDevice := range output.MFADevices {
+		if mfaDevice.SerialNumber != nil {
+			serials = append(serials, *mfaDevice.SerialNumber)
+		}
+	}
+
+	switch len(serials) {
+	case 0:
+		return "", fmt.Errorf("no MFA devices registered for the current user")
+	case 1:
+		return serials[0], nil
+	default:
+		// Multiple devices registered, let the user choose one
+		return internal.AskMFADevice(serials)
+	}
+}
+
+// getVirtualMFADevice builds the virtual MFA device ARN from the caller identity
+func getVirtualMFADevice(ctx context.Context) (string, error) {
 	client := sts.NewFromConfig(*credential.awsConfig)
-	identity, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	var identity *sts.GetCallerIdentityOutput
+	err := withMFATimeout(ctx, func(ctx context.Context) (err error) {
+		identity, err = client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get identity: %w", err)
 	}
@@ -108,14 +191,44 @@ func getMFADevice(ctx context.Context) (string, error) {
 	return fmt.Sprintf(virtualMFADevice, aws.ToString(identity.Account), username), nil
 }
 
+// withMFATimeout runs an AWS request of the mfa command, canceling it after the --timeout.
+// Prompts run outside of it, so the time spent answering them doesn't count.
+func withMFATimeout(ctx context.Context, request func(ctx context.Context) error) error {
+	timeout := viper.GetDuration("mfa-timeout")
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := request(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", internal.ErrMFATimeout, timeout)
+	}
+	return err
+}
+
+// isAccessDeniedError reports whether err is an AWS access denied API error
+func isAccessDeniedError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "AccessDeniedException":
+			return true
+		}
+	}
+	return false
+}
+
 // getTemporaryCredentials gets temporary credentials using the MFA token
 func getTemporaryCredentials(ctx context.Context, device, code string, duration int32) (*sts.GetSessionTokenOutput, error) {
 	client := sts.NewFromConfig(*credential.awsConfig)
 
-	output, err := client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
-		DurationSeconds: aws.Int32(duration),
-		SerialNumber:    aws.String(device),
-		TokenCode:       aws.String(code),
+	var output *sts.GetSessionTokenOutput
+	err := withMFATimeout(ctx, func(ctx context.Context) (err error) {
+		output, err = client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+			DurationSeconds: aws.Int32(duration),
+			SerialNumber:    aws.String(device),
+			TokenCode:       aws.String(code),
+		})
+		return err
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get session token: %w", err)
@@ -143,12 +256,26 @@ func saveTemporaryCredentials(sessionToken *sts.GetSessionTokenOutput) error {
 	return nil
 }
 
+// credentialExports formats credentials as shell export statements, quoting the values
+func credentialExports(credentials *ststypes.Credentials) string {
+	var exports strings.Builder
+	for _, variable := range []struct{ name, value string }{
+		{"AWS_ACCESS_KEY_ID", aws.ToString(credentials.AccessKeyId)},
+		{"AWS_SECRET_ACCESS_KEY", aws.ToString(credentials.SecretAccessKey)},
+		{"AWS_SESSION_TOKEN", aws.ToString(credentials.SessionToken)},
+		{"AWS_CREDENTIAL_EXPIRATION", aws.ToTime(credentials.Expiration).UTC().Format(time.RFC3339)},
+	} {
+		fmt.Fprintf(&exports, "export %s='%s'\n", variable.name, strings.ReplaceAll(variable.value, "'", `'\''`))
+	}
+	return exports.String()
+}
+
 // displayMFASuccessMessage shows a success message and usage instructions
 func displayMFASuccessMessage(expiration *time.Time) {
 	color.Green("[SUCCESS] Temporary MFA credentials created at %s (expires: %s)",
 		credentialWithMFA, expiration.UTC().Format(time.RFC3339))
 
-	fmt.Printf("%s %s %s\n",
+	fmt.Fprintf(color.Output, "%s %s %s\n",
 		color.YellowString("To use AWS CLI with these credentials, run:"),
 		color.CyanString("export AWS_SHARED_CREDENTIALS_FILE=%s", credentialWithMFA),
 		color.YellowString("or add this to your shell profile."),
@@ -160,11 +287,17 @@ func init() {
 	mfaCommand.Flags().Int32P("deadline", "d", defaultMFADuration,
 		"Duration in seconds for the temporary credentials (default: 6 hours)")
 	mfaCommand.Flags().StringP("device", "m", "",
-		"MFA device ARN (default: your virtual MFA device)")
+		"MFA device ARN or serial number (default: your registered MFA device)")
+	mfaCommand.Flags().Duration("timeout", defaultMFATimeout,
+		"Maximum time for each AWS request, for slow networks")
+	mfaCommand.Flags().Bool("export", false,
+		`Also print the credentials as shell exports, for eval "$(gossm mfa --export)"`)
 
 	// Bind flags to viper
 	viper.BindPFlag("mfa-deadline", mfaCommand.Flags().Lookup("deadline"))
 	viper.BindPFlag("mfa-device", mfaCommand.Flags().Lookup("device"))
+	viper.BindPFlag("mfa-timeout", mfaCommand.Flags().Lookup("timeout"))
+	viper.BindPFlag("mfa-export", mfaCommand.Flags().Lookup("export"))
 
 	// Add command to root
 	rootCmd.AddCommand(mfaCommand)
@@ -1 +1,46 @@
 package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+func TestWithMFATimeout(t *testing.T) {
+	viper.Set("mfa-timeout", 10*time.Millisecond)
+	t.Cleanup(func() { viper.Set("mfa-timeout", defaultMFATimeout) })
+
+	err := withMFATimeout(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, internal.ErrMFATimeout) {
+		t.Errorf("withMFATimeout() error = %v, want %v", err, internal.ErrMFATimeout)
+	}
+}
+
+func TestCredentialExports(t *testing.T) {
+	expiration := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := credentialExports(&ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIAEXAMPLE"),
+		SecretAccessKey: aws.String("se'cret+/="),
+		SessionToken:    aws.String("token"),
+		Expiration:      &expiration,
+	})
+
+	want := `export AWS_ACCESS_KEY_ID='ASIAEXAMPLE'
+export AWS_SECRET_ACCESS_KEY='se'\''cret+/='
+export AWS_SESSION_TOKEN='token'
+export AWS_CREDENTIAL_EXPIRATION='2026-01-02T03:04:05Z'
+`
+	if got != want {
+		t.Errorf("credentialExports() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+// multiSessionSkippedFlags are the flags not passed on to the session of each target, as the
+// target, profile and region are set explicitly and the others only apply to this process
+var multiSessionSkippedFlags = map[string]bool{
+	"multi":          true,
+	"target":         true,
+	"instance-id":    true,
+	"recent":         true,
+	"profile":        true,
+	"profile-select": true,
+	"region":         true,
+	"filter":         true,
+	"az":             true,
+	"subnet":         true,
+	"target-regex":   true,
+	"max-instances":  true,
+	"events":         true,
+	"log-file":       true,
+}
+
+// runMultiSession lets the user choose several instances and starts a gossm session to each of
+// them, in tmux panes when run inside tmux, or otherwise prints the commands to run. Each session
+// is a gossm process of its own, which terminates its session when it exits.
+func runMultiSession(ctx context.Context, flags *pflag.FlagSet) {
+	stopSpinner := internal.StartSpinner("Discovering instances")
+	instances, err := findInstances(ctx)
+	stopSpinner()
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// A --target-regex selects every instance it matches
+	var targets []*internal.Target
+	if viper.GetString("target-regex") != "" {
+		targets = sortedTargets(instances)
+	} else if targets, err = internal.AskMultiTarget(instances, ""); err != nil {
+		logErrorAndExit(err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		logErrorAndExit(fmt.Errorf("failed to locate the gossm executable: %w", err))
+	}
+	commands := make([][]string, 0, len(targets))
+	for _, target := range targets {
+		region := target.Region
+		if region == "" {
+			region = credential.awsConfig.Region
+		}
+		commands = append(commands, multiSessionArgs(executable, flags, target.Name, credential.awsProfile, region))
+	}
+
+	if os.Getenv("TMUX") == "" {
+		internal.Infof("Run each command in a terminal of its own, or run gossm start --multi inside tmux to open them in panes")
+		for _, args := range commands {
+			fmt.Println(shellCommand(args))
+		}
+		return
+	}
+
+	if err := openTmuxPanes(commands); err != nil {
+		logErrorAndExit(err)
+	}
+	internal.Infof("Started %d sessions in a new tmux window", len(commands))
+}
+
+// multiSessionArgs returns the gossm command line starting a session to the instance, passing on
+// the flags the user set for this command other than multiSessionSkippedFlags
+func multiSessionArgs(executable string, flags *pflag.FlagSet, instanceID, profile, region string) []string {
+	args := []string{executable, "start", "--instance-id", instanceID, "--region", region}
+	if profile != "" {
+		args = append(args, "--profile", profile)
+	}
+
+	flags.Visit(func(f *pflag.Flag) {
+		if multiSessionSkippedFlags[f.Name] {
+			return
+		}
+		if values, ok := f.Value.(pflag.SliceValue); ok {
+			for _, value := range values.GetSlice() {
+				args = append(args, "--"+f.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+f.Name+"="+f.Value.String())
+	})
+
+	// Each session records to a file of its own, also when the log file is set in the config
+	if path := viper.GetString("start-session-log-file"); path != "" {
+		args = append(args, "--log-file="+sessionLogFile(path, instanceID))
+	}
+
+	return args
+}
+
+// sessionLogFile returns the --log-file of the session to the instance when several sessions are
+// started: a log directory is shared, as each session names its file after its target, and a log
+// file gets the instance ID inserted before its extension, as in session.i-0123456789abcdef0.log.
+func sessionLogFile(path, instanceID string) string {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + instanceID + ext
+}
+
+// openTmuxPanes runs each command in a pane of a new tmux window. A pane whose command fails stays
+// open until Enter is pressed, so its error can be read.
+func openTmuxPanes(commands [][]string) error {
+	for i, args := range commands {
+		tmuxArgs := []string{"split-window"}
+		if i == 0 {
+			tmuxArgs = []string{"new-window", "-n", "gossm"}
+		}
+		tmuxArgs = append(tmuxArgs, shellCommand(args)+` || { printf '\nPress Enter to close this pane'; read _; }`)
+
+		if output, err := exec.Command("tmux", tmuxArgs...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to open a tmux pane: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+		// Re-tile after each split so the next pane fits
+		if err := exec.Command("tmux", "select-layout", "tiled").Run(); err != nil {
+			internal.Debugf("Failed to tile tmux panes: %v", err)
+		}
+	}
+	return nil
+}
+
+// shellCommand quotes a command line for the shell
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellDoubleQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+var (
+	// recentCommand is the Cobra command for listing recently connected targets
+	recentCommand = &cobra.Command{
+		Use:   "recent",
+		Short: "List the targets recently connected to",
+		Long: `List the targets most recently connected to with gossm start for the current profile, newest
+first. Reconnect to one by its number with gossm start --recent=<number>, or pick it from the
+list with gossm start --recent.
+
+Examples:
+  gossm recent             # Numbered list of recent targets
+  gossm start --recent=1   # Reconnect to the most recent target
+`,
+		Args: cobra.NoArgs,
+		Run:  runListRecent,
+	}
+)
+
+// runListRecent prints the numbered recent targets of the current profile
+func runListRecent(cmd *cobra.Command, args []string) {
+	recent := loadRecentTargets()
+	if len(recent) == 0 {
+		color.Yellow("No recent targets")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tNAME\tINSTANCE ID\tREGION\tLAST USED")
+	for i, r := range recent {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, r.Name, r.Target, r.Region, r.Used.Local().Format(time.DateTime))
+	}
+	w.Flush()
+}
+
+// loadRecentTargets returns the recent targets of the current profile, newest first
+func loadRecentTargets() []internal.RecentTarget {
+	state, err := internal.LoadState(statePath())
+	if err != nil {
+		internal.Warnf("Ignoring state file: %v", err)
+		return nil
+	}
+	return state.RecentTargets(credential.awsProfile)
+}
+
+// recentTarget returns the target selected with the --recent flag: the entry with the given number
+// in `gossm recent`, or one picked from the list when no number is given. It returns nil if the
+// flag is not set.
+func recentTarget(flags *pflag.FlagSet) (*internal.Target, error) {
+	if !flags.Changed("recent") {
+		return nil, nil
+	}
+	number, err := flags.GetInt("recent")
+	if err != nil {
+		return nil, err
+	}
+
+	recent := loadRecentTargets()
+	if len(recent) == 0 {
+		return nil, errors.New("no recent targets, connect to an instance with gossm start first")
+	}
+
+	if number == 0 {
+		index, err := internal.AskRecentTarget(recent)
+		if err != nil {
+			return nil, err
+		}
+		number = index + 1
+	}
+	if number < 1 || number > len(recent) {
+		return nil, fmt.Errorf("invalid recent target %d (choose 1-%d, see gossm recent)", number, len(recent))
+	}
+
+	r := recent[number-1]
+	target := &internal.Target{Name: r.Target, InstanceName: r.Name, Region: r.Region}
+	useTargetRegion(target)
+	return target, nil
+}
+
+// rememberRecentTarget records a connection to target as the most recent, unless --no-remember is set
+func rememberRecentTarget(target *internal.Target) {
+	if viper.GetBool("no-remember") {
+		return
+	}
+
+	updateState(func(state *internal.State) {
+		state.AddRecent(internal.RecentTarget{
+			Target:  target.Name,
+			Name:    target.InstanceName,
+			Profile: credential.awsProfile,
+			Region:  credential.awsConfig.Region,
+			Used:    time.Now(),
+		})
+	})
+}
+
+func init() {
+	// Add command to root
+	rootCmd.AddCommand(recentCommand)
+}
@@ -1,17 +1,34 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/fatih/color"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 
 	"github.com/ottramst/gossm/internal"
 )
@@ -19,6 +36,27 @@ import (
 const (
 	// defaultProfile is the AWS profile name to use when none is specified
 	defaultProfile = "default"
+
+	// allRegions is the --region value that searches every enabled region
+	allRegions = "all"
+
+	// selectRegions is the --region value that asks which regions to search
+	selectRegions = "select"
+
+	// defaultDiscoveryRegion is the region used for API calls when searching multiple regions
+	defaultDiscoveryRegion = "us-east-1"
+
+	// defaultConfigFile is the name of the gossm config file in the gossm home directory
+	defaultConfigFile = "config.yaml"
+
+	// sharedCredentialsFileEnv is the environment variable the AWS SDK reads the credentials file path from
+	sharedCredentialsFileEnv = "AWS_SHARED_CREDENTIALS_FILE"
+
+	// maxAPIAttempts is how often an AWS API call is attempted before a throttling or transient error is returned
+	maxAPIAttempts = 10
+
+	// stateFile is the name of the file in the gossm home directory that stores remembered values
+	stateFile = "state.json"
 )
 
 var (
@@ -35,6 +73,10 @@ or send files to your AWS servers using start-session, ssh, scp via AWS Systems
 
 	// credentialWithMFA is the path to the file containing temporary credentials obtained via MFA
 	credentialWithMFA = fmt.Sprintf("%s_mfa", config.DefaultSharedCredentialsFilename())
+
+	// exitFiles holds the files to delete before the process exits, such as ephemeral private keys
+	exitFiles   = map[string]bool{}
+	exitFilesMu sync.Mutex
 )
 
 // Credential holds AWS configuration and credential information for the session
@@ -45,6 +87,9 @@ type Credential struct {
 	// awsConfig contains the AWS SDK configuration including region and credentials
 	awsConfig *aws.Config
 
+	// awsRegions lists the regions to search when discovering instances across multiple regions
+	awsRegions []string
+
 	// gossmHomePath is the path to the gossm home directory (~/.gossm)
 	gossmHomePath string
 
@@ -61,100 +106,644 @@ func Execute(version string) {
 	}
 }
 
-// logErrorAndExit prints an error message and exits the program
+// loadConfigFile reads the gossm config file ($GOSSM_CONFIG or ~/.gossm/config.yaml) if it exists.
+// Values from the file are used when neither a flag nor an environment variable is set.
+func loadConfigFile() {
+	configPath := os.Getenv("GOSSM_CONFIG")
+	explicit := configPath != ""
+
+	if !explicit {
+		home, err := homedir.Dir()
+		if err != nil {
+			return
+		}
+		configPath = filepath.Join(home, ".gossm", defaultConfigFile)
+	}
+
+	viper.SetConfigFile(configPath)
+	if err := viper.ReadInConfig(); err != nil {
+		// A missing default config file is fine, a missing explicit one is not
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		logErrorAndExit(internal.WrapError(fmt.Errorf("failed to read config file %s: %w", configPath, err)))
+	}
+
+	internal.Debugf("Using config file: %s", viper.ConfigFileUsed())
+}
+
+// loadEnvFile sets the environment variables from the --env-file, keeping variables that are
+// already set unless --env-file-override is set
+func loadEnvFile() {
+	path := viper.GetString("env-file")
+	if path == "" {
+		return
+	}
+	expanded, err := homedir.Expand(path)
+	if err != nil {
+		logErrorAndExit(fmt.Errorf("invalid --env-file: %w", err))
+	}
+
+	if _, err := internal.LoadEnvFile(expanded, viper.GetBool("env-file-override")); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+// findOptions builds the instance discovery options from flags and config
+func findOptions() (internal.FindOptions, error) {
+	opts := internal.FindOptions{MaxInstances: viper.GetInt("max-instances")}
+	if opts.MaxInstances < 0 {
+		return opts, fmt.Errorf("invalid max instances %d: must not be negative", opts.MaxInstances)
+	}
+
+	opts.AvailabilityZones = viper.GetStringSlice("az")
+	opts.SubnetIDs = viper.GetStringSlice("subnet")
+
+	if pattern := viper.GetString("target-regex"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --target-regex: %w", err)
+		}
+		opts.TargetPattern = re
+	}
+
+	for _, filter := range viper.GetStringSlice("filter") {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return opts, fmt.Errorf("invalid filter '%s': must be in Key=Value format", filter)
+		}
+		opts.TagFilters = append(opts.TagFilters, internal.TagFilter{
+			Key:   strings.TrimSpace(key),
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	return opts, nil
+}
+
+// newSignalContext returns a context that is canceled on SIGINT, SIGTERM or SIGHUP,
+// so long-running AWS calls can be interrupted cleanly
+func newSignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+}
+
+// logErrorAndExit prints an error message, with a remediation hint when one is known, and exits the program
 func logErrorAndExit(err error) {
+	err = internal.TranslateError(err)
 	fmt.Println(color.RedString("[err] %s", err.Error()))
+
+	// os.Exit skips deferred cleanup, so terminate open sessions and remove files here
+	terminateOpenSessions()
+	removeExitFiles()
 	os.Exit(1)
 }
 
+// removeOnExit deletes path when the process exits through logErrorAndExit or
+// exitOnProcessError, which skip deferred cleanup. The returned function deletes it right away.
+func removeOnExit(path string) func() {
+	exitFilesMu.Lock()
+	exitFiles[path] = true
+	exitFilesMu.Unlock()
+
+	return func() {
+		exitFilesMu.Lock()
+		delete(exitFiles, path)
+		exitFilesMu.Unlock()
+		os.Remove(path)
+	}
+}
+
+// removeExitFiles deletes the files registered with removeOnExit
+func removeExitFiles() {
+	exitFilesMu.Lock()
+	defer exitFilesMu.Unlock()
+	for path := range exitFiles {
+		os.Remove(path)
+		delete(exitFiles, path)
+	}
+}
+
+// exitOnProcessError exits with the exit code of a failed child process, so scripts see the
+// status of the remote command. Other errors exit 1, and a nil error returns normally.
+func exitOnProcessError(err error) {
+	if err == nil {
+		return
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		// Explain failures recognized from the plugin's output before passing on the status
+		if translated := internal.TranslateError(err); translated != err {
+			color.Red("%v", translated)
+		}
+		internal.Debugf("Process exited with status %d", exitErr.ExitCode())
+		terminateOpenSessions()
+		removeExitFiles()
+		os.Exit(exitErr.ExitCode())
+	}
+
+	logErrorAndExit(err)
+}
+
 // initConfig reads in config file and ENV variables if set.
 // It initializes the AWS configuration and SSM plugin.
 func initConfig() {
 	credential = &Credential{}
 
+	// Load project settings first, they may select the config file, profile and region
+	loadEnvFile()
+
+	// Load defaults from the gossm config file
+	loadConfigFile()
+
+	// Disable color on request; NO_COLOR, TERM=dumb and redirected output are detected by the color package
+	if viper.GetBool("no-color") {
+		color.NoColor = true
+	}
+
+	// Configure diagnostic output from the verbosity flags
+	verbosity := viper.GetInt("verbose")
+	if viper.GetBool("debug") {
+		verbosity = 2
+	}
+	internal.SetLogLevel(internal.LogLevelFromFlags(verbosity, viper.GetBool("quiet")))
+	internal.SetQuietSession(viper.GetBool("quiet-session"))
+
+	// version only reports local details, so it needs no profile, credentials or plugin install
+	if subcmd, _, err := rootCmd.Find(os.Args[1:]); err == nil && subcmd == versionCommand {
+		return
+	}
+
+	if viper.GetInt("connect-timeout") < 0 {
+		logErrorAndExit(fmt.Errorf("invalid connect timeout %d: must not be negative", viper.GetInt("connect-timeout")))
+	}
+
+	// Validate the endpoint URL before any API call is made
+	if endpointURL := viper.GetString("endpoint-url"); endpointURL != "" {
+		if u, err := url.Parse(endpointURL); err != nil || u.Scheme == "" || u.Host == "" {
+			logErrorAndExit(fmt.Errorf("invalid endpoint URL %q: must be an absolute URL such as https://ssm.us-gov-west-1.amazonaws.com", endpointURL))
+		}
+	}
+
+	// Validate the AWS shared files, as the AWS SDK silently skips files that don't exist
+	for _, key := range []string{"config-file", "credentials-file"} {
+		if path := awsSharedFile(key); path != "" {
+			if _, err := os.Stat(path); err != nil {
+				logErrorAndExit(fmt.Errorf("invalid --%s: %w", key, err))
+			}
+		}
+	}
+
+	// Validate the escape character before any session is started
+	if _, err := internal.ParseEscapeChar(viper.GetString("escape-char")); err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Open the event stream before any session is started
+	if events := viper.GetString("events"); events != "" {
+		out, err := eventOutput(events)
+		if err != nil {
+			logErrorAndExit(err)
+		}
+		internal.SetEventOutput(out)
+	}
+
 	// 1. Get AWS profile
 	awsProfile := getAWSProfile()
 	credential.awsProfile = awsProfile
 
+	// doctor runs the remaining steps itself, reporting failures instead of exiting
+	if subcmd, _, err := rootCmd.Find(os.Args[1:]); err == nil && subcmd == doctorCommand {
+		return
+	}
+
 	// 2. Get region from command line or environment
-	awsRegion := viper.GetString("region")
+	awsRegion := getAWSRegion()
 
-	// 3. Setup gossm home directory and SSM plugin
-	setupGossmHomeAndPlugin()
+	// A region list or "all" searches multiple regions during discovery
+	multiRegion := ""
+	if isMultiRegion(awsRegion) {
+		multiRegion = awsRegion
+		awsRegion = ""
+	}
+
+	// 3. Setup gossm home directory, the SSM plugin is set up by the commands that launch it
+	if err := setupGossmHome(); err != nil {
+		logErrorAndExit(err)
+	}
+
+	// recent only reads the state file, so it needs no credentials
+	if subcmd, _, err := rootCmd.Find(os.Args[1:]); err == nil && subcmd == recentCommand {
+		return
+	}
 
 	// 4. Setup AWS credentials using the AWS SDK's credential chain
 	setupAWSCredentials(awsProfile, awsRegion)
 
-	// 5. Ensure region is set, prompt user if needed
-	if credential.awsConfig.Region == "" {
-		askRegion, err := internal.AskRegion(context.Background(), *credential.awsConfig)
-		if err != nil {
-			logErrorAndExit(internal.WrapError(err))
+	// Catch a mistyped region before it surfaces as an endpoint error
+	if awsRegion != "" {
+		validateRegions([]string{awsRegion})
+	}
+	if multiRegion != "" {
+		setupMultiRegion(multiRegion)
+	}
+	if len(credential.awsRegions) > 0 {
+		internal.Infof("AWS regions: %s", strings.Join(credential.awsRegions, ", "))
+	} else {
+		// 5. Ensure region is set, prompt user if needed
+		if credential.awsConfig.Region == "" {
+			credential.awsConfig.Region = askRegion()
 		}
-		credential.awsConfig.Region = askRegion.Name
+
+		internal.Infof("AWS region: %s", credential.awsConfig.Region)
 	}
 
-	color.Green("AWS region: %s", credential.awsConfig.Region)
+	// 6. Show which identity the credentials resolved to
+	logCallerIdentity()
 }
 
-// getAWSProfile determines the AWS profile to use
-func getAWSProfile() string {
-	profileFromFlag := viper.GetString("profile")
-	if profileFromFlag != "" {
-		return profileFromFlag
+// logCallerIdentity logs the caller identity at debug level, so users can confirm which
+// identity gossm resolved before running anything. The API call is skipped otherwise.
+func logCallerIdentity() {
+	if !internal.DebugEnabled() {
+		return
 	}
 
-	profileFromEnv := os.Getenv("AWS_PROFILE")
-	if profileFromEnv != "" {
-		return profileFromEnv
+	identity, err := sts.NewFromConfig(*credential.awsConfig).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		internal.Debugf("Failed to get caller identity: %v", err)
+		return
 	}
 
-	return defaultProfile
+	internal.Debugf("Authenticated as %s", aws.ToString(identity.Arn))
 }
 
-// setupGossmHomeAndPlugin sets up the gossm home directory and SSM plugin
-func setupGossmHomeAndPlugin() {
-	home, err := homedir.Dir()
+// askRegion prompts for a region, pre-selecting and remembering the last choice for the profile
+func askRegion() string {
+	remember := !viper.GetBool("no-remember")
+
+	// Load the last selected region for this profile
+	var state *internal.State
+	lastRegion := ""
+	if remember {
+		var err error
+		if state, err = internal.LoadState(statePath()); err != nil {
+			internal.Warnf("Ignoring state file: %v", err)
+			state = &internal.State{}
+		}
+		lastRegion = state.Regions[credential.awsProfile]
+	}
+
+	selected, err := internal.AskRegion(context.Background(), *credential.awsConfig, lastRegion)
 	if err != nil {
 		logErrorAndExit(internal.WrapError(err))
 	}
 
-	credential.gossmHomePath = filepath.Join(home, ".gossm")
-	if err := os.MkdirAll(credential.gossmHomePath, os.ModePerm); err != nil && !os.IsExist(err) {
-		logErrorAndExit(internal.WrapError(err))
+	// Remember the selection for the next run
+	if remember && selected.Name != lastRegion {
+		updateState(func(state *internal.State) {
+			state.SetRegion(credential.awsProfile, selected.Name)
+		})
 	}
 
-	plugin, err := internal.GetSsmPlugin()
-	if err != nil {
-		logErrorAndExit(internal.WrapError(err))
+	return selected.Name
+}
+
+// eventOutput returns the writer for the --events stream: stderr, or an open file descriptor
+func eventOutput(value string) (*os.File, error) {
+	if value == "stderr" {
+		return os.Stderr, nil
 	}
 
-	credential.ssmPluginPath = filepath.Join(credential.gossmHomePath, internal.GetSsmPluginName())
-	setupSsmPlugin(plugin)
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid events output '%s' (use stderr or a file descriptor number)", value)
+	}
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if _, err := file.Stat(); err != nil {
+		return nil, fmt.Errorf("events output fd %d is not open: %w", fd, err)
+	}
+	return file, nil
+}
+
+// processOptions returns the settings shared by the processes of a session: the escape character,
+// the session, target and region that the ~# escape command shows, and whether the session
+// banners are quiet. Callers add what applies only to them, such as the transcript and timeouts.
+func processOptions(sessionID *string, target string) internal.ProcessOptions {
+	// The value is validated in initConfig
+	char, _ := internal.ParseEscapeChar(viper.GetString("escape-char"))
+	return internal.ProcessOptions{
+		EscapeChar:   char,
+		SessionID:    aws.ToString(sessionID),
+		Target:       target,
+		Region:       credential.awsConfig.Region,
+		QuietSession: viper.GetBool("quiet-session"),
+	}
+}
+
+// transferProcessOptions returns the settings for file transfer tools such as scp. They run
+// without escape sequences, as the raw terminal mode needed to detect them mangles progress
+// meters and -v output; Ctrl-C still interrupts a stuck transfer.
+func transferProcessOptions(sessionID *string, target string) internal.ProcessOptions {
+	opts := processOptions(sessionID, target)
+	opts.EscapeChar = 0
+	return opts
+}
+
+// pluginProcessOptions returns the settings for running the SSM plugin directly, which
+// is terminated if it doesn't establish the session within --connect-timeout
+func pluginProcessOptions(sessionID *string, target string) internal.ProcessOptions {
+	opts := processOptions(sessionID, target)
+	opts.ConnectTimeout = time.Duration(viper.GetInt("connect-timeout")) * time.Second
+	return opts
+}
+
+// sshConnectOptions returns the ssh options applying --connect-timeout. With the plugin as
+// ProxyCommand, ssh's ConnectTimeout bounds the wait for the session to come up.
+func sshConnectOptions() []string {
+	if timeout := viper.GetInt("connect-timeout"); timeout > 0 {
+		return []string{"-o", fmt.Sprintf("ConnectTimeout=%d", timeout)}
+	}
+	return nil
+}
+
+// statePath returns the path of the gossm state file
+func statePath() string {
+	return filepath.Join(credential.gossmHomePath, stateFile)
+}
+
+// updateState applies update to the state file. Failures only produce a warning,
+// as the state is a convenience and must not break the command.
+func updateState(update func(state *internal.State)) {
+	if err := internal.UpdateState(statePath(), update); err != nil {
+		internal.Warnf("Failed to update state file: %v", err)
+	}
+}
+
+// isMultiRegion reports whether the region flag requests discovery across multiple regions
+func isMultiRegion(region string) bool {
+	return region == allRegions || region == selectRegions || strings.Contains(region, ",")
 }
 
-// setupSsmPlugin installs or updates the SSM plugin if needed
-func setupSsmPlugin(plugin []byte) {
-	info, err := os.Stat(credential.ssmPluginPath)
+// setupMultiRegion resolves the list of regions to search during discovery
+func setupMultiRegion(regionFlag string) {
+	// The profile's region is pre-selected when asking for regions
+	profileRegion := credential.awsConfig.Region
+
+	// API calls outside of discovery still need a concrete region
+	if credential.awsConfig.Region == "" {
+		credential.awsConfig.Region = defaultDiscoveryRegion
+	}
+
+	switch regionFlag {
+	case allRegions:
+		credential.awsRegions = internal.ListEnabledRegions(context.Background(), *credential.awsConfig)
+		return
+	case selectRegions:
+		regions, err := internal.AskRegions(context.Background(), *credential.awsConfig, []string{profileRegion})
+		if err != nil {
+			logErrorAndExit(err)
+		}
 
-	if os.IsNotExist(err) {
-		color.Green("[create] aws ssm plugin")
-		if err := os.WriteFile(credential.ssmPluginPath, plugin, 0755); err != nil {
-			logErrorAndExit(internal.WrapError(err))
+		// A single region needs no multi-region discovery
+		if len(regions) == 1 {
+			credential.awsConfig.Region = regions[0].Name
+			return
 		}
+		for _, region := range regions {
+			credential.awsRegions = append(credential.awsRegions, region.Name)
+		}
+		return
+	}
+
+	for _, region := range strings.Split(regionFlag, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			credential.awsRegions = append(credential.awsRegions, region)
+		}
+	}
+	validateRegions(credential.awsRegions)
+}
+
+// validateRegions exits if any region is not a known AWS region. A custom endpoint URL may serve
+// regions AWS doesn't know, so they are not checked then.
+func validateRegions(regions []string) {
+	if viper.GetString("endpoint-url") != "" {
 		return
 	}
+	if err := internal.ValidateRegions(context.Background(), *credential.awsConfig, regions); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+// findInstances discovers SSM-connected instances in the configured region or regions. An empty
+// result is an error, ErrNoMatchingInstances if the filters excluded every instance and
+// ErrNoInstances otherwise, so no prompt is shown for nothing.
+func findInstances(ctx context.Context) (map[string]*internal.Target, error) {
+	opts, err := findOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := discoverInstances(ctx, opts)
+	if err != nil || len(instances) > 0 {
+		return instances, err
+	}
+
+	// Tell filters that matched nothing apart from regions without any instances, looking for
+	// a single unfiltered instance to do so, without warning that discovery stopped there
+	regions := cmp.Or(strings.Join(credential.awsRegions, ", "), credential.awsConfig.Region)
+	filters := opts.Description()
+	if filters != "" {
+		unfiltered, err := discoverInstances(ctx, internal.FindOptions{MaxInstances: 1, QuietLimit: true})
+		if err != nil {
+			return nil, err
+		}
+		if len(unfiltered) > 0 {
+			return nil, fmt.Errorf("%w %s in %s", internal.ErrNoMatchingInstances, filters, regions)
+		}
+	}
+	return nil, fmt.Errorf("%w in %s", internal.ErrNoInstances, regions)
+}
+
+// discoverInstances finds the SSM-connected instances in the selected regions
+func discoverInstances(ctx context.Context, opts internal.FindOptions) (map[string]*internal.Target, error) {
+	if len(credential.awsRegions) > 0 {
+		return internal.FindInstancesInRegions(ctx, *credential.awsConfig, credential.awsRegions, opts)
+	}
+	return internal.FindInstances(ctx, *credential.awsConfig, opts)
+}
+
+// instanceIDTarget returns the target given by the --instance-id flag bound to key, or nil if
+// the flag is not set. The ID is used as-is, so no instance discovery (or permission for it) is needed.
+func instanceIDTarget(key string) (*internal.Target, error) {
+	instanceID := strings.TrimSpace(viper.GetString(key))
+	if instanceID == "" {
+		return nil, nil
+	}
+
+	if !instanceIDPattern.MatchString(instanceID) {
+		return nil, fmt.Errorf("invalid instance ID '%s' (expected i-... or mi-...)", instanceID)
+	}
+
+	// Without discovery there is no way to tell which of several regions the instance is in
+	if len(credential.awsRegions) > 0 {
+		return nil, fmt.Errorf("--instance-id requires a single region (got %s)", strings.Join(credential.awsRegions, ", "))
+	}
+
+	internal.Debugf("Using instance %s without discovery", instanceID)
+	return &internal.Target{Name: instanceID, Region: credential.awsConfig.Region}, nil
+}
+
+// useTargetRegion points the AWS configuration at the region the target runs in
+func useTargetRegion(target *internal.Target) {
+	if target != nil && target.Region != "" {
+		credential.awsConfig.Region = target.Region
+	}
+}
+
+// getAWSProfile determines the AWS profile to use.
+// The profile key is bound to the flag, AWS_PROFILE and the config file in that order of precedence.
+// Without one, the default profile is used if it exists, and otherwise the user picks a profile
+// when running interactively. --profile-select always asks.
+func getAWSProfile() string {
+	profile := viper.GetString("profile")
+	selectProfile := viper.GetBool("profile-select")
+	if profile != "" && !selectProfile {
+		return profile
+	}
+
+	// Only ask when someone can answer
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		if selectProfile {
+			internal.Warnf("Ignoring --profile-select as the input is not a terminal")
+		}
+		return cmp.Or(profile, defaultProfile)
+	}
+
+	profiles, err := internal.ListProfiles(sharedConfigFiles())
+	if err != nil {
+		internal.Warnf("Failed to list AWS profiles: %v", err)
+		return cmp.Or(profile, defaultProfile)
+	}
+	if len(profiles) == 0 || (!selectProfile && slices.Contains(profiles, defaultProfile)) {
+		return cmp.Or(profile, defaultProfile)
+	}
 
+	selected, err := internal.AskProfile(profiles, cmp.Or(profile, defaultProfile))
 	if err != nil {
 		logErrorAndExit(internal.WrapError(err))
 	}
+	return selected
+}
+
+// sharedConfigFiles returns the paths of the AWS shared config and credentials files
+func sharedConfigFiles() (configFile, credentialsFile string) {
+	configFile = cmp.Or(awsSharedFile("config-file"), os.Getenv("AWS_CONFIG_FILE"), config.DefaultSharedConfigFilename())
+	credentialsFile = cmp.Or(awsSharedFile("credentials-file"), os.Getenv(sharedCredentialsFileEnv), config.DefaultSharedCredentialsFilename())
+	return configFile, credentialsFile
+}
+
+// awsSharedFile returns the AWS shared file path set by --config-file or --credentials-file,
+// expanding a leading ~ as the config file isn't expanded by a shell
+func awsSharedFile(key string) string {
+	return expandHome(viper.GetString(key))
+}
+
+// expandHome expands a leading ~ or ~user in a path to the home directory, as a shell would.
+// Paths from the config file or the environment reach gossm unexpanded. The path is returned
+// unchanged if the home directory can't be found.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	name, rest := path, ""
+	if i := strings.IndexAny(path, "/"+string(filepath.Separator)); i >= 0 {
+		name, rest = path[:i], path[i+1:]
+	}
 
-	if int(info.Size()) != len(plugin) {
-		color.Green("[update] aws ssm plugin")
-		if err := os.WriteFile(credential.ssmPluginPath, plugin, 0755); err != nil {
-			logErrorAndExit(internal.WrapError(err))
+	var home string
+	if name == "~" {
+		dir, err := homedir.Dir()
+		if err != nil {
+			return path
+		}
+		home = dir
+	} else {
+		u, err := user.Lookup(name[1:])
+		if err != nil {
+			return path
 		}
+		home = u.HomeDir
+	}
+
+	if rest == "" {
+		return home
 	}
+	return filepath.Join(home, rest)
+}
+
+// sharedFileOptions returns the load options that point the AWS SDK at the files set by
+// --config-file and --credentials-file, replacing the default files and environment variables
+func sharedFileOptions() []func(*config.LoadOptions) error {
+	var opts []func(*config.LoadOptions) error
+	if path := awsSharedFile("config-file"); path != "" {
+		opts = append(opts, config.WithSharedConfigFiles([]string{path}))
+	}
+	if path := awsSharedFile("credentials-file"); path != "" {
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{path}))
+	}
+	return opts
+}
+
+// getAWSRegion determines the AWS region to use.
+// The region key is bound to the flag, GOSSM_REGION, AWS_REGION, AWS_DEFAULT_REGION and the config file
+// in that order of precedence. An empty region falls back to the profile's region, then to a prompt.
+func getAWSRegion() string {
+	return viper.GetString("region")
+}
+
+// setupPlugin installs or updates the SSM plugin before a command that launches it runs.
+// With --no-plugin-check the plugin already in the home directory is used as is.
+func setupPlugin(_ *cobra.Command, _ []string) {
+	if viper.GetBool("no-plugin-check") {
+		return
+	}
+	if err := installSsmPlugin(); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+// installSsmPlugin creates the gossm home directory and installs or updates the SSM plugin in it
+func installSsmPlugin() error {
+	if err := setupGossmHome(); err != nil {
+		return err
+	}
+
+	plugin, err := internal.GetSsmPlugin("", viper.GetString("plugin-version"), viper.GetString("plugin-source"))
+	if err != nil {
+		return internal.WrapError(err)
+	}
+
+	if err := internal.WriteSsmPlugin(credential.ssmPluginPath, plugin); err != nil {
+		return internal.WrapError(err)
+	}
+	return nil
+}
+
+// setupGossmHome creates the gossm home directory and sets the path of the SSM plugin in it
+func setupGossmHome() error {
+	home, err := homedir.Dir()
+	if err != nil {
+		return internal.WrapError(err)
+	}
+
+	credential.gossmHomePath = filepath.Join(home, ".gossm")
+	if err := os.MkdirAll(credential.gossmHomePath, os.ModePerm); err != nil && !os.IsExist(err) {
+		return internal.WrapError(err)
+	}
+
+	credential.ssmPluginPath = filepath.Join(credential.gossmHomePath, internal.GetSsmPluginName())
+	return nil
 }
 
 // setupAWSCredentials sets up AWS credentials using the AWS SDK's credential chain
@@ -166,21 +755,66 @@ func setupAWSCredentials(awsProfile, awsRegion string) {
 		logErrorAndExit(internal.WrapError(err))
 	}
 
-	// Check for special MFA credentials file
-	if _, err := os.Stat(credentialWithMFA); err == nil && os.Getenv("AWS_SHAREDcredentialS_FILE") == "" {
-		color.Yellow("[Use] gossm default mfa credential file %s", credentialWithMFA)
-		os.Setenv("AWS_SHAREDcredentialS_FILE", credentialWithMFA)
+	var loadOpts []func(*config.LoadOptions) error
+	switch {
+	case awsSharedFile("credentials-file") != "":
+		// An explicit credentials file is used as is, by the mfa command too
+	case subcmd == mfaCommand:
+		loadOpts = append(loadOpts, config.WithSharedCredentialsFiles([]string{mfaSourceCredentialsFile()}))
+	case useMFACredentialsFile(credentialWithMFA):
+		internal.Infof("[Use] gossm default mfa credential file %s", credentialWithMFA)
+	}
+
+	awsConfig, creds, err := loadAWSConfig(awsProfile, awsRegion, loadOpts...)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	if subcmd == mfaCommand && creds.SessionToken != "" {
+		internal.Warnf("Profile '%s' resolved to temporary credentials (source: %s), MFA requires long-term access keys",
+			awsProfile, creds.Source)
 	}
 
-	// For MFA command, ensure we're using credentials without session tokens
-	if subcmd.Use == "mfa" {
-		// Here we could clear any session tokens or use a separate profile that doesn't use session tokens
-		// This depends on the specifics of how the MFA command is implemented
+	credential.awsConfig = awsConfig
+}
+
+// mfaSourceCredentialsFile returns the credentials file the mfa command reads the long-term keys
+// from. GetSessionToken can't be called with session credentials, so that is the default file if
+// AWS_SHARED_CREDENTIALS_FILE is unset or points at the MFA credentials, and the user's own
+// file otherwise.
+func mfaSourceCredentialsFile() string {
+	path := os.Getenv(sharedCredentialsFileEnv)
+	if path == "" || filepath.Clean(path) == filepath.Clean(credentialWithMFA) {
+		return config.DefaultSharedCredentialsFilename()
 	}
+	return path
+}
 
+// useMFACredentialsFile points the AWS SDK at the MFA credentials file if it exists and
+// AWS_SHARED_CREDENTIALS_FILE isn't already set, reporting whether it did
+func useMFACredentialsFile(path string) bool {
+	if os.Getenv(sharedCredentialsFileEnv) != "" {
+		return false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+
+	os.Setenv(sharedCredentialsFileEnv, path)
+	return true
+}
+
+// loadAWSConfig loads the AWS configuration for the profile and verifies that credentials
+// can be retrieved, returning them along with the configuration. Extra options are applied last.
+func loadAWSConfig(awsProfile, awsRegion string, extraOpts ...func(*config.LoadOptions) error) (*aws.Config, aws.Credentials, error) {
 	// Use AWS SDK's built-in credential chain with our profile
 	configOpts := []func(*config.LoadOptions) error{
-		config.WithSharedConfigProfile(credential.awsProfile),
+		config.WithSharedConfigProfile(awsProfile),
+
+		// Back off and retry when large accounts get throttled, slowing down if throttling persists
+		config.WithRetryer(func() aws.Retryer {
+			return retry.AddWithMaxAttempts(retry.NewAdaptiveMode(), maxAPIAttempts)
+		}),
 	}
 
 	// Add region if specified
@@ -188,24 +822,31 @@ func setupAWSCredentials(awsProfile, awsRegion string) {
 		configOpts = append(configOpts, config.WithRegion(awsRegion))
 	}
 
+	// Send all API calls to a custom endpoint, e.g. a VPC endpoint or a GovCloud FIPS endpoint
+	if endpointURL := viper.GetString("endpoint-url"); endpointURL != "" {
+		configOpts = append(configOpts, config.WithBaseEndpoint(endpointURL))
+	}
+	configOpts = append(configOpts, sharedFileOptions()...)
+	configOpts = append(configOpts, extraOpts...)
+
 	// Load AWS configuration
 	awsConfig, err := config.LoadDefaultConfig(context.Background(), configOpts...)
 	if err != nil {
-		logErrorAndExit(internal.WrapError(fmt.Errorf("failed to load AWS configuration: %w", err)))
+		return nil, aws.Credentials{}, internal.WrapError(fmt.Errorf("failed to load AWS configuration: %w", err))
 	}
 
 	// Verify credentials are valid
 	creds, err := awsConfig.Credentials.Retrieve(context.Background())
 	if err != nil {
-		logErrorAndExit(internal.WrapError(fmt.Errorf("failed to retrieve AWS credentials: %w", err)))
+		return nil, aws.Credentials{}, internal.WrapError(fmt.Errorf("failed to retrieve AWS credentials: %w", err))
 	}
 
 	// Validate credentials
 	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
-		logErrorAndExit(internal.WrapError(fmt.Errorf("invalid AWS credentials: missing access key or secret key")))
+		return nil, aws.Credentials{}, internal.WrapError(fmt.Errorf("invalid AWS credentials: missing access key or secret key"))
 	}
 
-	credential.awsConfig = &awsConfig
+	return &awsConfig, creds, nil
 }
 
 // init sets up the command flags and initializes the configuration system
@@ -215,13 +856,89 @@ func init() {
 	// Define persistent flags for the root command
 	rootCmd.PersistentFlags().StringP("profile", "p", "",
 		`AWS profile name (default is AWS_PROFILE environment variable or "default")`)
+	rootCmd.PersistentFlags().Bool("profile-select", false,
+		`Choose the AWS profile from a list, even if a profile is already set`)
+	rootCmd.PersistentFlags().String("config-file", "",
+		`AWS shared config file to use instead of ~/.aws/config and AWS_CONFIG_FILE`)
+	rootCmd.PersistentFlags().String("credentials-file", "",
+		`AWS shared credentials file to use instead of ~/.aws/credentials and AWS_SHARED_CREDENTIALS_FILE`)
+	rootCmd.PersistentFlags().String("env-file", "",
+		`Load environment variables such as AWS_PROFILE, AWS_REGION and GOSSM_* settings from this dotenv file`)
+	rootCmd.PersistentFlags().Bool("env-file-override", false,
+		`Let the --env-file override environment variables that are already set`)
 	rootCmd.PersistentFlags().StringP("region", "r", "",
-		`AWS region to use for operations`)
+		`AWS region to use for operations (comma-separated list, "all" or "select" to search multiple regions)`)
+	rootCmd.PersistentFlags().Bool("no-remember", false,
+		`Don't pre-select or remember the last chosen region and SSH user`)
+	rootCmd.PersistentFlags().StringSlice("filter", nil,
+		`Only discover instances with a matching tag (Key=Value, repeatable)`)
+	rootCmd.PersistentFlags().StringSlice("az", nil,
+		`Only discover instances in this availability zone (repeatable)`)
+	rootCmd.PersistentFlags().StringSlice("subnet", nil,
+		`Only discover instances in this subnet (repeatable)`)
+	rootCmd.PersistentFlags().String("target-regex", "",
+		`Only discover instances whose Name tag or instance ID matches this regular expression`)
+	rootCmd.PersistentFlags().Int("max-instances", 0,
+		`Stop discovery after this many instances per region (0 for no limit)`)
+	rootCmd.PersistentFlags().CountP("verbose", "v",
+		`Increase output detail (-v for debug, -vv for full proxy commands and API inputs)`)
+	rootCmd.PersistentFlags().Bool("debug", false,
+		`Show all diagnostic output (same as -vv)`)
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false,
+		`Only show warnings and errors`)
+	rootCmd.PersistentFlags().Bool("no-color", false,
+		`Disable colored output (also disabled by NO_COLOR or when output is not a terminal)`)
+	rootCmd.PersistentFlags().Int("connect-timeout", 0,
+		`Seconds to wait for a session to be established before giving up (0 waits indefinitely)`)
+	rootCmd.PersistentFlags().String("endpoint-url", "",
+		`Custom endpoint URL for AWS API calls (AWS_ENDPOINT_URL and AWS_ENDPOINT_URL_<SERVICE> are also honored)`)
+	rootCmd.PersistentFlags().String("escape-char", string(internal.DefaultEscapeChar),
+		`Escape character for interactive sessions (a character, ^X for a control character, or "none" to disable)`)
+	rootCmd.PersistentFlags().Bool("quiet-session", false,
+		`Don't print session banners, for scripts and terminal multiplexers`)
+	rootCmd.PersistentFlags().String("events", "",
+		`Write session lifecycle events as JSON lines to stderr, or to a file descriptor with --events=<fd>`)
+	rootCmd.PersistentFlags().Lookup("events").NoOptDefVal = "stderr"
+	rootCmd.PersistentFlags().String("plugin-source", internal.PluginSourceAuto,
+		`Where the SSM plugin comes from: "auto" (download, falling back to the embedded plugin), "embedded" or "download"`)
+	rootCmd.PersistentFlags().Bool("no-plugin-check", false,
+		`Skip installing and updating the SSM plugin, using the one in ~/.gossm as is`)
 
 	// Initialize default version flag
 	rootCmd.InitDefaultVersionFlag()
 
 	// Bind flags to viper for configuration
 	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	viper.BindPFlag("profile-select", rootCmd.PersistentFlags().Lookup("profile-select"))
+	viper.BindPFlag("config-file", rootCmd.PersistentFlags().Lookup("config-file"))
+	viper.BindPFlag("credentials-file", rootCmd.PersistentFlags().Lookup("credentials-file"))
+	viper.BindPFlag("env-file", rootCmd.PersistentFlags().Lookup("env-file"))
+	viper.BindPFlag("env-file-override", rootCmd.PersistentFlags().Lookup("env-file-override"))
 	viper.BindPFlag("region", rootCmd.PersistentFlags().Lookup("region"))
+	viper.BindPFlag("filter", rootCmd.PersistentFlags().Lookup("filter"))
+	viper.BindPFlag("az", rootCmd.PersistentFlags().Lookup("az"))
+	viper.BindPFlag("subnet", rootCmd.PersistentFlags().Lookup("subnet"))
+	viper.BindPFlag("target-regex", rootCmd.PersistentFlags().Lookup("target-regex"))
+	viper.BindPFlag("max-instances", rootCmd.PersistentFlags().Lookup("max-instances"))
+	viper.BindPFlag("no-remember", rootCmd.PersistentFlags().Lookup("no-remember"))
+	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
+	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
+	viper.BindPFlag("quiet", rootCmd.PersistentFlags().Lookup("quiet"))
+	viper.BindPFlag("escape-char", rootCmd.PersistentFlags().Lookup("escape-char"))
+	viper.BindPFlag("no-color", rootCmd.PersistentFlags().Lookup("no-color"))
+	viper.BindPFlag("connect-timeout", rootCmd.PersistentFlags().Lookup("connect-timeout"))
+	viper.BindPFlag("endpoint-url", rootCmd.PersistentFlags().Lookup("endpoint-url"))
+	viper.BindPFlag("events", rootCmd.PersistentFlags().Lookup("events"))
+	viper.BindPFlag("quiet-session", rootCmd.PersistentFlags().Lookup("quiet-session"))
+	viper.BindPFlag("no-plugin-check", rootCmd.PersistentFlags().Lookup("no-plugin-check"))
+	viper.BindPFlag("plugin-source", rootCmd.PersistentFlags().Lookup("plugin-source"))
+
+	// Bind environment variables, which take precedence over the config file
+	viper.BindEnv("profile", "GOSSM_PROFILE", "AWS_PROFILE")
+	viper.BindEnv("region", "GOSSM_REGION", "AWS_REGION", "AWS_DEFAULT_REGION")
+	viper.BindEnv("filter", "GOSSM_FILTER")
+	viper.BindEnv("max-instances", "GOSSM_MAX_INSTANCES")
+	viper.BindEnv("plugin-version", "GOSSM_PLUGIN_VERSION")
+	viper.BindEnv("plugin-source", "GOSSM_PLUGIN_SOURCE")
+	viper.BindEnv("default-target", "GOSSM_DEFAULT_TARGET")
 }
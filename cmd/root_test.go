@@ -1 +1,229 @@
 package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/mitchellh/go-homedir"
+	"github.com/ottramst/gossm/internal"
+	"github.com/spf13/viper"
+)
+
+// useFakeAWS points the session credential at a server answering every AWS API call with handler,
+// and keeps the state file in a temporary directory
+func useFakeAWS(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	previous := credential
+	credential = &Credential{
+		awsConfig: &aws.Config{
+			Region:           "eu-west-1",
+			Credentials:      credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+			BaseEndpoint:     aws.String(server.URL),
+			RetryMaxAttempts: 1,
+		},
+		gossmHomePath: t.TempDir(),
+	}
+	t.Cleanup(func() { credential = previous })
+}
+
+// isolateAWSEnv points the AWS SDK at empty config files and clears credential variables
+func isolateAWSEnv(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv(sharedCredentialsFileEnv, "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return dir
+}
+
+func TestUseMFACredentialsFileLoadsCredentials(t *testing.T) {
+	dir := isolateAWSEnv(t)
+
+	path := filepath.Join(dir, "credentials_mfa")
+	content := "[default]\naws_access_key_id = AKIAMFATEST\naws_secret_access_key = secret\naws_session_token = token\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if !useMFACredentialsFile(path) {
+		t.Fatal("useMFACredentialsFile() = false, want true")
+	}
+	if got := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); got != path {
+		t.Fatalf("AWS_SHARED_CREDENTIALS_FILE = %q, want %q", got, path)
+	}
+
+	_, creds, err := loadAWSConfig(defaultProfile, "us-east-1")
+	if err != nil {
+		t.Fatalf("loadAWSConfig() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIAMFATEST" || creds.SessionToken != "token" {
+		t.Errorf("loaded credentials %q/%q, want the MFA credentials", creds.AccessKeyID, creds.SessionToken)
+	}
+}
+
+func TestUseMFACredentialsFileKeepsExplicitFile(t *testing.T) {
+	dir := isolateAWSEnv(t)
+
+	path := filepath.Join(dir, "credentials_mfa")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	explicit := filepath.Join(dir, "credentials")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", explicit)
+
+	if useMFACredentialsFile(path) {
+		t.Error("useMFACredentialsFile() = true, want false when the variable is already set")
+	}
+	if got := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); got != explicit {
+		t.Errorf("AWS_SHARED_CREDENTIALS_FILE = %q, want %q", got, explicit)
+	}
+}
+
+func TestUseMFACredentialsFileMissing(t *testing.T) {
+	dir := isolateAWSEnv(t)
+
+	if useMFACredentialsFile(filepath.Join(dir, "credentials_mfa")) {
+		t.Error("useMFACredentialsFile() = true, want false for a missing file")
+	}
+}
+
+func TestMFASourceCredentialsFile(t *testing.T) {
+	isolateAWSEnv(t)
+	custom := filepath.Join(t.TempDir(), "tenant-credentials")
+
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{env: "", want: config.DefaultSharedCredentialsFilename()},
+		{env: credentialWithMFA, want: config.DefaultSharedCredentialsFilename()},
+		{env: custom, want: custom},
+	}
+
+	for _, tt := range tests {
+		t.Setenv(sharedCredentialsFileEnv, tt.env)
+		if got := mfaSourceCredentialsFile(); got != tt.want {
+			t.Errorf("mfaSourceCredentialsFile() with %q = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestLoadAWSConfigUsesCredentialsFileFlag(t *testing.T) {
+	dir := isolateAWSEnv(t)
+
+	path := filepath.Join(dir, "tenant-credentials")
+	content := "[tenant]\naws_access_key_id = AKIATENANT\naws_secret_access_key = secret\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(sharedCredentialsFileEnv, filepath.Join(dir, "credentials"))
+	viper.Set("credentials-file", path)
+	t.Cleanup(func() { viper.Set("credentials-file", "") })
+
+	_, creds, err := loadAWSConfig("tenant", "us-east-1")
+	if err != nil {
+		t.Fatalf("loadAWSConfig() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIATENANT" {
+		t.Errorf("loaded access key %q, want the one from --credentials-file", creds.AccessKeyID)
+	}
+}
+
+// useHomeDir points the home directory at dir for the duration of the test
+func useHomeDir(t *testing.T, dir string) {
+	t.Helper()
+	homedir.DisableCache = true
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	t.Cleanup(func() { homedir.DisableCache = false })
+}
+
+func TestExpandHome(t *testing.T) {
+	home := t.TempDir()
+	useHomeDir(t, home)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "~", want: home},
+		{path: "~/.ssh/id_ed25519", want: filepath.Join(home, ".ssh", "id_ed25519")},
+		{path: "/etc/ssh/key", want: "/etc/ssh/key"},
+		{path: "keys/~/key", want: "keys/~/key"},
+		{path: "~no-such-user-gossm/key", want: "~no-such-user-gossm/key"},
+		{path: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := expandHome(tt.path); got != tt.want {
+			t.Errorf("expandHome(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFindInstancesOutcomes(t *testing.T) {
+	const instance = `{"InstanceId":"i-0123456789abcdef0","PingStatus":"Online"}`
+	tests := []struct {
+		name          string
+		infos         string
+		targetRegex   string
+		wantErr       error
+		wantDescribes int // DescribeInstanceInformation calls, including the unfiltered probe
+	}{
+		{name: "instances found", infos: instance, wantDescribes: 1},
+		{name: "filters exclude every instance", infos: instance, targetRegex: "^db-", wantErr: internal.ErrNoMatchingInstances, wantDescribes: 2},
+		{name: "filtered region without instances", targetRegex: "^db-", wantErr: internal.ErrNoInstances, wantDescribes: 2},
+		{name: "region without instances", wantErr: internal.ErrNoInstances, wantDescribes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			describes := 0
+			useFakeAWS(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Amz-Target") == "AmazonSSM.DescribeInstanceInformation" {
+					describes++
+					fmt.Fprintf(w, `{"InstanceInformationList":[%s]}`, tt.infos)
+					return
+				}
+				if r.ParseForm() == nil && r.Form.Get("Action") == "DescribeInstances" {
+					fmt.Fprint(w, `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet><item><instancesSet><item>`+
+						`<instanceId>i-0123456789abcdef0</instanceId><tagSet><item><key>Name</key><value>web-1</value></item></tagSet>`+
+						`</item></instancesSet></item></reservationSet></DescribeInstancesResponse>`)
+					return
+				}
+				http.Error(w, "unexpected call", http.StatusBadRequest)
+			})
+			viper.Set("target-regex", tt.targetRegex)
+			t.Cleanup(func() { viper.Set("target-regex", "") })
+
+			instances, err := findInstances(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("findInstances() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && len(instances) != 1 {
+				t.Errorf("findInstances() found %d instances, want 1", len(instances))
+			}
+			if describes != tt.wantDescribes {
+				t.Errorf("DescribeInstanceInformation called %d times, want %d", describes, tt.wantDescribes)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+const (
+	// rsyncOptionsWithArgs are the rsync short options that take a value
+	rsyncOptionsWithArgs = "BefMT"
+)
+
+var (
+	// rsyncCommand is the Cobra command for rsync transfers via SSM
+	rsyncCommand = &cobra.Command{
+		Use:   "rsync",
+		Short: "Synchronize files using rsync via AWS Systems Manager",
+		Long: `Synchronize files between your local machine and AWS instances using rsync
+through AWS Systems Manager Session Manager.
+
+This command tunnels rsync's ssh transport through SSM, which suits large or incremental
+transfers to instances without public IP addresses. rsync must be installed locally and
+on the instance.
+
+Output of rsync, such as its progress meter and -v diagnostics, is passed through unchanged.
+Escape sequences are not available; interrupt a stuck transfer with Ctrl-C.
+
+Host key checking works the same way as for the ssh command (see gossm ssh --help).
+
+Example:
+  gossm rsync --exec "-avz ./dist/ ec2-user@instance:/opt/app/"
+`,
+		PersistentPreRun: setupPlugin,
+		Run:              runRsyncCommand,
+	}
+)
+
+// runRsyncCommand executes the rsync operation
+func runRsyncCommand(cmd *cobra.Command, args []string) {
+	// Registered first so it runs after all other cleanup, propagating the process exit code
+	var execErr error
+	defer func() { exitOnProcessError(execErr) }()
+
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
+
+	// Get and validate rsync command arguments
+	rsyncExec := strings.TrimSpace(viper.GetString("rsync-exec"))
+	rsyncArgs, err := validateRsyncArguments(rsyncExec)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Resolve host key checking options
+	hostKeyArgs, err := hostKeyOptions(viper.GetString("rsync-strict-host-key-checking"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// rsync uses the same source/destination syntax as scp
+	targetInstanceID, err := findTargetInstanceID(ctx, rsyncArgs, rsyncOptionsWithArgs)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Resolve the sshd port on the instance
+	port, err := sshPort(viper.GetString("rsync-port"), "", "")
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Merge extra session parameters with the port
+	parameters, err := mergeSessionParameters(map[string][]string{"portNumber": {port}}, viper.GetStringSlice("rsync-param"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Display information about the command
+	internal.PrintReady("rsync", credential.awsConfig.Region, targetInstanceID)
+	color.Cyan("rsync %s", rsyncExec)
+
+	// Start an SSH session through SSM
+	session, err := startSSHSession(ctx, targetInstanceID, parameters)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Execute rsync with SSM as proxy
+	execErr = executeRsyncCommand(rsyncArgs, hostKeyArgs, session, targetInstanceID, parameters)
+
+	// Clean up by terminating the session
+	if err := terminateSession(ctx, session.SessionId); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+// validateRsyncArguments splits the rsync command arguments like a shell would and validates them
+func validateRsyncArguments(rsyncExec string) ([]string, error) {
+	rsyncArgs, err := splitArgs(rsyncExec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rsync arguments: %w", err)
+	}
+
+	if len(rsyncArgs) == 0 {
+		return nil, fmt.Errorf("rsync command arguments are required")
+	}
+
+	if len(rsyncArgs) < 2 {
+		return nil, fmt.Errorf("invalid rsync arguments: must include source and destination")
+	}
+
+	return rsyncArgs, nil
+}
+
+// executeRsyncCommand executes rsync with an ssh remote shell that uses SSM as proxy
+func executeRsyncCommand(rsyncArgs []string, hostKeyArgs []string, session *ssm.StartSessionOutput, targetInstanceID string, parameters map[string][]string) error {
+	// Marshal session information to JSON
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session data: %w", err)
+	}
+
+	// Create parameter input for the SSM plugin
+	input := &ssm.StartSessionInput{
+		DocumentName: aws.String(documentNameSSH),
+		Parameters:   parameters,
+		Target:       aws.String(targetInstanceID),
+	}
+
+	// Marshal parameters to JSON
+	paramsJSON, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session parameters: %w", err)
+	}
+
+	// Log the command with the session secrets redacted before they are quoted
+	proxyCommand := rsyncProxyCommand(string(sessionJSON), string(paramsJSON))
+	internal.Tracef("ProxyCommand: %s", rsyncProxyCommand(internal.RedactSecrets(string(sessionJSON)), string(paramsJSON)))
+
+	// Build the remote shell passed to rsync's -e option
+	remoteShell := []string{"ssh", "-o", "'" + proxyCommand + "'"}
+	remoteShell = append(remoteShell, hostKeyArgs...)
+	remoteShell = append(remoteShell, sshConnectOptions()...)
+
+	// Build rsync command arguments
+	args := []string{"-e", strings.Join(remoteShell, " ")}
+	args = append(args, rsyncArgs...)
+
+	// Execute rsync command
+	return internal.CallProcess(transferProcessOptions(session.SessionId, targetInstanceID), "rsync", args...)
+}
+
+// rsyncProxyCommand returns the ssh ProxyCommand option that runs the SSM plugin for the session.
+// The proxy command ends up inside a single-quoted rsync argument, so the JSON is double-quoted
+// for the shell that ssh runs it with.
+func rsyncProxyCommand(sessionJSON, paramsJSON string) string {
+	return fmt.Sprintf("ProxyCommand=%s %s %s %s %s %s",
+		credential.ssmPluginPath,
+		shellDoubleQuote(sessionJSON),
+		credential.awsConfig.Region,
+		"StartSession",
+		credential.awsProfile,
+		shellDoubleQuote(paramsJSON),
+	)
+}
+
+// shellDoubleQuote wraps s in double quotes, escaping the characters the shell interprets inside them
+func shellDoubleQuote(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + replacer.Replace(s) + `"`
+}
+
+func init() {
+	// Define command flags
+	rsyncCommand.Flags().StringP("exec", "e", "", "rsync command arguments (e.g., \"-avz ./dist/ user@instance:/opt/app/\")")
+	rsyncCommand.Flags().String("strict-host-key-checking", defaultHostKeyChecking, "Host key checking mode: yes, no or accept-new")
+	rsyncCommand.Flags().String("port", "", "sshd port on the instance (default: 22)")
+	rsyncCommand.Flags().StringArray("param", nil, "Extra session document parameter as key=value (repeatable)")
+	rsyncCommand.MarkFlagRequired("exec")
+
+	// Bind flags to viper
+	viper.BindPFlag("rsync-exec", rsyncCommand.Flags().Lookup("exec"))
+	viper.BindPFlag("rsync-strict-host-key-checking", rsyncCommand.Flags().Lookup("strict-host-key-checking"))
+	viper.BindPFlag("rsync-port", rsyncCommand.Flags().Lookup("port"))
+	viper.BindPFlag("rsync-param", rsyncCommand.Flags().Lookup("param"))
+
+	// Add command to root
+	rootCmd.AddCommand(rsyncCommand)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/ottramst/gossm/internal"
+)
+
+// sessionSecrets returns the JSON of a session whose token and stream URL must never be logged
+func sessionSecrets(t *testing.T) string {
+	t.Helper()
+
+	sessionJSON, err := json.Marshal(&ssm.StartSessionOutput{
+		SessionId:  aws.String("gossm-0123"),
+		StreamUrl:  aws.String("wss://ssmmessages.eu-west-1.amazonaws.com/v1/data-channel/gossm-0123?role=publish_subscribe&cell-number=AAEA"),
+		TokenValue: aws.String("secret-token"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(sessionJSON)
+}
+
+// assertRedacted fails if the logged form of command still shows a session secret
+func assertRedacted(t *testing.T, command string) {
+	t.Helper()
+
+	logged := internal.RedactSecrets(command)
+	for _, secret := range []string{"secret-token", "wss://", "gossm-0123"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("logged ProxyCommand shows %q: %s", secret, logged)
+		}
+	}
+}
+
+func TestRsyncProxyCommandRedacted(t *testing.T) {
+	useFakeAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unexpected call", http.StatusBadRequest)
+	})
+	sessionJSON := sessionSecrets(t)
+
+	proxyCommand := rsyncProxyCommand(sessionJSON, `{"Target":"i-0123456789abcdef0"}`)
+	if !strings.Contains(proxyCommand, `\"TokenValue\":\"secret-token\"`) {
+		t.Fatalf("rsyncProxyCommand() = %s, want the double-quoted session JSON", proxyCommand)
+	}
+	assertRedacted(t, proxyCommand)
+	assertRedacted(t, rsyncProxyCommand(internal.RedactSecrets(sessionJSON), `{"Target":"i-0123456789abcdef0"}`))
+}
+
+func TestValidateRsyncArguments(t *testing.T) {
+	tests := []struct {
+		exec    string
+		want    []string
+		wantErr bool
+	}{
+		{exec: "-avz ./dist/ ec2-user@web:/opt/app/", want: []string{"-avz", "./dist/", "ec2-user@web:/opt/app/"}},
+		{exec: `-av "./my dir/" 'ec2-user@web:/opt/my app/'`, want: []string{"-av", "./my dir/", "ec2-user@web:/opt/my app/"}},
+		{exec: "", wantErr: true},
+		{exec: "./dist/", wantErr: true},
+		{exec: `-av "./my dir/ ec2-user@web:/opt/app/`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := validateRsyncArguments(tt.exec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateRsyncArguments(%q) error = %v, wantErr %v", tt.exec, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("validateRsyncArguments(%q) = %q, want %q", tt.exec, got, tt.want)
+		}
+	}
+}
@@ -4,7 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -22,6 +29,14 @@ const (
 
 	// defaultSSHPort is the default port for SSH connections
 	defaultSSHPort = "22"
+
+	// scpOptionsWithArgs are the scp short options that take a value
+	scpOptionsWithArgs = "cDFiJloPSX"
+)
+
+var (
+	// instanceIDPattern matches EC2 and managed instance IDs used directly as hostnames
+	instanceIDPattern = regexp.MustCompile(`^m?i-[0-9a-f]{8,17}$`)
 )
 
 var (
@@ -35,45 +50,89 @@ through AWS Systems Manager Session Manager.
 This command establishes an SCP connection through SSM, allowing secure file
 transfers without requiring direct SSH access to the instance.
 
-Escape Sequence:
-  Enter ~.   Disconnect from the session (useful when network is stuck)
+Output of scp, such as its progress meter and -v diagnostics, is passed through unchanged.
+Escape sequences are not available; interrupt a stuck transfer with Ctrl-C.
+
+Host key checking works the same way as for the ssh command (see gossm ssh --help).
+
+Exactly one side of the transfer must be remote. The host may be an instance ID, a hostname
+or an IP address (IPv6 in brackets); resolved private addresses are matched first.
+
+Arguments are split like a shell would, so quote paths that contain spaces. Local sources
+must exist, directories need -r, and local wildcards such as *.log are expanded by gossm.
+
+Several local files can be sent in one transfer, as with scp itself. To run a series of
+transfers over a single session, list the arguments of each transfer on its own line in a
+--batch file (# starts a comment). The transfers share one ssh connection, so they must all
+use the same host; they stop at the first failure.
 
 Example:
   gossm scp --exec "-i key.pem file.txt ec2-user@instance:/home/ec2-user/"
+  gossm scp --exec "a.txt b.txt ec2-user@instance:/tmp/"
+  gossm scp --batch deploy.txt
 `,
-		Run: runSCPCommand,
+		PersistentPreRun: setupPlugin,
+		Run:              runSCPCommand,
 	}
 )
 
 // runSCPCommand executes the SCP file transfer operation
 func runSCPCommand(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	// Registered first so it runs after all other cleanup, propagating the process exit code
+	var execErr error
+	defer func() { exitOnProcessError(execErr) }()
+
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
+
+	// Get and validate SCP command arguments, one set per transfer
+	transfers, err := scpTransfers()
+	if err != nil {
+		logErrorAndExit(err)
+	}
+	scpArgs := transfers[0]
+
+	// Resolve host key checking options
+	hostKeyArgs, err := hostKeyOptions(viper.GetString("scp-strict-host-key-checking"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
 
-	// Get and validate SCP command arguments
-	scpArgs, err := validateSCPArguments()
+	// Parse source and destination to find the target instance, unless its ID is given
+	targetInstanceID, err := scpTargetInstanceID(ctx, scpArgs)
 	if err != nil {
 		logErrorAndExit(err)
 	}
 
-	// Parse source and destination to find the target instance
-	targetInstanceID, err := findTargetInstanceID(ctx, scpArgs)
+	// Resolve the sshd port on the instance
+	port, err := sshPort(viper.GetString("scp-port"), scpOptions(transfers), "-P")
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Merge extra session parameters with the port
+	parameters, err := mergeSessionParameters(map[string][]string{"portNumber": {port}}, viper.GetStringSlice("scp-param"))
 	if err != nil {
 		logErrorAndExit(err)
 	}
 
 	// Display information about the command
-	displaySCPCommandInfo(scpArgs, targetInstanceID)
+	displaySCPCommandInfo(strings.Join(scpArgs, " "), targetInstanceID)
 
 	// Start an SSH session through SSM
-	session, err := startSSHSession(ctx, targetInstanceID)
+	session, err := startSSHSession(ctx, targetInstanceID, parameters)
 	if err != nil {
 		logErrorAndExit(err)
 	}
 
 	// Execute SCP command with SSM as proxy
-	err = executeSCPCommand(scpArgs, session, targetInstanceID)
-	if err != nil {
-		color.Red("%v", err)
+	if len(transfers) > 1 {
+		execErr = executeSCPBatch(transfers, hostKeyArgs, session, targetInstanceID, parameters)
+	} else {
+		execErr = executeSCPCommand(scpArgs, hostKeyArgs, session, targetInstanceID, parameters)
 	}
 
 	// Clean up by terminating the session
@@ -83,75 +142,370 @@ func runSCPCommand(cmd *cobra.Command, args []string) {
 	}
 }
 
-// validateSCPArguments validates and parses the SCP command arguments
-func validateSCPArguments() (string, error) {
-	scpArgs := strings.TrimSpace(viper.GetString("scp-exec"))
+// scpTransfers returns the SCP arguments of each transfer: those of --exec, or one line
+// per transfer from the --batch file
+func scpTransfers() ([][]string, error) {
+	batch := viper.GetString("scp-batch")
+	if batch == "" {
+		scpArgs, err := validateSCPArguments(viper.GetString("scp-exec"))
+		if err != nil {
+			return nil, err
+		}
+		return [][]string{scpArgs}, nil
+	}
 
-	if scpArgs == "" {
-		return "", fmt.Errorf("SCP command arguments are required")
+	// Batches multiplex their transfers over one ssh connection
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("--batch requires ssh connection sharing (ControlMaster), which OpenSSH for Windows doesn't support")
 	}
 
-	// Basic validation of SCP arguments
-	parts := strings.Split(scpArgs, " ")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("invalid SCP arguments: must include source and destination")
+	return readSCPBatch(batch)
+}
+
+// readSCPBatch reads the SCP arguments of several transfers from a file, or stdin for "-".
+// Blank lines and lines starting with # are skipped. All transfers must use the same remote host.
+func readSCPBatch(path string) ([][]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch file: %w", err)
 	}
 
-	return scpArgs, nil
+	var transfers [][]string
+	var hosts []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		scpArgs, err := validateSCPArguments(line)
+		if err != nil {
+			return nil, fmt.Errorf("batch line %d: %w", i+1, err)
+		}
+
+		for _, host := range remoteHosts(scpArgs, scpOptionsWithArgs) {
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+		transfers = append(transfers, scpArgs)
+	}
+
+	if len(transfers) == 0 {
+		return nil, fmt.Errorf("batch file contains no transfers")
+	}
+	if len(hosts) > 1 {
+		return nil, fmt.Errorf("all batch transfers must use the same remote host (found %s)", strings.Join(hosts, ", "))
+	}
+
+	return transfers, nil
 }
 
-// findTargetInstanceID identifies the instance ID for the SCP operation
-func findTargetInstanceID(ctx context.Context, scpArgs string) (string, error) {
-	// Split the arguments to identify source and destination
-	parts := strings.Split(scpArgs, " ")
-	dst := parts[len(parts)-1]
-	src := parts[len(parts)-2]
+// scpOptions returns the options of every transfer, leaving out the paths, so that a path
+// such as "-P" after "--" is not taken for an option
+func scpOptions(transfers [][]string) string {
+	var options []string
+	for _, args := range transfers {
+		if operands := internal.OperandIndexes(args, scpOptionsWithArgs); len(operands) > 0 {
+			args = args[:operands[0]]
+		}
+		options = append(options, args...)
+	}
+	return strings.Join(options, " ")
+}
+
+// validateSCPArguments splits the SCP command arguments like a shell would and validates them.
+// Exactly one side of the transfer must be remote. Local sources must exist, directories need
+// -r, and local glob patterns are expanded as there is no shell to do it.
+func validateSCPArguments(scpArgs string) ([]string, error) {
+	args, err := splitArgs(scpArgs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCP arguments: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("SCP command arguments are required")
+	}
 
-	// Parse to find the host part (could be in source or destination)
-	var hostname string
+	operands := internal.OperandIndexes(args, scpOptionsWithArgs)
+	if len(operands) < 2 {
+		return nil, fmt.Errorf("invalid SCP arguments: must include source and destination")
+	}
+	expandLocalPaths(args, operands)
+
+	// Either the destination is remote and all sources are local, or the other way around
+	destination := args[operands[len(operands)-1]]
+	upload := remoteHost(destination) != ""
+	for _, i := range operands[:len(operands)-1] {
+		if remote := remoteHost(args[i]) != ""; remote == upload {
+			if upload {
+				return nil, fmt.Errorf("invalid SCP arguments: '%s' and the destination are both remote (exactly one side must be remote)", args[i])
+			}
+			return nil, fmt.Errorf("missing remote path: '%s' and '%s' are both local (expected [user@]host:path on one side)", args[i], destination)
+		}
+	}
 
-	// Check destination for hostname (user@host:path format)
-	dstParts := strings.Split(dst, ":")
-	if len(dstParts) > 1 {
-		hostParts := strings.Split(dstParts[0], "@")
-		if len(hostParts) == 2 {
-			hostname = hostParts[1]
+	if !upload {
+		// Downloads need an existing local directory to write to
+		if dir := filepath.Dir(destination); !isDir(dir) {
+			return nil, fmt.Errorf("destination directory not found: %s", dir)
 		}
+		return args, nil
 	}
 
-	// If not found in destination, check source
-	if hostname == "" {
-		srcParts := strings.Split(src, ":")
-		if len(srcParts) > 1 {
-			hostParts := strings.Split(srcParts[0], "@")
-			if len(hostParts) == 2 {
-				hostname = hostParts[1]
+	// Check and expand the local sources
+	recursive := internal.HasShortOption(args, scpOptionsWithArgs, 'r')
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if !slices.Contains(operands[:len(operands)-1], i) {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		sources := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			if sources, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("invalid source pattern '%s': %w", arg, err)
 			}
 		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("source file not found: no files match '%s'", arg)
+		}
+
+		for _, source := range sources {
+			info, err := os.Stat(source)
+			if err != nil {
+				return nil, fmt.Errorf("source file not found: %s", source)
+			}
+			if info.IsDir() && !recursive {
+				return nil, fmt.Errorf("source '%s' is a directory (use -r to copy directories)", source)
+			}
+		}
+		expanded = append(expanded, sources...)
 	}
 
-	if hostname == "" {
-		return "", fmt.Errorf("could not identify target hostname in SCP arguments")
+	return expanded, nil
+}
+
+// expandLocalPaths expands a leading ~ in the local operands and in the identity and config file
+// options of scp arguments, as there is no shell to do it
+func expandLocalPaths(args []string, operands []int) {
+	for i, arg := range args {
+		option := i > 0 && (args[i-1] == "-i" || args[i-1] == "-F")
+		if option || slices.Contains(operands, i) && remoteHost(arg) == "" {
+			args[i] = expandHome(arg)
+		}
 	}
+}
 
-	// Resolve hostname to IP address
-	ips, err := net.LookupIP(hostname)
-	if err != nil || len(ips) == 0 {
-		return "", fmt.Errorf("failed to resolve hostname '%s': %w", hostname, err)
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// splitArgs splits a command line into arguments like a POSIX shell, honoring single and
+// double quotes and backslash escapes, without expanding anything. On Windows backslashes
+// separate paths and are kept as they are.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes characters the shell treats specially
+			if quote == '"' && !strings.ContainsRune(`"\$`+"`", r) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && runtime.GOOS != "windows":
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
 	}
 
-	// Find instance ID based on IP address
-	ip := ips[0].String()
-	instanceID, err := internal.FindInstanceIdByIp(ctx, *credential.awsConfig, ip)
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// scpTargetInstanceID returns the instance given by --instance-id, or otherwise the one the
+// remote host in the SCP arguments resolves to
+func scpTargetInstanceID(ctx context.Context, scpArgs []string) (string, error) {
+	target, err := instanceIDTarget("scp-instance-id")
 	if err != nil {
-		return "", fmt.Errorf("failed to find instance by IP '%s': %w", ip, err)
+		return "", err
+	}
+	if target != nil {
+		return target.Name, nil
+	}
+
+	return findTargetInstanceID(ctx, scpArgs, scpOptionsWithArgs)
+}
+
+// findTargetInstanceID identifies the instance ID for the SCP operation.
+// optionsWithArgs lists the short options of the underlying tool that consume the next argument.
+func findTargetInstanceID(ctx context.Context, args []string, optionsWithArgs string) (string, error) {
+	hosts := remoteHosts(args, optionsWithArgs)
+
+	switch len(hosts) {
+	case 0:
+		return "", fmt.Errorf("could not identify target hostname in arguments (expected [user@]host:path)")
+	case 1:
+		return resolveInstanceID(ctx, hosts[0])
+	default:
+		return "", fmt.Errorf("copying between two remote hosts (%s) is not supported (copy through a local path instead)",
+			strings.Join(hosts, ", "))
+	}
+}
+
+// remoteHosts returns the distinct hosts of the remote operands in args,
+// skipping options and the values of options listed in optionsWithArgs
+func remoteHosts(args []string, optionsWithArgs string) []string {
+	var hosts []string
+	for _, i := range internal.OperandIndexes(args, optionsWithArgs) {
+		host := remoteHost(args[i])
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// remoteHost returns the host of a [user@]host:path or scp://[user@]host[:port]/path
+// operand, or "" for a local path. IPv6 hosts may be given in brackets.
+func remoteHost(operand string) string {
+	// URI form
+	if rest, ok := strings.CutPrefix(operand, "scp://"); ok {
+		authority, _, _ := strings.Cut(rest, "/")
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			authority = authority[at+1:]
+		}
+		if host, _, err := net.SplitHostPort(authority); err == nil {
+			return host
+		}
+		return strings.Trim(authority, "[]")
+	}
+
+	// Strip the user, unless the @ is part of a local path
+	host := operand
+	if at := strings.Index(host, "@"); at >= 0 && !strings.Contains(host[:at], "/") {
+		host = host[at+1:]
+	}
+
+	// Bracketed IPv6 host
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]:")
+		if end < 0 {
+			return ""
+		}
+		return host[1:end]
+	}
+
+	// Like scp, a colon after a slash means the operand is a local path
+	colon := strings.Index(host, ":")
+	if colon <= 0 || strings.Contains(host[:colon], "/") {
+		return ""
+	}
+
+	return host[:colon]
+}
+
+// resolveInstanceID maps a host to an instance ID. Instance IDs are used as-is; otherwise
+// the host is resolved and its addresses are matched against instances, private ones first.
+// A host matching more than one instance is an error rather than a guess.
+func resolveInstanceID(ctx context.Context, host string) (string, error) {
+	if instanceIDPattern.MatchString(host) {
+		return host, nil
+	}
+
+	// Resolve hostname to IP addresses
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("failed to resolve hostname '%s': %w", host, err)
+	}
+
+	// A public address may belong to a NAT or load balancer, so only use public ones
+	// if no private address matches
+	var private, public []net.IP
+	for _, ip := range ips {
+		if ip.IsPrivate() {
+			private = append(private, ip)
+		} else {
+			public = append(public, ip)
+		}
 	}
 
-	if instanceID == "" {
-		return "", fmt.Errorf("no matching instance found for IP '%s'", ip)
+	tried := make([]string, 0, len(ips))
+	for _, group := range [][]net.IP{private, public} {
+		var instanceIDs []string
+		for _, ip := range group {
+			matches, err := internal.FindInstanceIdsByIp(ctx, *credential.awsConfig, ip.String())
+			if err != nil {
+				return "", fmt.Errorf("failed to find instance by IP '%s': %w", ip, err)
+			}
+			for _, instanceID := range matches {
+				if !slices.Contains(instanceIDs, instanceID) {
+					instanceIDs = append(instanceIDs, instanceID)
+				}
+			}
+			tried = append(tried, ip.String())
+		}
+
+		switch len(instanceIDs) {
+		case 0:
+			continue
+		case 1:
+			return instanceIDs[0], nil
+		default:
+			return "", fmt.Errorf("%w: '%s' matches %s", internal.ErrAmbiguousHost, host, strings.Join(instanceIDs, ", "))
+		}
 	}
 
-	return instanceID, nil
+	return "", fmt.Errorf("no matching instance found for '%s' (tried %s)", host, strings.Join(tried, ", "))
 }
 
 // displaySCPCommandInfo shows information about the SCP operation
@@ -161,14 +515,14 @@ func displaySCPCommandInfo(scpArgs, targetInstanceID string) {
 }
 
 // startSSHSession starts an SSH session through SSM
-func startSSHSession(ctx context.Context, targetInstanceID string) (*ssm.StartSessionOutput, error) {
+func startSSHSession(ctx context.Context, targetInstanceID string, parameters map[string][]string) (*ssm.StartSessionOutput, error) {
 	input := &ssm.StartSessionInput{
 		DocumentName: aws.String(documentNameSSH),
-		Parameters:   map[string][]string{"portNumber": {defaultSSHPort}},
+		Parameters:   parameters,
 		Target:       aws.String(targetInstanceID),
 	}
 
-	session, err := internal.CreateStartSession(ctx, *credential.awsConfig, input)
+	session, err := startSession(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create SSM session: %w", err)
 	}
@@ -177,24 +531,83 @@ func startSSHSession(ctx context.Context, targetInstanceID string) (*ssm.StartSe
 }
 
 // executeSCPCommand executes the SCP command with SSM as proxy
-func executeSCPCommand(scpArgs string, session *ssm.StartSessionOutput, targetInstanceID string) error {
+func executeSCPCommand(scpArgs []string, hostKeyArgs []string, session *ssm.StartSessionOutput, targetInstanceID string, parameters map[string][]string) error {
+	proxyCommand, err := scpProxyCommand(session, targetInstanceID, parameters)
+	if err != nil {
+		return err
+	}
+
+	// Build SCP command arguments
+	args := append([]string{"-o", proxyCommand}, withoutUserOptions(hostKeyArgs, scpArgs, scpOptionsWithArgs)...)
+	args = append(args, sshConnectOptions()...)
+	args = append(args, scpArgs...)
+
+	// Execute SCP command
+	return internal.CallProcess(transferProcessOptions(session.SessionId, targetInstanceID), "scp", args...)
+}
+
+// executeSCPBatch executes several SCP transfers over a single SSM session. The first transfer
+// starts a shared ssh connection (ControlMaster) that the following transfers reuse, as the
+// session can only carry one connection. Transfers stop at the first failure.
+func executeSCPBatch(transfers [][]string, hostKeyArgs []string, session *ssm.StartSessionOutput, targetInstanceID string, parameters map[string][]string) error {
+	proxyCommand, err := scpProxyCommand(session, targetInstanceID, parameters)
+	if err != nil {
+		return err
+	}
+
+	// Keep the control socket in a private directory
+	controlDir, err := os.MkdirTemp("", "gossm-scp-")
+	if err != nil {
+		return fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	defer os.RemoveAll(controlDir)
+	controlPath := "ControlPath=" + filepath.Join(controlDir, "control")
+
+	// Stop the shared connection, which outlives the transfer that started it
+	defer func() {
+		if output, err := exec.Command("ssh", "-o", controlPath, "-O", "exit", targetInstanceID).CombinedOutput(); err != nil {
+			internal.Debugf("Failed to stop shared ssh connection: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+	}()
+
+	for i, scpArgs := range transfers {
+		// The first transfer connects through the plugin and starts the shared connection
+		args := []string{"-o", controlPath, "-o", "ControlMaster=no"}
+		if i == 0 {
+			args = []string{"-o", controlPath, "-o", "ControlMaster=yes", "-o", "ControlPersist=yes", "-o", proxyCommand}
+		}
+		args = append(args, withoutUserOptions(hostKeyArgs, scpArgs, scpOptionsWithArgs)...)
+		args = append(args, sshConnectOptions()...)
+		args = append(args, scpArgs...)
+
+		internal.Infof("[%d/%d] scp %s", i+1, len(transfers), strings.Join(scpArgs, " "))
+		if err := internal.CallProcess(transferProcessOptions(session.SessionId, targetInstanceID), "scp", args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scpProxyCommand returns the ssh ProxyCommand option that connects through the SSM session
+func scpProxyCommand(session *ssm.StartSessionOutput, targetInstanceID string, parameters map[string][]string) (string, error) {
 	// Marshal session information to JSON
 	sessionJSON, err := json.Marshal(session)
 	if err != nil {
-		return fmt.Errorf("failed to marshal session data: %w", err)
+		return "", fmt.Errorf("failed to marshal session data: %w", err)
 	}
 
 	// Create parameter input for the SSM plugin
 	input := &ssm.StartSessionInput{
 		DocumentName: aws.String(documentNameSSH),
-		Parameters:   map[string][]string{"portNumber": {defaultSSHPort}},
+		Parameters:   parameters,
 		Target:       aws.String(targetInstanceID),
 	}
 
 	// Marshal parameters to JSON
 	paramsJSON, err := json.Marshal(input)
 	if err != nil {
-		return fmt.Errorf("failed to marshal session parameters: %w", err)
+		return "", fmt.Errorf("failed to marshal session parameters: %w", err)
 	}
 
 	// Build proxy command for SCP
@@ -206,26 +619,29 @@ func executeSCPCommand(scpArgs string, session *ssm.StartSessionOutput, targetIn
 		credential.awsProfile,
 		string(paramsJSON),
 	)
+	internal.Tracef("ProxyCommand: %s", proxyCommand)
 
-	// Build SCP command arguments
-	args := []string{"-o", proxyCommand}
-	for _, arg := range strings.Fields(scpArgs) {
-		if arg != "" {
-			args = append(args, arg)
-		}
-	}
-
-	// Execute SCP command
-	return internal.CallProcess("scp", args...)
+	return proxyCommand, nil
 }
 
 func init() {
 	// Define command flags
 	scpCommand.Flags().StringP("exec", "e", "", "SCP command arguments (e.g., \"-r localfile user@instance:/remote/path\")")
-	scpCommand.MarkFlagRequired("exec")
+	scpCommand.Flags().String("instance-id", "", "Copy to or from this instance ID (i-... or mi-...) instead of resolving the host")
+	scpCommand.Flags().String("strict-host-key-checking", defaultHostKeyChecking, "Host key checking mode: yes, no or accept-new")
+	scpCommand.Flags().String("port", "", "sshd port on the instance (default: -P from --exec, or 22)")
+	scpCommand.Flags().StringArray("param", nil, "Extra session document parameter as key=value (repeatable)")
+	scpCommand.Flags().String("batch", "", "File with the SCP arguments of one transfer per line, all over one session (- for stdin)")
+	scpCommand.MarkFlagsOneRequired("exec", "batch")
+	scpCommand.MarkFlagsMutuallyExclusive("exec", "batch")
 
 	// Bind flags to viper
 	viper.BindPFlag("scp-exec", scpCommand.Flags().Lookup("exec"))
+	viper.BindPFlag("scp-strict-host-key-checking", scpCommand.Flags().Lookup("strict-host-key-checking"))
+	viper.BindPFlag("scp-port", scpCommand.Flags().Lookup("port"))
+	viper.BindPFlag("scp-param", scpCommand.Flags().Lookup("param"))
+	viper.BindPFlag("scp-instance-id", scpCommand.Flags().Lookup("instance-id"))
+	viper.BindPFlag("scp-batch", scpCommand.Flags().Lookup("batch"))
 
 	// Add command to root
 	rootCmd.AddCommand(scpCommand)
@@ -1 +1,142 @@
 package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+		posix   bool // Backslash escapes only apply outside Windows
+	}{
+		{line: "a.txt user@host:/tmp/", want: []string{"a.txt", "user@host:/tmp/"}},
+		{line: "  -r   dir  host:  ", want: []string{"-r", "dir", "host:"}},
+		{line: `"my file.txt" 'user@host:/tmp/a b/'`, want: []string{"my file.txt", "user@host:/tmp/a b/"}},
+		{line: `it"'"s host:`, want: []string{"it's", "host:"}},
+		{line: `"" host:`, want: []string{"", "host:"}},
+		{line: `my\ file.txt "a\"b" host:`, want: []string{"my file.txt", `a"b`, "host:"}, posix: true},
+		{line: `"unterminated host:`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if tt.posix && runtime.GOOS == "windows" {
+			continue
+		}
+
+		got, err := splitArgs(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSCPArguments(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "with space.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := func(name string) string { return filepath.ToSlash(filepath.Join(dir, name)) }
+	useHomeDir(t, dir)
+
+	tests := []struct {
+		name    string
+		args    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "tilde paths are expanded",
+			args: "-i ~/.ssh/key ~/a.txt host:~/uploads/",
+			want: []string{"-i", path(".ssh/key"), path("a.txt"), "host:~/uploads/"},
+		},
+		{
+			name: "upload with quoted space",
+			args: `-P 2222 "` + path("with space.txt") + `" ec2-user@host:/tmp/`,
+			want: []string{"-P", "2222", path("with space.txt"), "ec2-user@host:/tmp/"},
+		},
+		{
+			name: "glob is expanded",
+			args: path("*.txt") + " host:/tmp/",
+			want: []string{path("a.txt"), path("b.txt"), path("with space.txt"), "host:/tmp/"},
+		},
+		{
+			name: "recursive directory",
+			args: "-rp " + path("sub") + " host:/tmp/",
+			want: []string{"-rp", path("sub"), "host:/tmp/"},
+		},
+		{
+			name: "download",
+			args: "host:/var/log/*.log " + dir,
+			want: []string{"host:/var/log/*.log", dir},
+		},
+		{name: "directory without -r", args: path("sub") + " host:/tmp/", wantErr: "use -r"},
+		{name: "missing source", args: path("missing.txt") + " host:/tmp/", wantErr: "source file not found"},
+		{name: "no glob match", args: path("*.zip") + " host:/tmp/", wantErr: "source file not found"},
+		{name: "no remote side", args: path("a.txt") + " " + path("b.txt"), wantErr: "missing remote path"},
+		{name: "both remote", args: "host:/a host:/b", wantErr: "both remote"},
+		{name: "missing destination", args: "-r " + path("sub"), wantErr: "must include source and destination"},
+		{name: "missing download directory", args: "host:/a " + path("nope/b.txt"), wantErr: "destination directory not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateSCPArguments(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateSCPArguments(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateSCPArguments(%q) error = %v", tt.args, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("validateSCPArguments(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSCPPortFromOptions(t *testing.T) {
+	tests := []struct {
+		transfers []string
+		want      string
+	}{
+		{transfers: []string{"./app.tar ec2-user@web:/tmp/"}, want: defaultSSHPort},
+		{transfers: []string{"-P 2222 ./app.tar ec2-user@web:/tmp/"}, want: "2222"},
+		{transfers: []string{"-r -P2222 ./dist ec2-user@web:/opt/"}, want: "2222"},
+		{transfers: []string{"-- -P ec2-user@web:/tmp/"}, want: defaultSSHPort},
+		{transfers: []string{"./a ec2-user@web:/tmp/", "-P 2222 ./b ec2-user@web:/tmp/"}, want: "2222"},
+	}
+
+	for _, tt := range tests {
+		var transfers [][]string
+		for _, transfer := range tt.transfers {
+			transfers = append(transfers, strings.Fields(transfer))
+		}
+		got, err := sshPort("", scpOptions(transfers), "-P")
+		if err != nil {
+			t.Errorf("sshPort(%q) returned error: %v", tt.transfers, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sshPort(%q) = %q, want %q", tt.transfers, got, tt.want)
+		}
+	}
+}
@@ -2,19 +2,39 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"github.com/ottramst/gossm/internal"
 )
 
+const (
+	// defaultReconnectAttempts is how often --reconnect without a number reconnects a dropped session
+	defaultReconnectAttempts = 3
+
+	// reconnectDelay is how long to wait before reconnecting, giving the network time to recover
+	reconnectDelay = 2 * time.Second
+)
+
 var (
+	// openSessions maps the IDs of sessions created by this process that are not yet terminated to their region
+	openSessions   = map[string]string{}
+	openSessionsMu sync.Mutex
+
 	// startSessionCommand is the Cobra command for starting an SSM session
 	startSessionCommand = &cobra.Command{
 		Use:   "start",
@@ -24,54 +44,164 @@ var (
 This command establishes a secure session with an EC2 instance without requiring SSH access or
 opening inbound ports. It uses the AWS SSM agent running on the target instance.
 
-Escape Sequence:
+Escape Sequences:
   Enter ~.   Disconnect from the session (useful when network is stuck)
+  Enter ~#   Show the session ID, target and region
+  Enter ~?   List the supported escape sequences
+
+Session Logs:
+  --log-file records everything the session prints (and with --log-input, everything typed)
+  to a file, or to a timestamped file if given a directory. The log is plaintext and captures
+  anything sensitive shown or typed during the session, such as passwords or secrets.
+
+Session Limits:
+  --idle-timeout terminates the session after a period without input or output, and
+  --max-duration once it has been open for a given time, to avoid forgotten sessions.
+
+Reconnecting:
+  --reconnect creates a new session to the same instance when the session drops, e.g. after a
+  network change, up to 3 times (--reconnect=<number> to change). Leaving with ~. or exit, and
+  reaching a session limit, still end the session for good.
+
+Multiple Sessions:
+  --multi lets you choose several instances and opens a session to each in its own pane of a new
+  tmux window when run inside tmux. Outside tmux, it prints the gossm command for each instance.
+  Each session is a separate gossm process that terminates its session when it exits.
+
+Session Documents:
+  By default the session uses the account's standard shell document. --document selects another
+  SSM session document, such as AWS-StartInteractiveCommand or a custom document that restricts
+  the shell, and --param passes its parameters as key=value pairs (repeatable), for example
+  runAsUser or shellProfile for the standard document.
 
 Example:
-  gossm start              # Interactive instance selection
-  gossm start -t i-1234    # Connect to a specific instance ID
+  gossm start                          # Interactive instance selection
+  gossm start -t i-1234                # Connect to a specific instance ID
+  gossm start --recent=2               # Reconnect to the second target listed by gossm recent
+  gossm start --log-file ~/gossm-logs  # Record the session output to a timestamped file
+  gossm start --max-duration 8h        # Terminate the session after 8 hours
+  gossm start -t i-1234 --reconnect    # Reconnect up to 3 times if the session drops
+  gossm start --multi                  # Open sessions to several instances in tmux panes
+  gossm start --document AWS-StartInteractiveCommand --param command="top -c"
 `,
-		Run: runStartSession,
+		PersistentPreRun: setupPlugin,
+		Run:              runStartSession,
 	}
 )
 
 // runStartSession executes the start-session operation
 func runStartSession(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	ctx, stop := newSignalContext()
+	defer stop()
 
-	// Get target instance
-	target, err := getTargetInstance(ctx)
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
+
+	// Parse session parameters and limits before selecting a target so mistakes fail fast
+	parameters, err := parseSessionParameters(viper.GetStringSlice("start-session-param"))
 	if err != nil {
 		logErrorAndExit(err)
 	}
+	if err := validateSessionLimits("start-session"); err != nil {
+		logErrorAndExit(err)
+	}
+	if reconnects := viper.GetInt("start-session-reconnect"); reconnects < 0 {
+		logErrorAndExit(fmt.Errorf("invalid --reconnect %d: must not be negative", reconnects))
+	}
 
-	// Display information
-	internal.PrintReady("start-session", credential.awsConfig.Region, target.Name)
+	// Several targets each get a gossm process of their own
+	if viper.GetBool("start-session-multi") {
+		runMultiSession(ctx, cmd.Flags())
+		return
+	}
 
-	// Start session
-	session, err := createSession(ctx, target.Name)
+	// Get target instance, skipping discovery if it is a recent target or its ID is given
+	target, err := recentTarget(cmd.Flags())
+	if err == nil && target == nil {
+		target, err = instanceIDTarget("start-session-instance-id")
+	}
+	if err == nil && target == nil {
+		target, err = getTargetInstance(ctx)
+	}
 	if err != nil {
 		logErrorAndExit(err)
 	}
 
-	// Execute session
-	if err := executeSession(session, target.Name); err != nil {
-		color.Red("%v", err)
+	// Display information
+	internal.PrintReady("start-session", credential.awsConfig.Region, target.Name)
+
+	// Start session
+	input := &ssm.StartSessionInput{
+		Target:     aws.String(target.Name),
+		Parameters: parameters,
+	}
+	if document := viper.GetString("start-session-document"); document != "" {
+		input.DocumentName = aws.String(document)
 	}
 
-	// Clean up
-	if err := terminateSession(ctx, session.SessionId); err != nil {
+	session, err := createSession(ctx, input)
+	if err != nil {
 		logErrorAndExit(err)
 	}
+	rememberRecentTarget(target)
+
+	// Execute the session, reconnecting if requested
+	runSession(ctx, session, input, viper.GetInt("start-session-reconnect"))
 }
 
-// createSession creates a new SSM session to the target instance
-func createSession(ctx context.Context, targetName string) (*ssm.StartSessionOutput, error) {
-	input := &ssm.StartSessionInput{
-		Target: aws.String(targetName),
+// runSession executes the session and terminates it. Each time the plugin exits with an error,
+// a new session to the same target is created, up to maxReconnects times in total.
+func runSession(ctx context.Context, session *ssm.StartSessionOutput, input *ssm.StartSessionInput, maxReconnects int) {
+	for reconnects := 0; ; {
+		err := executeSession(session, input)
+		if err != nil {
+			color.Red("%v", internal.TranslateError(err))
+		}
+
+		// Clean up, a dropped session may already be gone
+		reconnect := reconnects < maxReconnects && sessionDropped(ctx, err)
+		if err := terminateSession(ctx, session.SessionId); err != nil {
+			if !reconnect {
+				logErrorAndExit(err)
+			}
+			internal.Debugf("Failed to terminate dropped session: %v", err)
+		}
+		if !reconnect {
+			return
+		}
+
+		// Create a new session, retrying while reconnects are left
+		for session = nil; session == nil; {
+			reconnects++
+			internal.Warnf("Session dropped, reconnecting to %s in %s (%d of %d)",
+				aws.ToString(input.Target), reconnectDelay, reconnects, maxReconnects)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+
+			session, err = createSession(ctx, input)
+			if err != nil {
+				if reconnects >= maxReconnects || ctx.Err() != nil {
+					logErrorAndExit(err)
+				}
+				internal.Warnf("%v", internal.TranslateError(err))
+			}
+		}
 	}
+}
 
-	session, err := internal.CreateStartSession(ctx, *credential.awsConfig, input)
+// sessionDropped reports whether the plugin exited because the session failed, rather than
+// after ~., a normal exit, a signal or a session limit
+func sessionDropped(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil &&
+		!errors.Is(err, internal.ErrSessionLimit) && !errors.Is(err, internal.ErrEscapeDisconnect)
+}
+
+// createSession creates a new SSM session to the target instance
+func createSession(ctx context.Context, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	session, err := startSession(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
@@ -80,45 +210,250 @@ func createSession(ctx context.Context, targetName string) (*ssm.StartSessionOut
 }
 
 // executeSession executes the interactive session using the SSM plugin
-func executeSession(session *ssm.StartSessionOutput, targetName string) error {
-	// Marshal session to JSON
-	sessionJSON, err := json.Marshal(session)
+func executeSession(session *ssm.StartSessionOutput, input *ssm.StartSessionInput) error {
+	targetName := aws.ToString(input.Target)
+
+	// Record and limit the session if requested
+	opts := pluginProcessOptions(session.SessionId, targetName)
+	applySessionLimits(&opts, "start-session")
+	closeLog, err := attachSessionLog(&opts, viper.GetString("start-session-log-file"), viper.GetBool("start-session-log-input"))
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	// Execute the session
+	return internal.CallSessionPlugin(opts, credential.ssmPluginPath, session, input,
+		credential.awsConfig.Region, credential.awsProfile)
+}
+
+// parseSessionParameters parses key=value session document parameters.
+// Repeating a key passes several values for that parameter.
+func parseSessionParameters(pairs []string) (map[string][]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	parameters := make(map[string][]string)
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid session parameter '%s' (expected key=value)", pair)
+		}
+		parameters[key] = append(parameters[key], value)
+	}
+
+	return parameters, nil
+}
+
+// mergeSessionParameters adds user-supplied key=value parameters to the parameters a command
+// sets for its session document. The command's own parameters cannot be overridden.
+func mergeSessionParameters(required map[string][]string, pairs []string) (map[string][]string, error) {
+	extra, err := parseSessionParameters(pairs)
 	if err != nil {
-		return fmt.Errorf("failed to marshal session: %w", err)
+		return nil, err
+	}
+
+	merged := make(map[string][]string, len(required)+len(extra))
+	for key, values := range required {
+		merged[key] = values
+	}
+	for key, values := range extra {
+		if _, ok := required[key]; ok {
+			return nil, fmt.Errorf("session parameter '%s' is set by gossm and cannot be passed with --param", key)
+		}
+		merged[key] = values
 	}
 
-	// Marshal session parameters to JSON
-	paramsJSON, err := json.Marshal(&ssm.StartSessionInput{
-		Target: aws.String(targetName),
+	return merged, nil
+}
+
+// attachSessionLog opens the transcript file at path, if one is requested, and attaches it to opts.
+// If path is a directory, a timestamped file named after the target is created in it.
+// The returned function closes the file.
+func attachSessionLog(opts *internal.ProcessOptions, path string, logInput bool) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+
+	now := time.Now()
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, fmt.Sprintf("gossm-%s-%s.log", opts.Target, now.Format("20060102-150405")))
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session log: %w", err)
+	}
+
+	fmt.Fprintf(file, "# gossm session %s to %s (%s) started %s\n",
+		opts.SessionID, opts.Target, opts.Region, now.Format(time.RFC3339))
+	internal.Warnf("Recording session to %s in plaintext, including anything sensitive typed or shown", path)
+
+	opts.Transcript = file
+	opts.TranscriptInput = logInput
+
+	return func() { file.Close() }, nil
+}
+
+// validateSessionLimits checks the --idle-timeout and --max-duration flags bound to viper under
+// prefix, so mistakes fail before a session is created
+func validateSessionLimits(prefix string) error {
+	for _, name := range []string{"idle-timeout", "max-duration"} {
+		if value := viper.GetDuration(prefix + "-" + name); value < 0 {
+			return fmt.Errorf("invalid --%s %s: must not be negative", name, value)
+		}
+	}
+	return nil
+}
+
+// applySessionLimits sets the idle timeout and maximum duration bound to viper under prefix on opts
+func applySessionLimits(opts *internal.ProcessOptions, prefix string) {
+	opts.IdleTimeout = viper.GetDuration(prefix + "-idle-timeout")
+	opts.MaxDuration = viper.GetDuration(prefix + "-max-duration")
+}
+
+// startSession creates an SSM session and tracks it until it is terminated,
+// so it can be cleaned up on any exit path
+func startSession(ctx context.Context, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	if err := checkAgentOnline(ctx, aws.ToString(input.Target)); err != nil {
+		return nil, err
+	}
+
+	session, err := internal.CreateStartSession(ctx, *credential.awsConfig, input)
+	if err != nil {
+		return nil, err
+	}
+
+	trackSession(session.SessionId, aws.ToString(input.Target))
+	return session, nil
+}
+
+// trackSession records a session created in the current region until it is terminated
+func trackSession(sessionID *string, target string) {
+	openSessionsMu.Lock()
+	openSessions[aws.ToString(sessionID)] = credential.awsConfig.Region
+	openSessionsMu.Unlock()
+
+	// Persist the session so it can be found with `gossm sessions` if this process crashes
+	updateState(func(state *internal.State) {
+		state.AddSession(aws.ToString(sessionID), internal.SessionRecord{
+			Profile: credential.awsProfile,
+			Region:  credential.awsConfig.Region,
+			Target:  target,
+			Started: time.Now(),
+		})
 	})
+}
+
+// checkAgentOnline verifies that the SSM agent of the target is online right before a session
+// is created, so an offline agent fails immediately instead of hanging the plugin. If the status
+// can't be determined, e.g. without ssm:DescribeInstanceInformation, the session is attempted anyway.
+func checkAgentOnline(ctx context.Context, target string) error {
+	// Only managed instances report an agent status
+	if !instanceIDPattern.MatchString(target) {
+		return nil
+	}
+
+	status, err := internal.GetAgentPingStatus(ctx, *credential.awsConfig, target)
+	if errors.Is(err, internal.ErrAgentOffline) {
+		return err
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal session parameters: %w", err)
+		internal.Debugf("Skipping SSM agent check: %v", err)
+		return nil
 	}
 
-	// Execute the session
-	return internal.CallProcess(
-		credential.ssmPluginPath,
-		string(sessionJSON),
-		credential.awsConfig.Region,
-		"StartSession",
-		credential.awsProfile,
-		string(paramsJSON),
-	)
+	if status != ssmtypes.PingStatusOnline {
+		return fmt.Errorf("%w: %s reports %s", internal.ErrAgentOffline, target, status)
+	}
+
+	return nil
 }
 
-// terminateSession terminates the SSM session
+// terminateSession terminates the SSM session in the region it was created in.
+// The session is cleaned up even if ctx was canceled by an interrupt.
 func terminateSession(ctx context.Context, sessionID *string) error {
-	return internal.DeleteStartSession(ctx, *credential.awsConfig, &ssm.TerminateSessionInput{
+	openSessionsMu.Lock()
+	region, ok := openSessions[aws.ToString(sessionID)]
+	delete(openSessions, aws.ToString(sessionID))
+	openSessionsMu.Unlock()
+
+	cfg := credential.awsConfig.Copy()
+	if ok {
+		cfg.Region = region
+	}
+
+	if err := internal.DeleteStartSession(context.WithoutCancel(ctx), cfg, &ssm.TerminateSessionInput{
 		SessionId: sessionID,
+	}); err != nil {
+		return err
+	}
+
+	updateState(func(state *internal.State) {
+		state.RemoveSession(aws.ToString(sessionID))
 	})
+
+	return nil
+}
+
+// terminateOpenSessions terminates every session created by this process that is still open.
+// Commands defer it and logErrorAndExit calls it, so no session outlives the process.
+func terminateOpenSessions() {
+	openSessionsMu.Lock()
+	sessionIDs := make([]string, 0, len(openSessions))
+	for sessionID := range openSessions {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	openSessionsMu.Unlock()
+
+	for _, sessionID := range sessionIDs {
+		if err := terminateSession(context.Background(), aws.String(sessionID)); err != nil {
+			internal.Warnf("Failed to terminate session %s: %v", sessionID, err)
+		}
+	}
 }
 
 func init() {
 	// Define command flags
 	startSessionCommand.Flags().StringP("target", "t", "", "Target EC2 instance ID (will prompt if not specified)")
+	startSessionCommand.Flags().String("instance-id", "", "Connect to this instance ID (i-... or mi-...) without discovering instances")
+	startSessionCommand.Flags().String("document", "", "SSM session document to use (e.g., AWS-StartInteractiveCommand)")
+	startSessionCommand.Flags().StringArray("param", nil, "Session document parameter as key=value (repeatable)")
+	startSessionCommand.Flags().String("log-file", "", "Record session output to this file (or a timestamped file in this directory)")
+	startSessionCommand.Flags().Bool("log-input", false, "Also record session input to the --log-file (captures anything typed, including secrets)")
+	startSessionCommand.Flags().Duration("idle-timeout", 0, "Terminate the session after this long without input or output (e.g. 30m, 0 disables)")
+	startSessionCommand.Flags().Duration("max-duration", 0, "Terminate the session after it has been open this long (e.g. 8h, 0 disables)")
+
+	startSessionCommand.Flags().Int("recent", 0, "Reconnect to the recent target with this number from gossm recent, as --recent=<number> (choose from the list if no number is given)")
+	startSessionCommand.Flags().Lookup("recent").NoOptDefVal = "0"
+	startSessionCommand.Flags().Int("reconnect", 0, fmt.Sprintf("Reconnect to the same instance when the session drops, up to %d times or as often as --reconnect=<number> says", defaultReconnectAttempts))
+	startSessionCommand.Flags().Lookup("reconnect").NoOptDefVal = strconv.Itoa(defaultReconnectAttempts)
+
+	startSessionCommand.Flags().Bool("multi", false, "Choose several instances and open a session to each in tmux panes, or print their commands outside tmux")
+
+	startSessionCommand.MarkFlagsMutuallyExclusive("target", "instance-id", "recent", "multi")
+
+	// Accept --parameters as an alias of --param
+	startSessionCommand.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "parameters" {
+			name = "param"
+		}
+		return pflag.NormalizedName(name)
+	})
 
 	// Bind flags to viper
 	viper.BindPFlag("start-session-target", startSessionCommand.Flags().Lookup("target"))
+	viper.BindPFlag("start-session-instance-id", startSessionCommand.Flags().Lookup("instance-id"))
+	viper.BindPFlag("start-session-document", startSessionCommand.Flags().Lookup("document"))
+	viper.BindPFlag("start-session-param", startSessionCommand.Flags().Lookup("param"))
+	viper.BindPFlag("start-session-log-file", startSessionCommand.Flags().Lookup("log-file"))
+	viper.BindPFlag("start-session-log-input", startSessionCommand.Flags().Lookup("log-input"))
+	viper.BindPFlag("start-session-idle-timeout", startSessionCommand.Flags().Lookup("idle-timeout"))
+	viper.BindPFlag("start-session-max-duration", startSessionCommand.Flags().Lookup("max-duration"))
+	viper.BindPFlag("start-session-reconnect", startSessionCommand.Flags().Lookup("reconnect"))
+	viper.BindPFlag("start-session-multi", startSessionCommand.Flags().Lookup("multi"))
 
 	// Add command to root
 	rootCmd.AddCommand(startSessionCommand)
@@ -1 +1,126 @@
 package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+func TestSessionDropped(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "plugin failed", ctx: context.Background(), err: errors.New("exit status 255"), want: true},
+		{name: "normal exit", ctx: context.Background(), err: nil, want: false},
+		{name: "session limit", ctx: context.Background(), err: fmt.Errorf("%w: idle", internal.ErrSessionLimit), want: false},
+		{name: "escape sequence", ctx: context.Background(), err: fmt.Errorf("%w: exit status 1", internal.ErrEscapeDisconnect), want: false},
+		{name: "interrupted", ctx: canceled, err: errors.New("exit status 1"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := sessionDropped(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: sessionDropped() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMultiSessionArgs(t *testing.T) {
+	flags := pflag.NewFlagSet("start", pflag.ContinueOnError)
+	flags.Bool("multi", false, "")
+	flags.String("region", "", "")
+	flags.StringSlice("filter", nil, "")
+	flags.StringArray("param", nil, "")
+	flags.Duration("idle-timeout", 0, "")
+	flags.Bool("log-input", false, "")
+	if err := flags.Parse([]string{"--multi", "--region", "all", "--filter", "Env=prod",
+		"--param", "runAsUser=ops", "--param", "shellProfile=bash -l", "--idle-timeout", "30m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := multiSessionArgs("/usr/local/bin/gossm", flags, "i-0123456789abcdef0", "prod", "eu-west-1")
+	want := []string{"/usr/local/bin/gossm", "start", "--instance-id", "i-0123456789abcdef0", "--region", "eu-west-1",
+		"--profile", "prod", "--idle-timeout=30m0s", "--param=runAsUser=ops", "--param=shellProfile=bash -l"}
+	if !slices.Equal(got, want) {
+		t.Errorf("multiSessionArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestMultiSessionArgsLogFile(t *testing.T) {
+	viper.Set("start-session-log-file", "/var/log/gossm/session.log")
+	t.Cleanup(func() { viper.Set("start-session-log-file", "") })
+
+	got := multiSessionArgs("gossm", pflag.NewFlagSet("start", pflag.ContinueOnError), "i-0123456789abcdef0", "", "eu-west-1")
+	if want := "--log-file=/var/log/gossm/session.i-0123456789abcdef0.log"; got[len(got)-1] != want {
+		t.Errorf("multiSessionArgs() = %q, want it to end with %q", got, want)
+	}
+}
+
+func TestSessionLogFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: dir, want: dir},
+		{path: "session.log", want: "session.i-1.log"},
+		{path: filepath.Join(dir, "transcript"), want: filepath.Join(dir, "transcript.i-1")},
+	}
+
+	for _, tt := range tests {
+		if got := sessionLogFile(tt.path, "i-1"); got != tt.want {
+			t.Errorf("sessionLogFile(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestStartSessionCreatesAndTracksSession(t *testing.T) {
+	var started int
+	useFakeAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.DescribeInstanceInformation":
+			fmt.Fprint(w, `{"InstanceInformationList":[{"InstanceId":"i-0123456789abcdef0","PingStatus":"Online"}]}`)
+		case "AmazonSSM.StartSession":
+			started++
+			fmt.Fprint(w, `{"SessionId":"gossm-0123","StreamUrl":"wss://example.com","TokenValue":"token"}`)
+		default:
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+		}
+	})
+	t.Cleanup(func() {
+		openSessionsMu.Lock()
+		delete(openSessions, "gossm-0123")
+		openSessionsMu.Unlock()
+	})
+
+	session, err := startSession(context.Background(), &ssm.StartSessionInput{Target: aws.String("i-0123456789abcdef0")})
+	if err != nil {
+		t.Fatalf("startSession() error = %v", err)
+	}
+	if aws.ToString(session.SessionId) != "gossm-0123" || started != 1 {
+		t.Errorf("startSession() = %s after %d StartSession calls, want gossm-0123 after 1", aws.ToString(session.SessionId), started)
+	}
+
+	openSessionsMu.Lock()
+	region, ok := openSessions["gossm-0123"]
+	openSessionsMu.Unlock()
+	if !ok || region != "eu-west-1" {
+		t.Errorf("session tracked in %q (%v), want eu-west-1", region, ok)
+	}
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+var (
+	// sessionsCommand is the Cobra command for listing active SSM sessions
+	sessionsCommand = &cobra.Command{
+		Use:   "sessions",
+		Short: "List your active SSM sessions",
+		Long: `List the active SSM sessions owned by the current AWS identity.
+
+Sessions started by gossm are normally terminated when it exits, but a crash or a killed
+terminal can leave them open on AWS. Sessions gossm started and never terminated are marked
+in the GOSSM column; terminate them with the kill subcommand.
+
+Examples:
+  gossm sessions                  # List active sessions in the current region
+  gossm sessions -r all           # List active sessions in every enabled region
+  gossm sessions kill --all       # Terminate all of your active sessions, after confirming
+  gossm sessions kill <id> ...    # Terminate specific sessions of yours
+  gossm sessions kill --all --yes # Terminate without asking, e.g. from a script
+`,
+		Args: cobra.NoArgs,
+		Run:  runListSessions,
+	}
+
+	// sessionsKillCommand is the Cobra command for terminating SSM sessions
+	sessionsKillCommand = &cobra.Command{
+		Use:   "kill [session-id...]",
+		Short: "Terminate active SSM sessions",
+		Run:   runKillSessions,
+	}
+)
+
+// runListSessions prints the active sessions of the current identity
+func runListSessions(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	sessions, err := findActiveSessions(ctx)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	state, err := internal.LoadState(statePath())
+	if err != nil {
+		internal.Warnf("Ignoring state file: %v", err)
+		state = &internal.State{}
+	}
+
+	if len(sessions) == 0 {
+		color.Yellow("No active sessions")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SESSION ID\tTARGET\tREGION\tSTARTED\tGOSSM")
+		for _, session := range sessions {
+			startedByGossm := ""
+			if _, ok := state.Sessions[aws.ToString(session.SessionId)]; ok {
+				startedByGossm = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				aws.ToString(session.SessionId),
+				aws.ToString(session.Target),
+				session.region,
+				aws.ToTime(session.StartDate).Local().Format(time.DateTime),
+				startedByGossm,
+			)
+		}
+		w.Flush()
+	}
+
+	// Forget recorded sessions of this profile that are no longer active
+	pruneSessionRecords(sessions)
+}
+
+// runKillSessions terminates the given sessions, or all active sessions with --all, after
+// showing them and asking for confirmation
+func runKillSessions(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	all := viper.GetBool("sessions-kill-all")
+	if all == (len(args) > 0) {
+		logErrorAndExit(fmt.Errorf("specify session IDs or --all (but not both)"))
+	}
+
+	var sessions []regionalSession
+	var err error
+	if all {
+		sessions, err = findActiveSessions(ctx)
+	} else {
+		sessions, err = findSessionsByID(ctx, args)
+	}
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	if len(sessions) == 0 {
+		color.Yellow("No active sessions")
+		return
+	}
+
+	// Show what is about to be terminated, as other people may rely on sessions of a shared role
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION ID\tTARGET\tOWNER\tREGION\tSTARTED")
+	for _, session := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			aws.ToString(session.SessionId),
+			aws.ToString(session.Target),
+			aws.ToString(session.Owner),
+			session.region,
+			aws.ToTime(session.StartDate).Local().Format(time.DateTime),
+		)
+	}
+	w.Flush()
+
+	if err := confirmKillSessions(len(sessions)); err != nil {
+		logErrorAndExit(err)
+	}
+
+	var failed int
+	for _, session := range sessions {
+		cfg := credential.awsConfig.Copy()
+		cfg.Region = session.region
+
+		sessionID := aws.ToString(session.SessionId)
+		if err := internal.DeleteStartSession(ctx, cfg, &ssm.TerminateSessionInput{
+			SessionId: session.SessionId,
+		}); err != nil {
+			color.Red("[err] %v", internal.TranslateError(err))
+			failed++
+			continue
+		}
+
+		updateState(func(state *internal.State) {
+			state.RemoveSession(sessionID)
+		})
+	}
+
+	if failed > 0 {
+		logErrorAndExit(fmt.Errorf("failed to terminate %d of %d sessions", failed, len(sessions)))
+	}
+}
+
+// findSessionsByID looks up the given active sessions in the region gossm recorded them in, or
+// the current region. Sessions owned by another identity are refused unless --any-owner is set.
+func findSessionsByID(ctx context.Context, sessionIDs []string) ([]regionalSession, error) {
+	state, err := internal.LoadState(statePath())
+	if err != nil {
+		internal.Warnf("Ignoring state file: %v", err)
+		state = &internal.State{}
+	}
+
+	owner := ""
+	if !viper.GetBool("sessions-kill-any-owner") {
+		if owner, err = getCallerARN(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var sessions []regionalSession
+	for _, sessionID := range sessionIDs {
+		cfg := credential.awsConfig.Copy()
+		if record, ok := state.Sessions[sessionID]; ok {
+			cfg.Region = record.Region
+		}
+
+		session, err := internal.FindActiveSession(ctx, cfg, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" && aws.ToString(session.Owner) != owner {
+			return nil, fmt.Errorf("session %s is owned by %s, pass --any-owner to terminate it anyway",
+				sessionID, aws.ToString(session.Owner))
+		}
+		sessions = append(sessions, regionalSession{Session: session, region: cfg.Region})
+	}
+
+	return sessions, nil
+}
+
+// confirmKillSessions asks for confirmation before sessions are terminated. Without a terminal
+// to ask on, --yes is required instead.
+func confirmKillSessions(count int) error {
+	if viper.GetBool("sessions-kill-yes") {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%w: terminating %d session(s) needs --yes when not run interactively", internal.ErrNotConfirmed, count)
+	}
+	return internal.ConfirmTerminateSessions(count)
+}
+
+// regionalSession is an active session together with the region it was found in
+type regionalSession struct {
+	ssmtypes.Session
+	region string
+}
+
+// findActiveSessions lists the active sessions owned by the caller in the selected regions
+func findActiveSessions(ctx context.Context) ([]regionalSession, error) {
+	owner, err := getCallerARN(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []regionalSession
+	for _, region := range sessionRegions() {
+		cfg := credential.awsConfig.Copy()
+		cfg.Region = region
+
+		found, err := internal.ListActiveSessions(ctx, cfg, owner)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", region, err)
+		}
+		for _, session := range found {
+			sessions = append(sessions, regionalSession{Session: session, region: region})
+		}
+	}
+
+	return sessions, nil
+}
+
+// sessionRegions returns the regions to look for sessions in
+func sessionRegions() []string {
+	if len(credential.awsRegions) > 0 {
+		return credential.awsRegions
+	}
+	return []string{credential.awsConfig.Region}
+}
+
+// getCallerARN returns the ARN of the current identity, which owns the sessions it starts
+func getCallerARN(ctx context.Context) (string, error) {
+	identity, err := sts.NewFromConfig(*credential.awsConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get identity: %w", err)
+	}
+
+	return aws.ToString(identity.Arn), nil
+}
+
+// pruneSessionRecords removes recorded sessions of the current profile in the listed
+// regions that are no longer active
+func pruneSessionRecords(active []regionalSession) {
+	activeIDs := make(map[string]bool, len(active))
+	for _, session := range active {
+		activeIDs[aws.ToString(session.SessionId)] = true
+	}
+
+	listedRegions := make(map[string]bool)
+	for _, region := range sessionRegions() {
+		listedRegions[region] = true
+	}
+
+	updateState(func(state *internal.State) {
+		for sessionID, record := range state.Sessions {
+			if record.Profile == credential.awsProfile && listedRegions[record.Region] && !activeIDs[sessionID] {
+				state.RemoveSession(sessionID)
+			}
+		}
+	})
+}
+
+func init() {
+	// Define command flags
+	sessionsKillCommand.Flags().Bool("all", false, "Terminate all of your active sessions")
+	sessionsKillCommand.Flags().BoolP("yes", "y", false, "Terminate the sessions without asking for confirmation")
+	sessionsKillCommand.Flags().Bool("any-owner", false, "Also terminate the given sessions if another identity owns them")
+
+	sessionsKillCommand.MarkFlagsMutuallyExclusive("all", "any-owner")
+
+	// Bind flags to viper
+	viper.BindPFlag("sessions-kill-all", sessionsKillCommand.Flags().Lookup("all"))
+	viper.BindPFlag("sessions-kill-yes", sessionsKillCommand.Flags().Lookup("yes"))
+	viper.BindPFlag("sessions-kill-any-owner", sessionsKillCommand.Flags().Lookup("any-owner"))
+
+	// Add commands to root
+	sessionsCommand.AddCommand(sessionsKillCommand)
+	rootCmd.AddCommand(sessionsCommand)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -16,6 +19,17 @@ import (
 	"github.com/ottramst/gossm/internal"
 )
 
+const (
+	// ephemeralKeyTTL is how long an ephemeral key stays authorized on the instance
+	ephemeralKeyTTL = 60 * time.Second
+
+	// knownHostsFile is the name of the known_hosts file in the gossm home directory
+	knownHostsFile = "known_hosts"
+
+	// defaultHostKeyChecking is the default StrictHostKeyChecking mode
+	defaultHostKeyChecking = "accept-new"
+)
+
 var (
 	// sshCommand is the Cobra command for SSH via SSM
 	sshCommand = &cobra.Command{
@@ -26,41 +40,127 @@ var (
 This command allows you to establish SSH connections without requiring inbound ports to be open
 or public IP addresses to be assigned to the instances.
 
-Escape Sequence:
+Escape Sequences:
   Enter ~.   Disconnect from the session (useful when network is stuck)
+  Enter ~#   Show the session ID, target and region
+  Enter ~?   List the supported escape sequences
 
 Examples:
   gossm ssh                               # Interactive instance and user selection
   gossm ssh -i ~/.ssh/mykey.pem           # Use a specific identity file (interactive instance selection)
   gossm ssh -e "-i key.pem ec2-user@i-123" # Directly specify a complete SSH command
+  gossm ssh --ephemeral-key               # Use a one-time key instead of a static identity file
+  gossm ssh --port 2222                   # Connect to sshd listening on a non-standard port
+  gossm ssh --log-file ~/gossm-logs       # Record the session output (see gossm start --help)
+  gossm ssh --idle-timeout 30m            # Disconnect after 30 minutes without activity
+  gossm ssh --jump i-456 -e "ec2-user@10.0.3.7" # Hop through an SSM instance to a private host
+
+Host Key Checking:
+  By default new host keys are accepted and recorded in ~/.gossm/known_hosts, while a changed key
+  for a known host is rejected. This trusts the first connection to an instance (the SSM session
+  already authenticates the target through AWS). Use --strict-host-key-checking=yes to refuse
+  unknown hosts, or =no to skip checking entirely, which offers no protection against a
+  substituted host and should only be used for disposable instances.
+
+Jump Instances:
+  With --jump [user@]host, the SSM session goes to the jump instance and ssh continues from there
+  to the target, as with ssh's ProxyJump. The target is reached at its private address, so it
+  only needs to be routable from the jump instance and doesn't need the SSM agent. The jump logs
+  in as the given user (or the selected SSH user) with the --identity key or your ssh agent.
+
+Ephemeral Keys:
+  With --ephemeral-key, gossm generates an ed25519 key pair in memory and authorizes the public
+  key for the SSH user through a one-shot Run Command (requires ssm:SendCommand). The key is
+  removed from authorized_keys on the instance after 60 seconds.
 `,
-		Run: runSSHCommand,
+		PersistentPreRun: setupPlugin,
+		Run:              runSSHCommand,
 	}
 )
 
 // runSSHCommand executes the SSH operation
 func runSSHCommand(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	// Registered first so it runs after all other cleanup, propagating the process exit code
+	var execErr error
+	defer func() { exitOnProcessError(execErr) }()
+
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
+
+	// Resolve host key checking options and session limits
+	hostKeyArgs, err := hostKeyOptions(viper.GetString("ssh-strict-host-key-checking"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
+	if err := validateSessionLimits("ssh"); err != nil {
+		logErrorAndExit(err)
+	}
 
 	// Get SSH command details and target instance
-	sshArgs, targetName, err := getSSHDetailsAndTarget(ctx)
+	sshArgs, targetName, user, err := getSSHDetailsAndTarget(ctx)
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// With a jump instance, the SSM session goes to it and ssh hops on to the target from there
+	sessionTarget := targetName
+	portArgs := sshOptions(sshArgs)
+	jump, err := resolveJump(ctx, viper.GetString("ssh-jump"), user)
 	if err != nil {
 		logErrorAndExit(err)
 	}
+	if jump != nil {
+		sessionTarget = jump.instanceID
+		portArgs = "" // -p in --exec is the port of the final host
+	}
+
+	// Resolve the sshd port on the instance
+	port, err := sshPort(viper.GetString("ssh-port"), portArgs, "-p")
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Merge extra session parameters with the port
+	parameters, err := mergeSessionParameters(map[string][]string{"portNumber": {port}}, viper.GetStringSlice("ssh-param"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Authorize a short-lived key instead of relying on a static one
+	if viper.GetBool("ssh-ephemeral-key") {
+		keyPath, err := setupEphemeralKey(ctx, sshArgs, targetName)
+		if err != nil {
+			logErrorAndExit(err)
+		}
+		defer removeOnExit(keyPath)()
+
+		sshArgs = fmt.Sprintf("-i %s -o IdentitiesOnly=yes %s", keyPath, sshArgs)
+	}
 
 	// Display information about the SSH command
 	internal.PrintReady("ssh", credential.awsConfig.Region, targetName)
+	if jump != nil {
+		color.Cyan("jump: %s", jump.destination())
+	}
 	color.Cyan("ssh %s", sshArgs)
 
 	// Start an SSH session through SSM
-	session, err := startSSHSession(ctx, targetName)
+	session, err := startSSHSession(ctx, sessionTarget, parameters)
 	if err != nil {
 		logErrorAndExit(err)
 	}
 
 	// Execute the SSH command
-	if err := executeSSHCommand(sshArgs, session, targetName); err != nil {
-		color.Red("%v", err)
+	execErr = executeSSHCommand(sshArgs, hostKeyArgs, session, sessionTarget, parameters, jump)
+
+	// Remember the user that worked for the next connection to this instance
+	if execErr == nil && user != "" && !viper.GetBool("no-remember") {
+		updateState(func(state *internal.State) {
+			state.SetUser(targetName, user)
+		})
 	}
 
 	// Clean up by terminating the session
@@ -69,15 +169,21 @@ func runSSHCommand(cmd *cobra.Command, args []string) {
 	}
 }
 
-// getSSHDetailsAndTarget determines the SSH command and target instance
-func getSSHDetailsAndTarget(ctx context.Context) (string, string, error) {
+// getSSHDetailsAndTarget determines the SSH command and target instance, and the SSH user
+// if it was selected rather than given with --exec
+func getSSHDetailsAndTarget(ctx context.Context) (string, string, string, error) {
 	// Get SSH command arguments
 	execFlag := strings.TrimSpace(viper.GetString("ssh-exec"))
-	identityFlag := strings.TrimSpace(viper.GetString("ssh-identity"))
+	identityFlag := expandHome(strings.TrimSpace(viper.GetString("ssh-identity")))
 
 	// Validate flags - can't use both exec and identity
 	if execFlag != "" && identityFlag != "" {
-		return "", "", fmt.Errorf("cannot use both --exec and --identity flags (use only one)")
+		return "", "", "", fmt.Errorf("cannot use both --exec and --identity flags (use only one)")
+	}
+
+	// An ephemeral key replaces the identity file
+	if identityFlag != "" && viper.GetBool("ssh-ephemeral-key") {
+		return "", "", "", fmt.Errorf("cannot use both --identity and --ephemeral-key flags (use only one)")
 	}
 
 	// Handle interactive mode
@@ -90,67 +196,323 @@ func getSSHDetailsAndTarget(ctx context.Context) (string, string, error) {
 }
 
 // handleInteractiveSSH handles interactive selection of instance and user
-func handleInteractiveSSH(ctx context.Context, identityFlag string) (string, string, error) {
-	// Ask for target instance
-	target, err := internal.AskTarget(ctx, *credential.awsConfig)
+func handleInteractiveSSH(ctx context.Context, identityFlag string) (string, string, string, error) {
+	// Use the given instance ID, or ask for the target instance
+	target, err := instanceIDTarget("ssh-instance-id")
+	if err == nil && target == nil {
+		target, err = askTarget(ctx)
+	}
 	if err != nil {
-		return "", "", fmt.Errorf("failed to select target instance: %w", err)
+		return "", "", "", fmt.Errorf("failed to select target instance: %w", err)
 	}
 
 	// Ask for SSH user
-	sshUser, err := internal.AskUser()
+	sshUser, err := sshUser(target)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to select SSH user: %w", err)
+		return "", "", "", fmt.Errorf("failed to select SSH user: %w", err)
 	}
 
 	// Generate SSH command
-	sshCommand := internal.GenerateSSHExecCommand("", identityFlag, sshUser.Name, target.PublicDomain)
+	sshCommand, err := internal.GenerateSSHExecCommand("", identityFlag, sshUser.Name, sshHost(target))
+	if err != nil {
+		return "", "", "", err
+	}
 
-	return sshCommand, target.Name, nil
+	return sshCommand, target.Name, sshUser.Name, nil
 }
 
-// handleDirectSSHCommand processes a directly specified SSH command
-func handleDirectSSHCommand(ctx context.Context, execFlag string) (string, string, error) {
-	// Parse the exec command to extract the server
-	parts := strings.Split(execFlag, " ")
+// sshHost returns the host name to give ssh for the target. The connection goes through the SSM
+// proxy, so the name mostly identifies the host in known_hosts: the private DNS name, which unlike
+// the public one survives a stop and start, or the public one with --public. Without a discovered
+// domain the instance ID serves as host.
+func sshHost(target *internal.Target) string {
+	domains := []string{target.PrivateDomain, target.PublicDomain}
+	if viper.GetBool("ssh-public") {
+		domains = []string{target.PublicDomain, target.PrivateDomain}
+	}
+	for _, domain := range domains {
+		if domain != "" {
+			return domain
+		}
+	}
+	return target.Name
+}
+
+// sshUser returns the SSH user given with --user or the ssh-user config key. Otherwise it asks
+// for the user, defaulting to the user last used for the instance, or else to the usual user
+// of the instance's operating system.
+func sshUser(target *internal.Target) (*internal.User, error) {
+	if user := strings.TrimSpace(viper.GetString("ssh-user")); user != "" {
+		return &internal.User{Name: user}, nil
+	}
+
+	return internal.AskUser(lastSSHUser(target.Name, internal.DefaultSSHUser(target.PlatformName)))
+}
+
+// lastSSHUser returns the SSH user remembered for the instance, or fallback if there is none
+func lastSSHUser(instanceID, fallback string) string {
+	if viper.GetBool("no-remember") {
+		return fallback
+	}
+
+	state, err := internal.LoadState(statePath())
+	if err != nil {
+		internal.Warnf("Ignoring state file: %v", err)
+		return fallback
+	}
+
+	if user := state.Users[instanceID]; user != "" {
+		return user
+	}
+	return fallback
+}
 
-	// The server should be the last part of the command (user@server)
-	lastPart := parts[len(parts)-1]
-	serverParts := strings.Split(lastPart, "@")
+// handleDirectSSHCommand processes a directly specified SSH command
+func handleDirectSSHCommand(ctx context.Context, execFlag string) (string, string, string, error) {
+	// Parse the exec command to extract the server from the user@server destination, which
+	// may be followed by a remote command
+	fields := strings.Fields(execFlag)
+	i := sshDestination(fields)
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid SSH command format: must include user@server")
+	}
+	serverParts := strings.Split(fields[i], "@")
 
 	if len(serverParts) < 2 {
-		return "", "", fmt.Errorf("invalid SSH command format: must include user@server")
+		return "", "", "", fmt.Errorf("invalid SSH command format: must include user@server")
+	}
+
+	// An instance ID skips resolving the server
+	target, err := instanceIDTarget("ssh-instance-id")
+	if err != nil {
+		return "", "", "", err
+	}
+	if target != nil {
+		return execFlag, target.Name, "", nil
+	}
+
+	// Through a jump instance the server is resolved from there, and need not run the SSM agent
+	if viper.GetString("ssh-jump") != "" {
+		return execFlag, serverParts[len(serverParts)-1], "", nil
 	}
 
 	// Extract server hostname
 	server := serverParts[len(serverParts)-1]
 
-	// Resolve server to IP
-	ips, err := net.LookupIP(server)
-	if err != nil || len(ips) == 0 {
-		return "", "", fmt.Errorf("failed to resolve hostname '%s': %w", server, err)
+	// Resolve the server to the instance it runs on
+	instanceID, err := resolveInstanceID(ctx, server)
+	if err != nil {
+		return "", "", "", err
 	}
 
-	ip := ips[0].String()
+	return execFlag, instanceID, "", nil
+}
 
-	// Find instance by IP
-	instanceID, err := internal.FindInstanceIdByIp(ctx, *credential.awsConfig, ip)
+// hostKeyOptions returns the ssh options for the requested StrictHostKeyChecking mode.
+// Known hosts are kept in the gossm home directory, or discarded entirely when checking is off.
+func hostKeyOptions(mode string) ([]string, error) {
+	knownHosts := filepath.Join(credential.gossmHomePath, knownHostsFile)
+
+	switch mode {
+	case "yes", "accept-new":
+	case "no":
+		knownHosts = os.DevNull
+	default:
+		return nil, fmt.Errorf("invalid --strict-host-key-checking value '%s' (use yes, no or accept-new)", mode)
+	}
+
+	return []string{
+		"-o", "StrictHostKeyChecking=" + mode,
+		"-o", "UserKnownHostsFile=" + knownHosts,
+	}, nil
+}
+
+// withoutUserOptions drops the "-o Key=Value" pairs from options whose key the user also set
+// with -o in args before the first operand. ssh uses the first value given for an option, so
+// options passed ahead of the user's own would silently override them.
+func withoutUserOptions(options, args []string, optionsWithArgs string) []string {
+	end := len(args)
+	if operands := internal.OperandIndexes(args, optionsWithArgs); len(operands) > 0 {
+		end = operands[0]
+	}
+
+	userKeys := make(map[string]bool)
+	for i := 0; i < end; i++ {
+		value, ok := strings.CutPrefix(args[i], "-o")
+		if !ok {
+			continue
+		}
+		if value == "" && i+1 < end {
+			i++
+			value = args[i]
+		}
+		key, _, _ := strings.Cut(value, "=")
+		userKeys[strings.ToLower(key)] = true
+	}
+
+	var kept []string
+	for i := 0; i+1 < len(options); i += 2 {
+		key, _, _ := strings.Cut(options[i+1], "=")
+		if !userKeys[strings.ToLower(key)] {
+			kept = append(kept, options[i], options[i+1])
+		}
+	}
+	return kept
+}
+
+// sshPort returns the sshd port to tunnel to: the --port flag if set, otherwise the value
+// of portOption (e.g. -p for ssh, -P for scp) in the user's arguments, or the default port
+func sshPort(flagPort, args, portOption string) (string, error) {
+	port := flagPort
+
+	if port == "" {
+		fields := strings.Fields(args)
+		for i, field := range fields {
+			if field == portOption && i+1 < len(fields) {
+				port = fields[i+1]
+			} else if value, ok := strings.CutPrefix(field, portOption); ok && value != "" {
+				port = value
+			}
+		}
+	}
+
+	if port == "" {
+		return defaultSSHPort, nil
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid SSH port '%s'", port)
+	}
+
+	return port, nil
+}
+
+// sshDestination returns the index of the destination in ssh arguments, the first operand, or -1
+// if there is none. The arguments after it are the remote command.
+func sshDestination(fields []string) int {
+	if operands := internal.OperandIndexes(fields, internal.SSHOptionsWithArgs); len(operands) > 0 {
+		return operands[0]
+	}
+	return -1
+}
+
+// sshOptions returns the ssh arguments before the destination, so that options in the remote
+// command, such as "grep -p", are not taken for ssh's own
+func sshOptions(sshArgs string) string {
+	fields := strings.Fields(sshArgs)
+	if i := sshDestination(fields); i >= 0 {
+		fields = fields[:i]
+	}
+	return strings.Join(fields, " ")
+}
+
+// setupEphemeralKey generates a key pair, authorizes it on the instance and writes the
+// private key to a temporary file, returning its path
+func setupEphemeralKey(ctx context.Context, sshArgs, targetName string) (string, error) {
+	// The SSH user is the user part of the user@host destination
+	fields := strings.Fields(sshArgs)
+	i := sshDestination(fields)
+	if i < 0 {
+		return "", fmt.Errorf("ephemeral key requires a user@host SSH destination")
+	}
+	user, _, ok := strings.Cut(fields[i], "@")
+	if !ok || user == "" {
+		return "", fmt.Errorf("ephemeral key requires a user@host SSH destination")
+	}
+
+	key, err := internal.GenerateEphemeralKey()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to find instance by IP '%s': %w", ip, err)
+		return "", err
 	}
 
-	if instanceID == "" {
-		return "", "", fmt.Errorf("no matching instance found for IP '%s'", ip)
+	color.Cyan("Authorizing ephemeral SSH key for %s on %s...", user, targetName)
+	if err := internal.InstallEphemeralKey(ctx, *credential.awsConfig, targetName, user, key, ephemeralKeyTTL); err != nil {
+		return "", err
 	}
 
-	// Generate SSH command
-	sshCommand := internal.GenerateSSHExecCommand(execFlag, "", "", "")
+	// Keep the private key out of the home directory's long-lived files
+	keyFile, err := os.CreateTemp(credential.gossmHomePath, "ephemeral-key-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer keyFile.Close()
+
+	if _, err := keyFile.Write(key.PrivateKeyPEM); err != nil {
+		os.Remove(keyFile.Name())
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return keyFile.Name(), nil
+}
+
+// sshJump is the instance an ssh connection hops through to reach a host only routable from it
+type sshJump struct {
+	instanceID string
+	user       string
+}
 
-	return sshCommand, instanceID, nil
+// destination returns the jump instance as [user@]host
+func (j *sshJump) destination() string {
+	if j.user == "" {
+		return j.instanceID
+	}
+	return j.user + "@" + j.instanceID
 }
 
-// executeSSHCommand executes the SSH command with SSM as proxy
-func executeSSHCommand(sshArgs string, session *ssm.StartSessionOutput, targetName string) error {
+// resolveJump parses --jump [user@]host, resolving the host to an instance, or returns nil if
+// no jump is requested. Without a user the jump logs in as defaultUser, or as ssh's default.
+func resolveJump(ctx context.Context, value, defaultUser string) (*sshJump, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	user, host := defaultUser, value
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		user, host = value[:at], value[at+1:]
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid jump instance '%s' (expected [user@]host)", value)
+	}
+
+	instanceID, err := resolveInstanceID(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve jump instance: %w", err)
+	}
+
+	return &sshJump{instanceID: instanceID, user: user}, nil
+}
+
+// jumpProxyCommand returns the ProxyCommand that connects to the jump instance through the SSM
+// plugin and forwards the connection to the final host, the way ProxyJump does. Options given
+// on the command line don't reach the ssh that ProxyJump starts, so it is spelled out here.
+func jumpProxyCommand(pluginCommand string, jump *sshJump, hostKeyArgs []string) string {
+	args := []string{"-o", "ProxyCommand=" + pluginCommand}
+	args = append(args, hostKeyArgs...)
+	args = append(args, sshConnectOptions()...)
+	if identity := expandHome(strings.TrimSpace(viper.GetString("ssh-identity"))); identity != "" {
+		args = append(args, "-i", identity)
+	}
+	args = append(args, jump.destination())
+
+	// Quote for the shell ssh runs the ProxyCommand with, and keep ssh from expanding % tokens
+	quoted := []string{"ssh"}
+	for _, arg := range args {
+		quoted = append(quoted, strings.ReplaceAll(shellDoubleQuote(arg), "%", "%%"))
+	}
+	return strings.Join(quoted, " ") + " -W %h:%p"
+}
+
+// sshProxyOption returns the ProxyCommand option that runs pluginCommand, hopping through jump if it isn't nil
+func sshProxyOption(pluginCommand string, jump *sshJump, hostKeyArgs []string) string {
+	if jump != nil {
+		return "ProxyCommand=" + jumpProxyCommand(pluginCommand, jump, hostKeyArgs)
+	}
+	return "ProxyCommand=" + pluginCommand
+}
+
+// executeSSHCommand executes the SSH command with SSM as proxy, hopping through jump if it isn't nil
+func executeSSHCommand(sshArgs string, hostKeyArgs []string, session *ssm.StartSessionOutput, targetName string, parameters map[string][]string, jump *sshJump) error {
 	// Marshal session information to JSON
 	sessionJSON, err := json.Marshal(session)
 	if err != nil {
@@ -160,7 +522,7 @@ func executeSSHCommand(sshArgs string, session *ssm.StartSessionOutput, targetNa
 	// Create parameter input for the SSM plugin
 	input := &ssm.StartSessionInput{
 		DocumentName: aws.String(documentNameSSH),
-		Parameters:   map[string][]string{"portNumber": {defaultSSHPort}},
+		Parameters:   parameters,
 		Target:       aws.String(targetName),
 	}
 
@@ -171,7 +533,7 @@ func executeSSHCommand(sshArgs string, session *ssm.StartSessionOutput, targetNa
 	}
 
 	// Build proxy command for SSH
-	proxyCommand := fmt.Sprintf("ProxyCommand=%s '%s' %s %s %s '%s'",
+	pluginCommand := fmt.Sprintf("%s '%s' %s %s %s '%s'",
 		credential.ssmPluginPath,
 		string(sessionJSON),
 		credential.awsConfig.Region,
@@ -179,27 +541,73 @@ func executeSSHCommand(sshArgs string, session *ssm.StartSessionOutput, targetNa
 		credential.awsProfile,
 		string(paramsJSON),
 	)
+	proxyCommand := sshProxyOption(pluginCommand, jump, hostKeyArgs)
+
+	// Redact the session secrets before the jump command quotes them out of the pattern's reach
+	internal.Tracef("ProxyCommand: %s", sshProxyOption(internal.RedactSecrets(pluginCommand), jump, hostKeyArgs))
 
 	// Build SSH command arguments
-	cmdArgs := []string{"-o", proxyCommand}
+	cmdArgs := append([]string{"-o", proxyCommand}, withoutUserOptions(hostKeyArgs, strings.Fields(sshArgs), internal.SSHOptionsWithArgs)...)
+	cmdArgs = append(cmdArgs, sshConnectOptions()...)
 	for _, arg := range strings.Fields(sshArgs) {
 		if arg != "" {
 			cmdArgs = append(cmdArgs, arg)
 		}
 	}
 
+	// Record and limit the session if requested
+	opts := processOptions(session.SessionId, targetName)
+	applySessionLimits(&opts, "ssh")
+	closeLog, err := attachSessionLog(&opts, viper.GetString("ssh-log-file"), viper.GetBool("ssh-log-input"))
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
 	// Execute SSH command
-	return internal.CallProcess("ssh", cmdArgs...)
+	return internal.CallProcess(opts, "ssh", cmdArgs...)
 }
 
 func init() {
 	// Define command flags
 	sshCommand.Flags().StringP("exec", "e", "", "Complete SSH command (e.g., \"-i key.pem ec2-user@instance\")")
 	sshCommand.Flags().StringP("identity", "i", "", "SSH identity file path (e.g., ~/.ssh/id_rsa)")
+	sshCommand.Flags().StringP("user", "u", "", "SSH user to log in as (default: prompt, suggesting the usual user of the instance's OS)")
+	sshCommand.Flags().String("instance-id", "", "Connect to this instance ID (i-... or mi-...) instead of discovering or resolving the host")
+	sshCommand.Flags().String("jump", "", "Hop through this instance ([user@]host) to reach a target only routable from it")
+	sshCommand.Flags().Bool("public", false, "Name the selected instance by its public instead of its private DNS name")
+	sshCommand.Flags().Bool("ephemeral-key", false, "Generate a one-time SSH key and authorize it on the instance")
+	sshCommand.Flags().String("strict-host-key-checking", defaultHostKeyChecking, "Host key checking mode: yes, no or accept-new")
+	sshCommand.Flags().String("port", "", "sshd port on the instance (default: -p from --exec, or 22)")
+	sshCommand.Flags().StringArray("param", nil, "Extra session document parameter as key=value (repeatable)")
+	sshCommand.Flags().String("log-file", "", "Record session output to this file (or a timestamped file in this directory)")
+	sshCommand.Flags().Bool("log-input", false, "Also record session input to the --log-file (captures anything typed, including secrets)")
+	sshCommand.Flags().Duration("idle-timeout", 0, "Terminate the session after this long without input or output (e.g. 30m, 0 disables)")
+	sshCommand.Flags().Duration("max-duration", 0, "Terminate the session after it has been open this long (e.g. 8h, 0 disables)")
+
+	// The user is part of the --exec command
+	sshCommand.MarkFlagsMutuallyExclusive("exec", "user")
+
+	// The ephemeral key is authorized through SSM, which the host behind a jump may not have
+	sshCommand.MarkFlagsMutuallyExclusive("jump", "ephemeral-key")
+	sshCommand.MarkFlagsMutuallyExclusive("jump", "public")
+	sshCommand.MarkFlagsMutuallyExclusive("exec", "public")
 
 	// Bind flags to viper
 	viper.BindPFlag("ssh-exec", sshCommand.Flags().Lookup("exec"))
 	viper.BindPFlag("ssh-identity", sshCommand.Flags().Lookup("identity"))
+	viper.BindPFlag("ssh-user", sshCommand.Flags().Lookup("user"))
+	viper.BindPFlag("ssh-instance-id", sshCommand.Flags().Lookup("instance-id"))
+	viper.BindPFlag("ssh-jump", sshCommand.Flags().Lookup("jump"))
+	viper.BindPFlag("ssh-public", sshCommand.Flags().Lookup("public"))
+	viper.BindPFlag("ssh-ephemeral-key", sshCommand.Flags().Lookup("ephemeral-key"))
+	viper.BindPFlag("ssh-strict-host-key-checking", sshCommand.Flags().Lookup("strict-host-key-checking"))
+	viper.BindPFlag("ssh-port", sshCommand.Flags().Lookup("port"))
+	viper.BindPFlag("ssh-param", sshCommand.Flags().Lookup("param"))
+	viper.BindPFlag("ssh-log-file", sshCommand.Flags().Lookup("log-file"))
+	viper.BindPFlag("ssh-log-input", sshCommand.Flags().Lookup("log-input"))
+	viper.BindPFlag("ssh-idle-timeout", sshCommand.Flags().Lookup("idle-timeout"))
+	viper.BindPFlag("ssh-max-duration", sshCommand.Flags().Lookup("max-duration"))
 
 	// Add command to root
 	rootCmd.AddCommand(sshCommand)
@@ -1 +1,115 @@
 package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+func TestSSHDestination(t *testing.T) {
+	tests := []struct {
+		args string
+		want int
+	}{
+		{args: "ec2-user@i-1234", want: 0},
+		{args: "-i key.pem ec2-user@i-1234", want: 2},
+		{args: "-vp 2222 ec2-user@i-1234 uptime", want: 2},
+		{args: "-p2222 ec2-user@i-1234 ls -l admin@host", want: 1},
+		{args: "-v", want: -1},
+	}
+
+	for _, tt := range tests {
+		if got := sshDestination(strings.Fields(tt.args)); got != tt.want {
+			t.Errorf("sshDestination(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSSHPortFromOptions(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: "ec2-user@i-1234", want: defaultSSHPort},
+		{args: "-p 2222 ec2-user@i-1234", want: "2222"},
+		{args: "-p2222 ec2-user@i-1234 uptime", want: "2222"},
+		{args: "ec2-user@i-1234 grep -p foo /etc/hosts", want: defaultSSHPort},
+		{args: "-p 2222 ec2-user@i-1234 grep -p foo /etc/hosts", want: "2222"},
+	}
+
+	for _, tt := range tests {
+		got, err := sshPort("", sshOptions(tt.args), "-p")
+		if err != nil {
+			t.Errorf("sshPort(%q) returned error: %v", tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sshPort(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveOnExit(t *testing.T) {
+	dir := t.TempDir()
+	now := filepath.Join(dir, "ephemeral-key-1")
+	later := filepath.Join(dir, "ephemeral-key-2")
+	for _, path := range []string{now, later} {
+		if err := os.WriteFile(path, []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Removing a file right away unregisters it, the rest is later on exit
+	removeOnExit(now)()
+	removeOnExit(later)
+	if _, err := os.Stat(now); !os.IsNotExist(err) {
+		t.Errorf("%s still exists after its cleanup ran", now)
+	}
+
+	removeExitFiles()
+	if _, err := os.Stat(later); !os.IsNotExist(err) {
+		t.Errorf("%s still exists after exit cleanup", later)
+	}
+	if len(exitFiles) != 0 {
+		t.Errorf("exitFiles = %v after exit cleanup, want none", exitFiles)
+	}
+}
+
+func TestWithoutUserOptions(t *testing.T) {
+	options := []string{"-o", "StrictHostKeyChecking=accept-new", "-o", "UserKnownHostsFile=/home/me/.gossm/known_hosts"}
+
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{args: "ec2-user@i-1234", want: options},
+		{args: "-o StrictHostKeyChecking=no ec2-user@i-1234", want: options[2:]},
+		{args: "-ouserknownhostsfile=/dev/null -p 22 ec2-user@i-1234", want: options[:2]},
+		{args: "-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null ec2-user@i-1234", want: nil},
+		// Options of the remote command are not ssh's
+		{args: "ec2-user@i-1234 ssh -o StrictHostKeyChecking=no other", want: options},
+	}
+
+	for _, tt := range tests {
+		if got := withoutUserOptions(options, strings.Fields(tt.args), internal.SSHOptionsWithArgs); !slices.Equal(got, tt.want) {
+			t.Errorf("withoutUserOptions(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSSHProxyOptionRedacted(t *testing.T) {
+	pluginCommand := "session-manager-plugin '" + sessionSecrets(t) + "' eu-west-1 StartSession default '{}'"
+
+	for _, jump := range []*sshJump{nil, {instanceID: "i-0fedcba9876543210", user: "ec2-user"}} {
+		proxyCommand := sshProxyOption(pluginCommand, jump, nil)
+		if !strings.Contains(proxyCommand, "secret-token") {
+			t.Fatalf("sshProxyOption() = %s, want the session JSON", proxyCommand)
+		}
+		assertRedacted(t, proxyCommand)
+		assertRedacted(t, sshProxyOption(internal.RedactSecrets(pluginCommand), jump, nil))
+	}
+}
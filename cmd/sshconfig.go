@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+var (
+	// sshConfigCommand is the Cobra command for generating an ssh_config Host block
+	sshConfigCommand = &cobra.Command{
+		Use:   "ssh-config [instance-id]",
+		Short: "Print an ssh_config Host block that connects through AWS SSM",
+		Long: `Print an ssh_config Host block for an instance that uses gossm as its ProxyCommand.
+
+Append the output to ~/.ssh/config to reach the instance with plain ssh, or with tools built
+on top of it such as scp, rsync, Ansible or VS Code Remote. Each connection starts a fresh
+SSM session through the installed session-manager-plugin and terminates it afterwards.
+
+Examples:
+  gossm ssh-config                                 # Interactive instance selection
+  gossm ssh-config i-1234 -u ec2-user >> ~/.ssh/config
+  ssh i-1234                                       # Connect with plain ssh afterwards
+`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  runSSHConfigCommand,
+	}
+)
+
+// runSSHConfigCommand prints the ssh_config Host block
+func runSSHConfigCommand(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Resolve host key checking options
+	hostKeyArgs, err := hostKeyOptions(viper.GetString("ssh-config-strict-host-key-checking"))
+	if err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Validate the sshd port, which the ProxyCommand receives as %p
+	if _, err := sshPort(viper.GetString("ssh-config-port"), "", ""); err != nil {
+		logErrorAndExit(err)
+	}
+
+	// Use the given instance or ask for one
+	var targetName string
+	if len(args) == 1 {
+		targetName = args[0]
+	} else {
+		target, err := askTarget(ctx)
+		if err != nil {
+			logErrorAndExit(fmt.Errorf("failed to select target instance: %w", err))
+		}
+		targetName = target.Name
+	}
+
+	// The ProxyCommand invokes this binary, so it must be referenced by absolute path
+	executable, err := os.Executable()
+	if err != nil {
+		logErrorAndExit(fmt.Errorf("failed to locate gossm executable: %w", err))
+	}
+
+	fmt.Print(generateSSHConfig(targetName, executable, hostKeyArgs))
+}
+
+// generateSSHConfig renders the Host block for the target instance
+func generateSSHConfig(targetName, executable string, hostKeyArgs []string) string {
+	alias := viper.GetString("ssh-config-host")
+	if alias == "" {
+		alias = targetName
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", alias)
+	fmt.Fprintf(&b, "    HostName %s\n", targetName)
+
+	if user := viper.GetString("ssh-config-user"); user != "" {
+		fmt.Fprintf(&b, "    User %s\n", user)
+	}
+	if identity := expandHome(viper.GetString("ssh-config-identity")); identity != "" {
+		fmt.Fprintf(&b, "    IdentityFile %s\n", identity)
+	}
+	if port := viper.GetString("ssh-config-port"); port != "" {
+		fmt.Fprintf(&b, "    Port %s\n", port)
+	}
+
+	// Pin the profile and region so the proxy never needs to prompt
+	// Pin the endpoint and AWS shared files too, as the proxy runs without the current flags
+	var extraFlags string
+	if endpointURL := viper.GetString("endpoint-url"); endpointURL != "" {
+		extraFlags += fmt.Sprintf(" --endpoint-url %s", endpointURL)
+	}
+	for _, key := range []string{"config-file", "credentials-file"} {
+		if path := awsSharedFile(key); path != "" {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+			extraFlags += fmt.Sprintf(" --%s \"%s\"", key, path)
+		}
+	}
+	fmt.Fprintf(&b, "    ProxyCommand \"%s\" --profile %s --region %s%s --quiet %s %%h %%p\n",
+		executable,
+		credential.awsProfile,
+		credential.awsConfig.Region,
+		extraFlags,
+		sshProxyCommand.Name(),
+	)
+
+	// hostKeyArgs are "-o Key=Value" pairs, rendered as "Key Value"
+	for i := 1; i < len(hostKeyArgs); i += 2 {
+		key, value, _ := strings.Cut(hostKeyArgs[i], "=")
+		fmt.Fprintf(&b, "    %s %s\n", key, value)
+	}
+
+	internal.Debugf("Generated ssh_config for %s", targetName)
+	return b.String()
+}
+
+func init() {
+	// Define command flags
+	sshConfigCommand.Flags().String("host", "", "Host alias to use in the block (default: the instance ID)")
+	sshConfigCommand.Flags().StringP("user", "u", "", "SSH user to set in the block")
+	sshConfigCommand.Flags().StringP("identity", "i", "", "SSH identity file to set in the block")
+	sshConfigCommand.Flags().String("port", "", "sshd port on the instance to set in the block")
+	sshConfigCommand.Flags().String("strict-host-key-checking", defaultHostKeyChecking, "Host key checking mode: yes, no or accept-new")
+
+	// Bind flags to viper
+	viper.BindPFlag("ssh-config-host", sshConfigCommand.Flags().Lookup("host"))
+	viper.BindPFlag("ssh-config-user", sshConfigCommand.Flags().Lookup("user"))
+	viper.BindPFlag("ssh-config-identity", sshConfigCommand.Flags().Lookup("identity"))
+	viper.BindPFlag("ssh-config-port", sshConfigCommand.Flags().Lookup("port"))
+	viper.BindPFlag("ssh-config-strict-host-key-checking", sshConfigCommand.Flags().Lookup("strict-host-key-checking"))
+
+	// Add command to root
+	rootCmd.AddCommand(sshConfigCommand)
+}
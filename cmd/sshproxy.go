@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/spf13/cobra"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+var (
+	// sshProxyCommand is the Cobra command used as an ssh ProxyCommand
+	sshProxyCommand = &cobra.Command{
+		Use:              "ssh-proxy <instance-id> <port>",
+		Short:            "Relay stdin/stdout to an instance's SSH port through AWS SSM",
+		Long:             `Relay stdin/stdout to an instance's SSH port through AWS SSM. Used as ProxyCommand by gossm ssh-config.`,
+		Args:             cobra.ExactArgs(2),
+		Hidden:           true,
+		PersistentPreRun: setupPlugin,
+		Run:              runSSHProxyCommand,
+	}
+)
+
+// runSSHProxyCommand starts an SSH session and relays it over stdin/stdout
+func runSSHProxyCommand(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	// Terminate any session still open when the command returns or panics
+	defer terminateOpenSessions()
+
+	targetName, port := args[0], args[1]
+
+	input := &ssm.StartSessionInput{
+		DocumentName: aws.String(documentNameSSH),
+		Parameters:   map[string][]string{"portNumber": {port}},
+		Target:       aws.String(targetName),
+	}
+
+	session, err := startSession(ctx, input)
+	if err != nil {
+		logErrorAndExit(fmt.Errorf("failed to create SSM session: %w", err))
+	}
+
+	// Relay the session; ssh owns stdin/stdout, so there is no escape handling here
+	if err := relaySSHSession(session, input); err != nil {
+		internal.Warnf("%v", err)
+	}
+
+	// Clean up by terminating the session
+	if err := terminateSession(ctx, session.SessionId); err != nil {
+		logErrorAndExit(err)
+	}
+}
+
+// relaySSHSession runs the SSM plugin with stdin/stdout connected to the caller
+func relaySSHSession(session *ssm.StartSessionOutput, input *ssm.StartSessionInput) error {
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session data: %w", err)
+	}
+
+	paramsJSON, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session parameters: %w", err)
+	}
+
+	// Escape sequences would corrupt the ssh traffic on stdin
+	opts := processOptions(session.SessionId, aws.ToString(input.Target))
+	opts.EscapeChar = 0
+
+	return internal.CallProcess(
+		opts,
+		credential.ssmPluginPath,
+		string(sessionJSON),
+		credential.awsConfig.Region,
+		"StartSession",
+		credential.awsProfile,
+		string(paramsJSON),
+	)
+}
+
+func init() {
+	// Add command to root
+	rootCmd.AddCommand(sshProxyCommand)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+var (
+	// versionCommand is the Cobra command for printing version information
+	versionCommand = &cobra.Command{
+		Use:   "version",
+		Short: "Print the gossm version",
+		Long: `Print the gossm version.
+
+With --full, also print the Go version, platform and the installed session-manager-plugin with
+its version, source and hash, which makes bug reports self-describing. No AWS credentials are
+needed and nothing is installed.
+
+Example:
+  gossm version --full
+`,
+		Args: cobra.NoArgs,
+		Run:  runVersionCommand,
+	}
+)
+
+// runVersionCommand prints the version information
+func runVersionCommand(cmd *cobra.Command, args []string) {
+	fmt.Printf("gossm %s\n", cmp.Or(rootCmd.Version, "dev"))
+	if !viper.GetBool("version-full") {
+		return
+	}
+
+	fmt.Printf("go: %s\n", runtime.Version())
+	fmt.Printf("platform: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+
+	info, err := internal.LoadPluginInfo()
+	if err != nil {
+		fmt.Println("plugin: not installed (installed on first use)")
+	} else {
+		fmt.Printf("plugin: %s (%s, installed %s)\n", info.Version, info.Source, info.InstallDate.Format("2006-01-02"))
+		fmt.Printf("plugin sha256: %s\n", info.Hash)
+	}
+
+	if home, err := homedir.Dir(); err == nil {
+		fmt.Printf("plugin path: %s\n", filepath.Join(home, ".gossm", internal.GetSsmPluginName()))
+	}
+}
+
+func init() {
+	// Define command flags
+	versionCommand.Flags().Bool("full", false, "Also print the Go version, platform and SSM plugin details")
+
+	// Bind flags to viper
+	viper.BindPFlag("version-full", versionCommand.Flags().Lookup("full"))
+
+	// Add command to root
+	rootCmd.AddCommand(versionCommand)
+}
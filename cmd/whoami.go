@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+const (
+	// whoamiOutputText prints the identity as aligned key-value lines
+	whoamiOutputText = "text"
+
+	// whoamiOutputJSON prints the identity as a JSON object
+	whoamiOutputJSON = "json"
+)
+
+var (
+	// whoamiCommand is the Cobra command for printing the caller identity
+	whoamiCommand = &cobra.Command{
+		Use:   "whoami",
+		Short: "Print the AWS identity gossm uses",
+		Long: `Print the AWS account, ARN and user ID of the credentials gossm resolved
+(sts:GetCallerIdentity), along with the profile and region, to confirm which account and role
+commands run as without switching to the AWS CLI.
+
+Examples:
+  gossm whoami
+  gossm whoami -p prod --output json
+`,
+		Args: cobra.NoArgs,
+		Run:  runWhoami,
+	}
+)
+
+// whoamiIdentity is the identity printed by whoami
+type whoamiIdentity struct {
+	Account string `json:"account"`
+	Arn     string `json:"arn"`
+	UserID  string `json:"userId"`
+	Profile string `json:"profile"`
+	Region  string `json:"region"`
+}
+
+// runWhoami prints the caller identity of the configured credentials
+func runWhoami(cmd *cobra.Command, args []string) {
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	output := viper.GetString("whoami-output")
+	if output != whoamiOutputText && output != whoamiOutputJSON {
+		logErrorAndExit(fmt.Errorf("invalid output format '%s' (use %s or %s)", output, whoamiOutputText, whoamiOutputJSON))
+	}
+
+	result, err := sts.NewFromConfig(*credential.awsConfig).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		logErrorAndExit(fmt.Errorf("failed to get caller identity: %w", err))
+	}
+
+	identity := whoamiIdentity{
+		Account: aws.ToString(result.Account),
+		Arn:     aws.ToString(result.Arn),
+		UserID:  aws.ToString(result.UserId),
+		Profile: credential.awsProfile,
+		Region:  credential.awsConfig.Region,
+	}
+	if err := printWhoami(os.Stdout, identity, output); err != nil {
+		logErrorAndExit(internal.WrapError(err))
+	}
+}
+
+// printWhoami writes the identity to w in the output format
+func printWhoami(w io.Writer, identity whoamiIdentity, output string) error {
+	if output == whoamiOutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(identity)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Account:\t%s\n", identity.Account)
+	fmt.Fprintf(tw, "Arn:\t%s\n", identity.Arn)
+	fmt.Fprintf(tw, "UserId:\t%s\n", identity.UserID)
+	fmt.Fprintf(tw, "Profile:\t%s\n", identity.Profile)
+	fmt.Fprintf(tw, "Region:\t%s\n", identity.Region)
+	return tw.Flush()
+}
+
+func init() {
+	// Define command flags
+	whoamiCommand.Flags().StringP("output", "o", whoamiOutputText,
+		`Output format, "text" or "json"`)
+
+	// Bind flags to viper
+	viper.BindPFlag("whoami-output", whoamiCommand.Flags().Lookup("output"))
+
+	// Add command to root
+	rootCmd.AddCommand(whoamiCommand)
+}
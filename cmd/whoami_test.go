@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrintWhoami(t *testing.T) {
+	identity := whoamiIdentity{
+		Account: "123456789012",
+		Arn:     "arn:aws:sts::123456789012:assumed-role/Admin/alice",
+		UserID:  "AROAEXAMPLE:alice",
+		Profile: "prod",
+		Region:  "eu-west-1",
+	}
+
+	var text bytes.Buffer
+	if err := printWhoami(&text, identity, whoamiOutputText); err != nil {
+		t.Fatalf("printWhoami(text) error = %v", err)
+	}
+	if !strings.Contains(text.String(), "Account:  123456789012\n") || !strings.Contains(text.String(), "Region:   eu-west-1\n") {
+		t.Errorf("printWhoami(text) = %q", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := printWhoami(&out, identity, whoamiOutputJSON); err != nil {
+		t.Fatalf("printWhoami(json) error = %v", err)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("printWhoami(json) printed invalid JSON: %v", err)
+	}
+	if decoded["account"] != identity.Account || decoded["userId"] != identity.UserID || decoded["profile"] != "prod" {
+		t.Errorf("printWhoami(json) = %v", decoded)
+	}
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// The exported functions take an aws.Config and create the clients they need, passing them on
+// as these narrow interfaces so tests can substitute fakes for the AWS APIs.
+
+// ec2DescribeAPI is the part of the EC2 API used to look up instances, implemented by *ec2.Client
+type ec2DescribeAPI interface {
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+// ssmDescribeAPI is the part of the SSM API used to find managed nodes, implemented by *ssm.Client
+type ssmDescribeAPI interface {
+	DescribeInstanceInformation(ctx context.Context, params *ssm.DescribeInstanceInformationInput, optFns ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error)
+}
+
+// ssmCommandAPI is the part of the SSM API used to send commands, implemented by *ssm.Client
+type ssmCommandAPI interface {
+	SendCommand(ctx context.Context, params *ssm.SendCommandInput, optFns ...func(*ssm.Options)) (*ssm.SendCommandOutput, error)
+}
+
+// ssmInvocationAPI is the part of the SSM API used to follow command invocations, implemented by *ssm.Client
+type ssmInvocationAPI interface {
+	GetCommandInvocation(ctx context.Context, params *ssm.GetCommandInvocationInput, optFns ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error)
+}
+
+// ssmSessionAPI is the part of the SSM API used to create and terminate sessions, implemented
+// by *ssm.Client
+type ssmSessionAPI interface {
+	StartSession(ctx context.Context, params *ssm.StartSessionInput, optFns ...func(*ssm.Options)) (*ssm.StartSessionOutput, error)
+	TerminateSession(ctx context.Context, params *ssm.TerminateSessionInput, optFns ...func(*ssm.Options)) (*ssm.TerminateSessionOutput, error)
+}
@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeEC2 serves DescribeInstances from instances, by instance-id filter or in pages of pageSize
+type fakeEC2 struct {
+	instances []ec2types.Instance
+	pageSize  int
+	inputs    []*ec2.DescribeInstancesInput
+}
+
+func (f *fakeEC2) DescribeInstances(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.inputs = append(f.inputs, params)
+
+	for _, filter := range params.Filters {
+		if aws.ToString(filter.Name) != "instance-id" {
+			continue
+		}
+		var matched []ec2types.Instance
+		for _, instance := range f.instances {
+			if slices.Contains(filter.Values, aws.ToString(instance.InstanceId)) {
+				matched = append(matched, instance)
+			}
+		}
+		return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{Instances: matched}}}, nil
+	}
+
+	start, _ := strconv.Atoi(aws.ToString(params.NextToken))
+	end := min(start+f.pageSize, len(f.instances))
+	output := &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{Instances: f.instances[start:end]}}}
+	if end < len(f.instances) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+// fakeSSM serves DescribeInstanceInformation from infos in pages of pageSize, and records the
+// commands sent, failing from call failSendAt on if it is set. GetCommandInvocation returns
+// invocations in turn, repeating the last, with nil standing for one not registered yet.
+type fakeSSM struct {
+	infos       []ssmtypes.InstanceInformation
+	pageSize    int
+	failSendAt  int
+	invocations []*ssm.GetCommandInvocationOutput
+	describes   []*ssm.DescribeInstanceInformationInput
+	sends       []*ssm.SendCommandInput
+	gets        int
+}
+
+func (f *fakeSSM) DescribeInstanceInformation(_ context.Context, params *ssm.DescribeInstanceInformationInput, _ ...func(*ssm.Options)) (*ssm.DescribeInstanceInformationOutput, error) {
+	f.describes = append(f.describes, params)
+
+	start, _ := strconv.Atoi(aws.ToString(params.NextToken))
+	end := min(start+f.pageSize, len(f.infos))
+	output := &ssm.DescribeInstanceInformationOutput{InstanceInformationList: f.infos[start:end]}
+	if end < len(f.infos) {
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func (f *fakeSSM) SendCommand(_ context.Context, params *ssm.SendCommandInput, _ ...func(*ssm.Options)) (*ssm.SendCommandOutput, error) {
+	f.sends = append(f.sends, params)
+	if f.failSendAt > 0 && len(f.sends) >= f.failSendAt {
+		return nil, errors.New("throttled")
+	}
+	return &ssm.SendCommandOutput{Command: &ssmtypes.Command{
+		CommandId:   aws.String(fmt.Sprintf("cmd-%d", len(f.sends))),
+		InstanceIds: params.InstanceIds,
+	}}, nil
+}
+
+func (f *fakeSSM) GetCommandInvocation(_ context.Context, params *ssm.GetCommandInvocationInput, _ ...func(*ssm.Options)) (*ssm.GetCommandInvocationOutput, error) {
+	output := f.invocations[min(f.gets, len(f.invocations)-1)]
+	f.gets++
+	if output == nil {
+		return nil, &ssmtypes.InvocationDoesNotExist{}
+	}
+	output.InstanceId = params.InstanceId
+	return output, nil
+}
+
+// fakeFleet returns n running EC2 instances named web-<i>, their SSM information and that of one
+// on-premises managed instance
+func fakeFleet(n int) ([]ec2types.Instance, []ssmtypes.InstanceInformation) {
+	var instances []ec2types.Instance
+	var infos []ssmtypes.InstanceInformation
+	for i := range n {
+		id := fmt.Sprintf("i-%017d", i)
+		instances = append(instances, ec2types.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: ec2types.InstanceTypeT3Micro,
+			Placement:    &ec2types.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			Tags:         []ec2types.Tag{{Key: aws.String("Name"), Value: aws.String(fmt.Sprintf("web-%d", i))}},
+		})
+		infos = append(infos, ssmtypes.InstanceInformation{InstanceId: aws.String(id), PlatformName: aws.String("Ubuntu")})
+	}
+	infos = append(infos, ssmtypes.InstanceInformation{
+		InstanceId:   aws.String("mi-0123456789abcdef0"),
+		Name:         aws.String("on-prem"),
+		PlatformType: ssmtypes.PlatformTypeLinux,
+	})
+	return instances, infos
+}
+
+func TestFindInstances(t *testing.T) {
+	tests := []struct {
+		name          string
+		fleet         int
+		opts          FindOptions
+		wantTargets   int
+		wantDescribes int   // DescribeInstanceInformation pages fetched
+		wantBatches   []int // Instance IDs per DescribeInstances call
+	}{
+		{name: "single batch", fleet: 3, wantTargets: 4, wantDescribes: 1, wantBatches: []int{3}},
+		{name: "paginated and batched", fleet: 250, wantTargets: 251, wantDescribes: 6, wantBatches: []int{199, 51}},
+		{name: "stops at the limit", fleet: 250, opts: FindOptions{MaxInstances: 60}, wantTargets: 60, wantDescribes: 2, wantBatches: []int{60}},
+		{name: "tag filters", fleet: 3, opts: FindOptions{TagFilters: []TagFilter{{Key: "Env", Value: "prod"}}}, wantTargets: 4, wantDescribes: 1, wantBatches: []int{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances, infos := fakeFleet(tt.fleet)
+			ec2Client := &fakeEC2{instances: instances}
+			ssmClient := &fakeSSM{infos: infos, pageSize: maxOutputResults}
+
+			table, err := findInstances(context.Background(), ec2Client, ssmClient, "eu-west-1", tt.opts)
+			if err != nil {
+				t.Fatalf("findInstances() error = %v", err)
+			}
+
+			if len(table) != tt.wantTargets {
+				t.Errorf("findInstances() found %d targets, want %d", len(table), tt.wantTargets)
+			}
+			if len(ssmClient.describes) != tt.wantDescribes {
+				t.Errorf("DescribeInstanceInformation called %d times, want %d", len(ssmClient.describes), tt.wantDescribes)
+			}
+
+			var batches []int
+			for _, input := range ec2Client.inputs {
+				for _, filter := range input.Filters {
+					if aws.ToString(filter.Name) == "instance-id" {
+						batches = append(batches, len(filter.Values))
+					}
+				}
+				if len(tt.opts.TagFilters) > 0 && !slices.ContainsFunc(input.Filters, func(f ec2types.Filter) bool {
+					return aws.ToString(f.Name) == "tag:Env"
+				}) {
+					t.Errorf("DescribeInstances filters %v miss the tag filter", input.Filters)
+				}
+			}
+			if !slices.Equal(batches, tt.wantBatches) {
+				t.Errorf("DescribeInstances batches = %v, want %v", batches, tt.wantBatches)
+			}
+
+			for _, target := range table {
+				if target.Region != "eu-west-1" {
+					t.Errorf("target %s region = %q, want eu-west-1", target.Name, target.Region)
+				}
+				if target.Name == "i-00000000000000000" && (target.InstanceName != "web-0" || target.PlatformName != "Ubuntu" || target.AvailabilityZone != "eu-west-1a") {
+					t.Errorf("target = %+v, want web-0 on Ubuntu in eu-west-1a", target)
+				}
+			}
+		})
+	}
+}
+
+func TestFindInstancesLimitWarning(t *testing.T) {
+	var buf bytes.Buffer
+	previous := logger
+	logger = slog.New(&consoleHandler{out: &buf, plain: true, level: logLevel, mu: stderrMu})
+	t.Cleanup(func() { logger = previous })
+
+	tests := []struct {
+		name string
+		opts FindOptions
+		want bool
+	}{
+		{name: "limit reached", opts: FindOptions{MaxInstances: 1}, want: true},
+		{name: "quiet limit", opts: FindOptions{MaxInstances: 1, QuietLimit: true}, want: false},
+		{name: "under the limit", opts: FindOptions{MaxInstances: 10}, want: false},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		instances, infos := fakeFleet(3)
+		_, err := findInstances(context.Background(), &fakeEC2{instances: instances}, &fakeSSM{infos: infos, pageSize: maxOutputResults}, "eu-west-1", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: findInstances() error = %v", tt.name, err)
+		}
+		if got := strings.Contains(buf.String(), "Showing the first"); got != tt.want {
+			t.Errorf("%s: warned about the limit = %v, want %v (output %q)", tt.name, got, tt.want, buf.String())
+		}
+	}
+}
+
+func TestFindInstanceIdsByIp(t *testing.T) {
+	instance := func(id string, configure func(*ec2types.Instance)) ec2types.Instance {
+		instance := ec2types.Instance{InstanceId: aws.String(id), PrivateIpAddress: aws.String("10.0.0.1")}
+		configure(&instance)
+		return instance
+	}
+	instances := []ec2types.Instance{
+		instance("i-public", func(i *ec2types.Instance) { i.PublicIpAddress = aws.String("203.0.113.10") }),
+		instance("i-private", func(i *ec2types.Instance) { i.PrivateIpAddress = aws.String("10.0.0.20") }),
+		instance("i-secondary", func(i *ec2types.Instance) {
+			i.NetworkInterfaces = []ec2types.InstanceNetworkInterface{{
+				PrivateIpAddresses: []ec2types.InstancePrivateIpAddress{{PrivateIpAddress: aws.String("10.0.0.30")}},
+			}}
+		}),
+		instance("i-eni-public", func(i *ec2types.Instance) {
+			i.NetworkInterfaces = []ec2types.InstanceNetworkInterface{{
+				Association: &ec2types.InstanceNetworkInterfaceAssociation{PublicIp: aws.String("198.51.100.7")},
+			}}
+		}),
+		// Same private IP in another VPC, on a later page
+		instance("i-other-vpc", func(i *ec2types.Instance) { i.PrivateIpAddress = aws.String("10.0.0.20") }),
+	}
+
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{ip: "203.0.113.10", want: []string{"i-public"}},
+		{ip: "10.0.0.20", want: []string{"i-private", "i-other-vpc"}},
+		{ip: "10.0.0.30", want: []string{"i-secondary"}},
+		{ip: "198.51.100.7", want: []string{"i-eni-public"}},
+		{ip: "192.0.2.1", want: nil},
+	}
+
+	for _, tt := range tests {
+		client := &fakeEC2{instances: instances, pageSize: 2}
+		got, err := findInstanceIdsByIp(context.Background(), client, tt.ip)
+		if err != nil {
+			t.Fatalf("findInstanceIdsByIp(%s) error = %v", tt.ip, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("findInstanceIdsByIp(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+		if len(client.inputs) != 3 {
+			t.Errorf("findInstanceIdsByIp(%s) fetched %d pages, want 3", tt.ip, len(client.inputs))
+		}
+	}
+}
+
+func TestSendCommandBatches(t *testing.T) {
+	targets := make([]*Target, 120)
+	for i := range targets {
+		targets[i] = &Target{Name: fmt.Sprintf("i-%017d", i)}
+	}
+
+	client := &fakeSSM{}
+	commands, err := sendCommand(context.Background(), client, targets, "uptime", RunCommandOptions{})
+	if err != nil {
+		t.Fatalf("sendCommand() error = %v", err)
+	}
+	var sizes []int
+	for _, input := range client.sends {
+		sizes = append(sizes, len(input.InstanceIds))
+	}
+	if want := []int{50, 50, 20}; !slices.Equal(sizes, want) || len(commands) != 3 {
+		t.Errorf("sendCommand() sent batches %v as %d commands, want %v as 3", sizes, len(commands), want)
+	}
+
+	// A failing batch returns the commands already sent
+	client = &fakeSSM{failSendAt: 3}
+	commands, err = sendCommand(context.Background(), client, targets, "uptime", RunCommandOptions{})
+	if err == nil || len(commands) != 2 {
+		t.Errorf("sendCommand() = %d commands, %v; want 2 commands and an error", len(commands), err)
+	}
+}
+
+func TestWaitForCommandInvocation(t *testing.T) {
+	t.Parallel()
+
+	t.Run("terminal status", func(t *testing.T) {
+		t.Parallel()
+		client := &fakeSSM{invocations: []*ssm.GetCommandInvocationOutput{
+			nil,
+			{Status: ssmtypes.CommandInvocationStatusInProgress},
+			{Status: ssmtypes.CommandInvocationStatusSuccess},
+		}}
+		output, err := waitForCommandInvocation(context.Background(), client, &ssm.GetCommandInvocationInput{InstanceId: aws.String("i-1")})
+		if err != nil {
+			t.Fatalf("waitForCommandInvocation() error = %v", err)
+		}
+		if output.Status != ssmtypes.CommandInvocationStatusSuccess || client.gets != 3 {
+			t.Errorf("waitForCommandInvocation() = %s after %d polls, want Success after 3", output.Status, client.gets)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		client := &fakeSSM{invocations: []*ssm.GetCommandInvocationOutput{{Status: ssmtypes.CommandInvocationStatusInProgress}}}
+		ctx, cancel := context.WithTimeout(context.Background(), pollInterval+pollInterval/2)
+		defer cancel()
+		if _, err := waitForCommandInvocation(ctx, client, &ssm.GetCommandInvocationInput{InstanceId: aws.String("i-1")}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("waitForCommandInvocation() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestMonitorCommandInvocation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		invocations []*ssm.GetCommandInvocationOutput
+		timeout     time.Duration
+		want        string
+	}{
+		{
+			name: "success",
+			invocations: []*ssm.GetCommandInvocationOutput{
+				{Status: ssmtypes.CommandInvocationStatusPending},
+				{Status: ssmtypes.CommandInvocationStatusSuccess, StandardOutputContent: aws.String("ok")},
+			},
+			want: statusDone,
+		},
+		{
+			name: "non-zero exit code",
+			invocations: []*ssm.GetCommandInvocationOutput{
+				{Status: ssmtypes.CommandInvocationStatusFailed, ResponseCode: 2, StandardErrorContent: aws.String("boom")},
+			},
+			want: statusFailed,
+		},
+		{
+			name: "timed out",
+			invocations: []*ssm.GetCommandInvocationOutput{
+				{Status: ssmtypes.CommandInvocationStatusInProgress},
+			},
+			timeout: pollInterval + pollInterval/2,
+			want:    statusRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			var out bytes.Buffer
+			board := &statusBoard{out: &out, mu: &sync.Mutex{}, instances: []string{"i-1"}, states: map[string]string{}}
+			client := &fakeSSM{invocations: tt.invocations}
+			got := monitorCommandInvocation(ctx, client, &ssm.GetCommandInvocationInput{InstanceId: aws.String("i-1")}, board, CommandOutputOptions{})
+			if got != tt.want {
+				t.Errorf("monitorCommandInvocation() = %s, want %s (output %q)", got, tt.want, out.String())
+			}
+		})
+	}
+}
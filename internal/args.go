@@ -0,0 +1,71 @@
+package internal
+
+import "strings"
+
+// SSHOptionsWithArgs are the ssh short options that take a value
+const SSHOptionsWithArgs = "BbcDEeFIiJLlmOopQRSWw"
+
+// OperandIndexes returns the indexes of the operands in args, skipping options and the values
+// of options listed in optionsWithArgs. Every argument after "--" is an operand.
+func OperandIndexes(args []string, optionsWithArgs string) []int {
+	var operands []int
+	skipNext := false
+
+	for i, arg := range args {
+		if skipNext {
+			skipNext = false
+			continue
+		}
+		if arg == "--" {
+			for j := i + 1; j < len(args); j++ {
+				operands = append(operands, j)
+			}
+			break
+		}
+
+		// Option clusters such as "-rP 2222" consume the next argument when the last
+		// option takes a value; "-P2222" carries the value inline
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			if !strings.HasPrefix(arg, "--") {
+				for j, opt := range arg[1:] {
+					if strings.ContainsRune(optionsWithArgs, opt) {
+						skipNext = j == len(arg)-2
+						break
+					}
+				}
+			}
+			continue
+		}
+
+		operands = append(operands, i)
+	}
+
+	return operands
+}
+
+// HasShortOption reports whether the short option is given in args, alone or in a cluster
+func HasShortOption(args []string, optionsWithArgs string, option rune) bool {
+	skipNext := false
+	for _, arg := range args {
+		if skipNext {
+			skipNext = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "--") || len(arg) < 2 {
+			continue
+		}
+
+		for j, opt := range arg[1:] {
+			if opt == option {
+				return true
+			}
+			// The rest of the cluster is the option's value
+			if strings.ContainsRune(optionsWithArgs, opt) {
+				skipNext = j == len(arg)-2
+				break
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOperandIndexes(t *testing.T) {
+	tests := []struct {
+		args string
+		want []int
+	}{
+		{args: "ec2-user@i-1234", want: []int{0}},
+		{args: "-i key.pem ec2-user@i-1234 uptime", want: []int{2, 3}},
+		{args: "-vp 2222 ec2-user@i-1234", want: []int{2}},
+		{args: "-p2222 -v ec2-user@i-1234", want: []int{2}},
+		{args: "-v --", want: nil},
+		{args: "-v -- -p ec2-user@i-1234", want: []int{2, 3}},
+	}
+
+	for _, tt := range tests {
+		if got := OperandIndexes(strings.Fields(tt.args), SSHOptionsWithArgs); !slices.Equal(got, tt.want) {
+			t.Errorf("OperandIndexes(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestHasSSHIdentity(t *testing.T) {
+	tests := []struct {
+		args string
+		want bool
+	}{
+		{args: "-i key.pem ec2-user@i-1234", want: true},
+		{args: "-vi key.pem ec2-user@i-1234", want: true},
+		{args: "-ikey.pem ec2-user@i-1234", want: true},
+		{args: "-l admin -p 2222 ec2-user@i-1234", want: false},
+		{args: "-o IdentitiesOnly=yes ec2-user@i-1234", want: false},
+		{args: "-p -i ec2-user@i-1234", want: false},
+		{args: "ec2-user@i-1234 ssh -i key.pem other", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := hasSSHIdentity(tt.args); got != tt.want {
+			t.Errorf("hasSSHIdentity(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
@@ -36,6 +36,28 @@ const (
 
 	// latestVersionURL is the URL to query for the latest plugin version
 	latestVersionURL = "https://s3.amazonaws.com/session-manager-downloads/plugin/latest/VERSION"
+
+	// tempDirName is the directory in the plugin directory holding gossm's temporary files
+	tempDirName = "tmp"
+
+	// staleTempAge is the age after which a temporary file is left over from an interrupted run
+	staleTempAge = time.Hour
+)
+
+// legacyTempPrefixes start the names of the temporary files earlier versions created in the
+// system temp directory, followed by the random digits of os.CreateTemp
+var legacyTempPrefixes = []string{"session-manager-plugin-", "deb-extract-", "rpm-extract-", "ssm-plugin-extract-"}
+
+// Plugin sources selectable with --plugin-source
+const (
+	// PluginSourceAuto downloads the plugin, falling back to the embedded one if that fails
+	PluginSourceAuto = "auto"
+
+	// PluginSourceEmbedded always uses the plugin embedded in gossm, without network access
+	PluginSourceEmbedded = "embedded"
+
+	// PluginSourceDownload always uses a downloaded plugin, failing if the download fails
+	PluginSourceDownload = "download"
 )
 
 // PluginInfo stores metadata about the installed plugin
@@ -54,10 +76,53 @@ func GetSsmPluginName() string {
 	return "session-manager-plugin"
 }
 
-// GetSsmPlugin retrieves the AWS SSM plugin, downloading it if needed
-func GetSsmPlugin() ([]byte, error) {
+// WriteSsmPlugin writes the SSM plugin to path, unless the plugin there is already identical.
+// Sizes are compared first, as a plugin of the same size may still be a different build.
+func WriteSsmPlugin(path string, plugin []byte) error {
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		Infof("[create] aws ssm plugin")
+	case err != nil:
+		return err
+	case int(info.Size()) != len(plugin):
+		Infof("[update] aws ssm plugin")
+	default:
+		current, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		currentHash, _ := calculateHash(current)
+		pluginHash, _ := calculateHash(plugin)
+		if currentHash == pluginHash {
+			return nil
+		}
+		Infof("[update] aws ssm plugin")
+	}
+
+	return os.WriteFile(path, plugin, 0755)
+}
+
+// GetSsmPlugin retrieves the AWS SSM plugin from source, downloading it if needed. The plugin
+// and its temporary files are kept in pluginDir, or GetPluginDirectory if it is empty.
+// An empty requestedVersion installs the latest version, and an empty source means PluginSourceAuto.
+func GetSsmPlugin(pluginDir, requestedVersion, source string) ([]byte, error) {
+	if source == "" {
+		source = PluginSourceAuto
+	}
+	if source != PluginSourceAuto && source != PluginSourceEmbedded && source != PluginSourceDownload {
+		return nil, fmt.Errorf("invalid plugin source '%s' (use %s, %s or %s)",
+			source, PluginSourceAuto, PluginSourceEmbedded, PluginSourceDownload)
+	}
+
+	if pluginDir == "" {
+		pluginDir = GetPluginDirectory()
+	}
+
+	// Remove what interrupted downloads left behind
+	cleanStaleTempFiles(pluginDir, time.Now().Add(-staleTempAge))
+
 	// First, try to load already installed plugin
-	pluginDir := GetPluginDirectory()
 	pluginPath := filepath.Join(pluginDir, GetSsmPluginName())
 
 	// Create plugin directory if it doesn't exist
@@ -69,8 +134,24 @@ func GetSsmPlugin() ([]byte, error) {
 	infoFilePath := filepath.Join(pluginDir, pluginInfoFile)
 	info, infoErr := loadPluginInfo(infoFilePath)
 
-	// Get user-defined version from environment
-	requestedVersion := os.Getenv("GOSSM_PLUGIN_VERSION")
+	// The embedded plugin is extracted again unless the installed one is the same build, which
+	// it no longer is after an upgrade of gossm that ships another plugin
+	if source == PluginSourceEmbedded {
+		data, err := readEmbeddedPlugin()
+		if err != nil {
+			return nil, err
+		}
+		hash, _ := calculateHash(data)
+		if infoErr == nil && info.Source == PluginSourceEmbedded && info.Hash == hash && ValidatePlugin(pluginPath) == nil {
+			return data, nil
+		}
+		if err := installEmbeddedPlugin(pluginDir, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	// Use the latest version unless one was requested
 	if requestedVersion == "" {
 		requestedVersion = defaultPluginVersion
 	}
@@ -78,8 +159,9 @@ func GetSsmPlugin() ([]byte, error) {
 	// Determine if we need to download a new version
 	needsDownload := false
 
-	// If info doesn't exist or has different version than requested
-	if infoErr != nil || (requestedVersion != "latest" && requestedVersion != info.Version) {
+	// If info doesn't exist, has different version than requested or isn't a downloaded plugin
+	if infoErr != nil || (requestedVersion != "latest" && requestedVersion != info.Version) ||
+		(source == PluginSourceDownload && info.Source == PluginSourceEmbedded) {
 		needsDownload = true
 	} else {
 		// Check if plugin file exists and is executable
@@ -90,10 +172,13 @@ func GetSsmPlugin() ([]byte, error) {
 
 	// Download new plugin if needed
 	if needsDownload {
-		fmt.Println("Downloading AWS Session Manager plugin...")
+		Infof("Downloading AWS Session Manager plugin...")
 		if err := downloadPlugin(pluginDir, requestedVersion); err != nil {
+			if source == PluginSourceDownload {
+				return nil, fmt.Errorf("failed to download plugin: %w", err)
+			}
 			// If download fails, fallback to embedded plugin
-			fmt.Printf("Download failed, using embedded plugin: %v\n", err)
+			Warnf("Download failed, using embedded plugin: %v", err)
 			return getEmbeddedPlugin(pluginDir)
 		}
 	}
@@ -101,14 +186,79 @@ func GetSsmPlugin() ([]byte, error) {
 	// Read the plugin file
 	data, err := os.ReadFile(pluginPath)
 	if err != nil {
+		if source == PluginSourceDownload {
+			return nil, fmt.Errorf("failed to read plugin: %w", err)
+		}
 		// If reading fails, fallback to embedded plugin
-		fmt.Printf("Failed to read plugin, using embedded plugin: %v\n", err)
+		Warnf("Failed to read plugin, using embedded plugin: %v", err)
 		return getEmbeddedPlugin(pluginDir)
 	}
 
 	return data, nil
 }
 
+// tempRoot returns gossm's temporary directory in pluginDir, creating it if needed. It is kept in
+// the user's plugin directory rather than the shared system temp directory, where another user
+// could create it first and control the plugin binaries extracted in it.
+func tempRoot(pluginDir string) (string, error) {
+	root := filepath.Join(pluginDir, tempDirName)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// makeTempDir creates a temporary directory in gossm's temp directory in pluginDir
+func makeTempDir(pluginDir, pattern string) (string, error) {
+	root, err := tempRoot(pluginDir)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(root, pattern)
+}
+
+// makeTempFile creates a temporary file in gossm's temp directory in pluginDir
+func makeTempFile(pluginDir, pattern string) (*os.File, error) {
+	root, err := tempRoot(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(root, pattern)
+}
+
+// cleanStaleTempFiles removes temporary files in pluginDir last modified before cutoff, which a
+// killed gossm left behind. Recent ones may belong to another running gossm and are kept.
+func cleanStaleTempFiles(pluginDir string, cutoff time.Time) {
+	var paths []string
+	root := filepath.Join(pluginDir, tempDirName)
+	if entries, err := os.ReadDir(root); err == nil {
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(root, entry.Name()))
+		}
+	}
+	for _, prefix := range legacyTempPrefixes {
+		matches, _ := filepath.Glob(filepath.Join(os.TempDir(), prefix+"*"))
+		for _, match := range matches {
+			suffix := strings.TrimPrefix(filepath.Base(match), prefix)
+			if suffix != "" && strings.Trim(suffix, "0123456789") == "" {
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			Debugf("Failed to remove stale temporary file %s: %v", path, err)
+			continue
+		}
+		Debugf("Removed stale temporary file %s", path)
+	}
+}
+
 // GetPluginDirectory returns the directory where plugins are stored
 func GetPluginDirectory() string {
 	homeDir, err := os.UserHomeDir()
@@ -121,6 +271,18 @@ func GetPluginDirectory() string {
 
 // getEmbeddedPlugin extracts the plugin from embedded assets
 func getEmbeddedPlugin(pluginDir string) ([]byte, error) {
+	data, err := readEmbeddedPlugin()
+	if err != nil {
+		return nil, err
+	}
+	if err := installEmbeddedPlugin(pluginDir, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// readEmbeddedPlugin returns the embedded plugin for the current platform
+func readEmbeddedPlugin() ([]byte, error) {
 	goos := strings.ToLower(runtime.GOOS)
 	goarch := strings.ToLower(runtime.GOARCH)
 	
@@ -138,11 +300,15 @@ func getEmbeddedPlugin(pluginDir string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract embedded plugin: %w", err)
 	}
+	return data, nil
+}
 
+// installEmbeddedPlugin writes the embedded plugin data to pluginDir and records its hash
+func installEmbeddedPlugin(pluginDir string, data []byte) error {
 	// Write plugin to disk
 	pluginPath := filepath.Join(pluginDir, GetSsmPluginName())
 	if err := os.WriteFile(pluginPath, data, 0755); err != nil {
-		return nil, fmt.Errorf("failed to write plugin file: %w", err)
+		return fmt.Errorf("failed to write plugin file: %w", err)
 	}
 
 	// Calculate hash
@@ -155,11 +321,7 @@ func getEmbeddedPlugin(pluginDir string) ([]byte, error) {
 		Source:      "embedded",
 		Hash:        hash,
 	}
-	if err := savePluginInfo(filepath.Join(pluginDir, pluginInfoFile), info); err != nil {
-		return nil, err
-	}
-
-	return data, nil
+	return savePluginInfo(filepath.Join(pluginDir, pluginInfoFile), info)
 }
 
 // downloadPlugin downloads and installs the specified plugin version
@@ -172,7 +334,7 @@ func downloadPlugin(pluginDir string, version string) error {
 		if err != nil {
 			return fmt.Errorf("failed to determine latest version: %w", err)
 		}
-		fmt.Printf("Latest version is: %s\n", actualVersion)
+		Debugf("Latest version is: %s", actualVersion)
 	}
 
 	// Determine platform-specific download URL and extraction method
@@ -181,7 +343,7 @@ func downloadPlugin(pluginDir string, version string) error {
 		return err
 	}
 
-	fmt.Printf("Downloading from: %s\n", downloadURL)
+	Debugf("Downloading from: %s", downloadURL)
 
 	// Create HTTP client with timeout
 	client := &http.Client{
@@ -200,7 +362,7 @@ func downloadPlugin(pluginDir string, version string) error {
 	}
 
 	// Create a temporary file to store the download
-	tempFile, err := os.CreateTemp("", "session-manager-plugin-*")
+	tempFile, err := makeTempFile(pluginDir, "session-manager-plugin-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
@@ -238,10 +400,10 @@ func downloadPlugin(pluginDir string, version string) error {
 	}
 
 	if err := savePluginInfo(filepath.Join(pluginDir, pluginInfoFile), info); err != nil {
-		fmt.Printf("Warning: failed to save plugin info: %v\n", err)
+		Warnf("Failed to save plugin info: %v", err)
 	}
 
-	fmt.Printf("Successfully installed AWS Session Manager Plugin version %s\n", actualVersion)
+	Infof("Successfully installed AWS Session Manager Plugin version %s", actualVersion)
 	return nil
 }
 
@@ -357,7 +519,7 @@ func extractBinary(srcPath, destDir string) (string, error) {
 // extractFromDeb extracts the plugin binary from a .deb package
 func extractFromDeb(debPath, destDir string) (string, error) {
 	// Create a temporary directory to extract files
-	tempDir, err := os.MkdirTemp("", "deb-extract-*")
+	tempDir, err := makeTempDir(destDir, "deb-extract-*")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
@@ -434,7 +596,7 @@ func extractFromRpm(rpmPath, destDir string) (string, error) {
 
 	if rpm2cpioExists != "" && cpioExists != "" {
 		// Create a temporary directory to extract files
-		tempDir, err := os.MkdirTemp("", "rpm-extract-*")
+		tempDir, err := makeTempDir(destDir, "rpm-extract-*")
 		if err != nil {
 			return "", fmt.Errorf("failed to create temp directory: %w", err)
 		}
@@ -484,7 +646,7 @@ func extractFromRpm(rpmPath, destDir string) (string, error) {
 // extractFromPkg extracts the plugin binary from a Mac .pkg package
 func extractFromPkg(pkgPath, destDir string) (string, error) {
 	// Create a temporary directory for extraction
-	tempDir, err := os.MkdirTemp("", "ssm-plugin-extract-*")
+	tempDir, err := makeTempDir(destDir, "ssm-plugin-extract-*")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
@@ -717,6 +879,11 @@ func getLatestVersion() (string, error) {
 	return version, nil
 }
 
+// LoadPluginInfo returns the metadata of the plugin installed in the plugin directory
+func LoadPluginInfo() (PluginInfo, error) {
+	return loadPluginInfo(filepath.Join(GetPluginDirectory(), pluginInfoFile))
+}
+
 // loadPluginInfo loads plugin metadata from file
 func loadPluginInfo(filePath string) (PluginInfo, error) {
 	var info PluginInfo
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanStaleTempFiles(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	root := filepath.Join(home, ".gossm", "plugins", tempDirName)
+
+	stale := time.Now().Add(-2 * staleTempAge)
+	paths := map[string]bool{
+		filepath.Join(root, "download-1"):                    true,  // Stale gossm temp dir
+		filepath.Join(root, "download-2"):                    false, // Recent, possibly in use
+		filepath.Join(tmp, "deb-extract-123456"):             true,  // Stale temp dir of an earlier version
+		filepath.Join(tmp, "session-manager-plugin-1.2.deb"): false, // Not created by gossm
+	}
+	for path, old := range paths {
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if old {
+			if err := os.Chtimes(path, stale, stale); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.Chtimes(filepath.Join(tmp, "session-manager-plugin-1.2.deb"), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanStaleTempFiles(GetPluginDirectory(), time.Now().Add(-staleTempAge))
+
+	for path, removed := range paths {
+		_, err := os.Stat(path)
+		if removed != os.IsNotExist(err) {
+			t.Errorf("%s removed = %v, want %v", filepath.Base(path), os.IsNotExist(err), removed)
+		}
+	}
+}
+
+func TestTempRootIsPrivate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TMPDIR", t.TempDir())
+
+	root, err := tempRoot(GetPluginDirectory())
+	if err != nil {
+		t.Fatalf("tempRoot() error = %v", err)
+	}
+	if want := filepath.Join(home, ".gossm", "plugins", tempDirName); root != want {
+		t.Errorf("tempRoot() = %s, want %s", root, want)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("tempRoot() permissions = %v, want 0700", info.Mode().Perm())
+	}
+}
+
+func TestWriteSsmPluginReplacesSameSizePlugin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session-manager-plugin")
+	if err := os.WriteFile(path, []byte("old build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size, different content
+	if err := WriteSsmPlugin(path, []byte("new build")); err != nil {
+		t.Fatalf("WriteSsmPlugin() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new build" {
+		t.Errorf("plugin = %q, want %q", got, "new build")
+	}
+}
+
+func TestGetSsmPluginReplacesStaleEmbeddedPlugin(t *testing.T) {
+	embedded, err := readEmbeddedPlugin()
+	if err != nil {
+		t.Skipf("no embedded plugin for this platform: %v", err)
+	}
+
+	// An embedded plugin extracted by an older gossm
+	dir := t.TempDir()
+	pluginPath := filepath.Join(dir, GetSsmPluginName())
+	if err := os.WriteFile(pluginPath, []byte("old build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldHash, _ := calculateHash([]byte("old build"))
+	if err := savePluginInfo(filepath.Join(dir, pluginInfoFile), PluginInfo{Version: "embedded", Source: PluginSourceEmbedded, Hash: oldHash}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := GetSsmPlugin(dir, "", PluginSourceEmbedded)
+	if err != nil {
+		t.Fatalf("GetSsmPlugin() error = %v", err)
+	}
+	installed, err := os.ReadFile(pluginPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, embedded) || !bytes.Equal(installed, embedded) {
+		t.Errorf("GetSsmPlugin() kept the stale plugin (%d bytes returned, %d installed), want the embedded one (%d bytes)",
+			len(data), len(installed), len(embedded))
+	}
+
+	// The current build is kept as it is
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(pluginPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSsmPlugin(dir, "", PluginSourceEmbedded); err != nil {
+		t.Fatalf("GetSsmPlugin() error = %v", err)
+	}
+	if info, err := os.Stat(pluginPath); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("GetSsmPlugin() rewrote the current embedded plugin")
+	}
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sessionBannerPrefix starts the line the SSM plugin prints before the session is established
+	sessionBannerPrefix = "Starting session with SessionId:"
+
+	// sessionExitPrefix starts the line the SSM plugin prints when the session ends
+	sessionExitPrefix = "Exiting session with sessionId:"
+)
+
+// connectWatcher terminates a process that doesn't establish its session within a timeout.
+// The session counts as established once the process writes output other than the plugin banner.
+type connectWatcher struct {
+	timeout   time.Duration
+	onReady   func() // Called once when the session is established, if set
+	ready     chan struct{}
+	readyOnce sync.Once
+	done      chan struct{}
+	timedOut  atomic.Bool
+}
+
+// newConnectWatcher returns a watcher for the timeout; a zero timeout disables it
+func newConnectWatcher(timeout time.Duration) *connectWatcher {
+	return &connectWatcher{
+		timeout: timeout,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// wrap returns a writer that marks the session established on the first output after the banner
+func (c *connectWatcher) wrap(w io.Writer) io.Writer {
+	if c.timeout <= 0 && c.onReady == nil {
+		return w
+	}
+	return &readinessWriter{Writer: w, watcher: c}
+}
+
+// start watches the process, terminating it gracefully if the session isn't established in time
+func (c *connectWatcher) start(process stoppableProcess, out io.Writer, after func(time.Duration) <-chan time.Time) {
+	if c.timeout <= 0 {
+		return
+	}
+
+	go func() {
+		select {
+		case <-c.ready:
+		case <-c.done:
+		case <-after(c.timeout):
+			c.timedOut.Store(true)
+			fmt.Fprintf(out, "\r\nNo session established within %s, terminating...\r\n", c.timeout)
+			terminateGracefully(process, out, after)
+		}
+	}()
+}
+
+// stop ends the watch once the process has exited. It returns the timeout error if the
+// watcher terminated the process, and err otherwise.
+func (c *connectWatcher) stop(err error) error {
+	close(c.done)
+	if c.timedOut.Load() {
+		return fmt.Errorf("%w within %s", ErrConnectTimeout, c.timeout)
+	}
+	return err
+}
+
+// markReady records that the session was established
+func (c *connectWatcher) markReady() {
+	c.readyOnce.Do(func() {
+		close(c.ready)
+		if c.onReady != nil {
+			c.onReady()
+		}
+	})
+}
+
+// readinessWriter passes output through and tells its watcher when the session is established
+type readinessWriter struct {
+	io.Writer
+	watcher *connectWatcher
+}
+
+// Write marks the session established unless p only holds the plugin banner, then writes p
+func (w *readinessWriter) Write(p []byte) (int, error) {
+	if !isSessionBanner(p) {
+		w.watcher.markReady()
+	}
+	return w.Writer.Write(p)
+}
+
+// quietSessionWriter drops the session start and exit messages of the plugin
+type quietSessionWriter struct {
+	io.Writer
+}
+
+// Write discards p if it only holds a plugin session message, and writes it otherwise
+func (w *quietSessionWriter) Write(p []byte) (int, error) {
+	output := strings.TrimSpace(string(p))
+	if output != "" && !strings.Contains(output, "\n") &&
+		(strings.HasPrefix(output, sessionBannerPrefix) || strings.HasPrefix(output, sessionExitPrefix)) {
+		return len(p), nil
+	}
+	return w.Writer.Write(p)
+}
+
+// isSessionBanner reports whether output is blank or only the banner the plugin prints
+// before it connects
+func isSessionBanner(p []byte) bool {
+	output := strings.TrimSpace(string(p))
+	return output == "" || (strings.HasPrefix(output, sessionBannerPrefix) && !strings.Contains(output, "\n"))
+}
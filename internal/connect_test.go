@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsSessionBanner(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{output: "\nStarting session with SessionId: user-0123456789abcdef0\n", want: true},
+		{output: "\r\n", want: true},
+		{output: "", want: true},
+		{output: "sh-4.2$ ", want: false},
+		{output: "Starting session with SessionId: user-0123\nsh-4.2$ ", want: false},
+		{output: "Port 8080 opened for sessionId user-0123.\n", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isSessionBanner([]byte(tt.output)); got != tt.want {
+			t.Errorf("isSessionBanner(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestConnectWatcherReadiness(t *testing.T) {
+	watcher := newConnectWatcher(time.Second)
+	var out bytes.Buffer
+	w := watcher.wrap(&out)
+
+	w.Write([]byte("Starting session with SessionId: user-0123\n"))
+	select {
+	case <-watcher.ready:
+		t.Fatal("banner marked the session established")
+	default:
+	}
+
+	w.Write([]byte("Waiting for connections...\n"))
+	select {
+	case <-watcher.ready:
+	default:
+		t.Fatal("output after the banner did not mark the session established")
+	}
+
+	if out.String() != "Starting session with SessionId: user-0123\nWaiting for connections...\n" {
+		t.Errorf("output = %q, want it passed through unchanged", out.String())
+	}
+}
+
+func TestConnectWatcherDisabled(t *testing.T) {
+	watcher := newConnectWatcher(0)
+	var out bytes.Buffer
+
+	if w := watcher.wrap(&out); w != &out {
+		t.Error("wrap() with a zero timeout should return the writer unchanged")
+	}
+	if err := watcher.stop(nil); err != nil {
+		t.Errorf("stop() = %v, want nil", err)
+	}
+}
+
+func TestConnectWatcherStopPassesError(t *testing.T) {
+	watcher := newConnectWatcher(time.Second)
+	processErr := errors.New("exit status 1")
+
+	if err := watcher.stop(processErr); err != processErr {
+		t.Errorf("stop() = %v, want %v", err, processErr)
+	}
+}
+
+func TestConnectWatcherReadyEvent(t *testing.T) {
+	var events bytes.Buffer
+	SetEventOutput(&events)
+	defer SetEventOutput(nil)
+
+	opts := ProcessOptions{SessionID: "user-0123", Target: "i-0123456789abcdef0", Region: "eu-west-1"}
+	watcher := newConnectWatcher(0)
+	watcher.onReady = opts.readyEvent()
+	w := watcher.wrap(&bytes.Buffer{})
+
+	w.Write([]byte("Starting session with SessionId: user-0123\n"))
+	if events.Len() != 0 {
+		t.Fatalf("banner emitted %q", events.String())
+	}
+
+	// Readiness is tracked for events even without a timeout, and reported once
+	w.Write([]byte("sh-4.2$ "))
+	w.Write([]byte("ls\n"))
+
+	var event Event
+	if err := json.Unmarshal(events.Bytes(), &event); err != nil {
+		t.Fatalf("events = %q, want a single JSON line: %v", events.String(), err)
+	}
+	if event.Event != EventSessionReady || event.SessionID != opts.SessionID || event.Target != opts.Target {
+		t.Errorf("event = %+v, want session_ready for %s on %s", event, opts.SessionID, opts.Target)
+	}
+}
+
+func TestQuietSessionWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &quietSessionWriter{Writer: &out}
+
+	w.Write([]byte("\nStarting session with SessionId: user-0123\n"))
+	w.Write([]byte("sh-4.2$ exit\n"))
+	w.Write([]byte("\n\nExiting session with sessionId: user-0123.\n\n"))
+
+	if out.String() != "sh-4.2$ exit\n" {
+		t.Errorf("output = %q, want only the session output", out.String())
+	}
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+const (
+	// maxDescribeTasks is the number of tasks DescribeTasks accepts per call
+	maxDescribeTasks = 100
+
+	// agentStatusRunning is the status of a running ECS managed agent
+	agentStatusRunning = "RUNNING"
+)
+
+// ECSContainer is a running container that can be reached with ECS Exec
+type ECSContainer struct {
+	Cluster        string // Cluster name
+	TaskID         string // Task ID, the last part of the task ARN
+	TaskDefinition string // Task definition family and revision
+	Name           string // Container name
+	RuntimeID      string // Container runtime ID
+}
+
+// Target returns the SSM target of the container, ecs:<cluster>_<task-id>_<runtime-id>
+func (c *ECSContainer) Target() string {
+	return fmt.Sprintf("ecs:%s_%s_%s", c.Cluster, c.TaskID, c.RuntimeID)
+}
+
+// label returns the option shown for the container in the picker
+func (c *ECSContainer) label() string {
+	return fmt.Sprintf("%s\t(%s)\t%s", c.TaskDefinition, c.TaskID, c.Name)
+}
+
+// ListECSClusters returns the names of the ECS clusters in the region
+func ListECSClusters(ctx context.Context, cfg aws.Config) ([]string, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	var clusters []string
+	paginator := ecs.NewListClustersPaginator(client, &ecs.ListClustersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ECS clusters: %w", err)
+		}
+		for _, arn := range page.ClusterArns {
+			clusters = append(clusters, lastARNPart(arn))
+		}
+	}
+
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// FindECSContainers returns the running containers of a cluster whose tasks have ECS Exec
+// enabled and whose exec agent is running
+func FindECSContainers(ctx context.Context, cfg aws.Config, cluster string) ([]*ECSContainer, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	// List running tasks
+	var taskARNs []string
+	paginator := ecs.NewListTasksPaginator(client, &ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		DesiredStatus: ecstypes.DesiredStatusRunning,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ECS tasks: %w", err)
+		}
+		taskARNs = append(taskARNs, page.TaskArns...)
+	}
+
+	// Describe tasks in batches
+	var containers []*ECSContainer
+	for len(taskARNs) > 0 {
+		batchSize := min(len(taskARNs), maxDescribeTasks)
+		batch := taskARNs[:batchSize]
+		taskARNs = taskARNs[batchSize:]
+
+		output, err := client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   batch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe ECS tasks: %w", err)
+		}
+
+		for _, task := range output.Tasks {
+			if !task.EnableExecuteCommand {
+				continue
+			}
+			for _, container := range task.Containers {
+				if container.RuntimeId == nil || !execAgentRunning(container) {
+					continue
+				}
+				containers = append(containers, &ECSContainer{
+					Cluster:        cluster,
+					TaskID:         lastARNPart(aws.ToString(task.TaskArn)),
+					TaskDefinition: lastARNPart(aws.ToString(task.TaskDefinitionArn)),
+					Name:           aws.ToString(container.Name),
+					RuntimeID:      aws.ToString(container.RuntimeId),
+				})
+			}
+		}
+	}
+
+	return containers, nil
+}
+
+// execAgentRunning reports whether the ECS Exec agent of a container is running
+func execAgentRunning(container ecstypes.Container) bool {
+	for _, agent := range container.ManagedAgents {
+		if agent.Name == ecstypes.ManagedAgentNameExecuteCommandAgent {
+			return aws.ToString(agent.LastStatus) == agentStatusRunning
+		}
+	}
+	return false
+}
+
+// lastARNPart returns the resource name or ID at the end of an ARN
+func lastARNPart(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// AskECSCluster prompts the user to select an ECS cluster
+func AskECSCluster(clusters []string) (string, error) {
+	if len(clusters) == 0 {
+		return "", errors.New("no ECS clusters found")
+	}
+
+	if err := requireTerminal("cluster", "--cluster"); err != nil {
+		return "", err
+	}
+
+	prompt := &survey.Select{
+		Message: "Choose an ECS cluster:",
+		Options: clusters,
+	}
+
+	var selected string
+	err := survey.AskOne(prompt, &selected,
+		survey.WithIcons(func(icons *survey.IconSet) {
+			icons.SelectFocus.Format = "green+hb"
+		}),
+		survey.WithPageSize(20))
+
+	if err != nil {
+		return "", fmt.Errorf("cluster selection failed: %w", err)
+	}
+
+	return selected, nil
+}
+
+// AskECSContainer prompts the user to select a container
+func AskECSContainer(containers []*ECSContainer) (*ECSContainer, error) {
+	if len(containers) == 0 {
+		return nil, ErrNoContainers
+	}
+
+	if err := requireTerminal("container", "--task or --container"); err != nil {
+		return nil, err
+	}
+
+	// Create a list of container options
+	table := make(map[string]*ECSContainer, len(containers))
+	options := make([]string, 0, len(containers))
+	for _, container := range containers {
+		table[container.label()] = container
+		options = append(options, container.label())
+	}
+	sort.Strings(options)
+
+	prompt := &survey.Select{
+		Message: "Choose a container:",
+		Options: options,
+	}
+
+	var selectedKey string
+	err := survey.AskOne(prompt, &selectedKey,
+		survey.WithIcons(func(icons *survey.IconSet) {
+			icons.SelectFocus.Format = "green+hb"
+		}),
+		survey.WithPageSize(20))
+
+	if err != nil {
+		return nil, fmt.Errorf("container selection failed: %w", err)
+	}
+
+	return table[selectedKey], nil
+}
+
+// ExecuteECSCommand starts an interactive ECS Exec session running command in the container
+func ExecuteECSCommand(ctx context.Context, cfg aws.Config, container *ECSContainer, command string) (*ecstypes.Session, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	input := &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(container.Cluster),
+		Task:        aws.String(container.TaskID),
+		Container:   aws.String(container.Name),
+		Command:     aws.String(command),
+		Interactive: true,
+	}
+	Tracef("ExecuteCommand input: %s", toJSON(input))
+
+	output, err := client.ExecuteCommand(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	EmitEvent(EventSessionCreated, aws.ToString(output.Session.SessionId), container.Target(), cfg.Region)
+	return output.Session, nil
+}
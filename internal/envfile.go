@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadEnvFile sets the environment variables defined in a dotenv file, one KEY=VALUE per line.
+// Blank lines, # comments and an "export " prefix are allowed, and values may be quoted.
+// Variables that are already set are kept unless override is set. It returns the names of the
+// variables it set.
+func LoadEnvFile(path string, override bool) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	defer file.Close()
+
+	var set []string
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		key, value, ok, err := parseEnvLine(scanner.Text())
+		if err != nil {
+			return set, fmt.Errorf("invalid env file %s, line %d: %w", path, lineNumber, err)
+		}
+		if !ok {
+			continue
+		}
+		if _, exists := os.LookupEnv(key); exists && !override {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return set, fmt.Errorf("failed to set %s from env file: %w", key, err)
+		}
+		set = append(set, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return set, fmt.Errorf("failed to read env file %s: %w", path, err)
+	}
+
+	return set, nil
+}
+
+// parseEnvLine parses a dotenv line, reporting false for blank lines and comments
+func parseEnvLine(line string) (string, string, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+	line = strings.TrimPrefix(line, "export ")
+
+	key, value, found := strings.Cut(line, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false, fmt.Errorf("expected KEY=VALUE")
+	}
+
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return key, value[1 : len(value)-1], true, nil
+	}
+	// Unquoted values may end with a comment
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return key, value, true, nil
+}
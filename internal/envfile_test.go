@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# Project AWS settings
+export AWS_PROFILE=staging
+AWS_REGION = "eu-west-1"
+GOSSM_FILTER='Env=staging' 
+
+GOSSM_MAX_INSTANCES=10 # enough for staging
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, override := range []bool{false, true} {
+		t.Setenv("AWS_PROFILE", "production")
+		t.Setenv("AWS_REGION", "")
+		os.Unsetenv("AWS_REGION")
+		t.Setenv("GOSSM_FILTER", "")
+		os.Unsetenv("GOSSM_FILTER")
+		t.Setenv("GOSSM_MAX_INSTANCES", "")
+		os.Unsetenv("GOSSM_MAX_INSTANCES")
+
+		set, err := LoadEnvFile(path, override)
+		if err != nil {
+			t.Fatalf("LoadEnvFile(override=%t) error = %v", override, err)
+		}
+
+		wantProfile, wantSet := "production", []string{"AWS_REGION", "GOSSM_FILTER", "GOSSM_MAX_INSTANCES"}
+		if override {
+			wantProfile, wantSet = "staging", []string{"AWS_PROFILE", "AWS_REGION", "GOSSM_FILTER", "GOSSM_MAX_INSTANCES"}
+		}
+		if !slices.Equal(set, wantSet) {
+			t.Errorf("LoadEnvFile(override=%t) set %v, want %v", override, set, wantSet)
+		}
+		want := map[string]string{
+			"AWS_PROFILE":         wantProfile,
+			"AWS_REGION":          "eu-west-1",
+			"GOSSM_FILTER":        "Env=staging",
+			"GOSSM_MAX_INSTANCES": "10",
+		}
+		for key, value := range want {
+			if got := os.Getenv(key); got != value {
+				t.Errorf("override=%t: %s = %q, want %q", override, key, got, value)
+			}
+		}
+	}
+}
+
+func TestLoadEnvFileInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("AWS_REGION=eu-west-1\nnot a variable\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_REGION", "")
+
+	if _, err := LoadEnvFile(path, true); err == nil {
+		t.Error("LoadEnvFile() succeeded, want an error for line 2")
+	}
+}
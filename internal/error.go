@@ -6,6 +6,7 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/aws/smithy-go"
 	"github.com/gjbae1212/go-wraperror"
 )
 
@@ -16,8 +17,66 @@ var (
 
 	// ErrUnknown is returned when the error reason cannot be determined
 	ErrUnknown = errors.New("unknown error")
+
+	// ErrNoInstances is returned when discovery finds no SSM-connected instances
+	ErrNoInstances = errors.New("no EC2 instances found")
+
+	// ErrNoMatchingInstances is returned when instances were found but none passed the discovery filters
+	ErrNoMatchingInstances = errors.New("no instances matched")
+
+	// ErrNoContainers is returned when no running container with ECS Exec enabled is found
+	ErrNoContainers = errors.New("no containers with ECS Exec enabled found")
+
+	// ErrAgentOffline is returned when the SSM agent of a target is not online
+	ErrAgentOffline = errors.New("SSM agent is not online")
+
+	// ErrAmbiguousHost is returned when a host or IP address matches more than one instance
+	ErrAmbiguousHost = errors.New("host matches more than one instance")
+
+	// ErrConnectTimeout is returned when a session isn't established within the connect timeout
+	ErrConnectTimeout = errors.New("failed to establish session")
+
+	// ErrPluginFailed is returned when the SSM plugin reports that it couldn't start the session
+	ErrPluginFailed = errors.New("SSM plugin failed to start the session")
+
+	// ErrNotConfirmed is returned when the user doesn't confirm running a command on many instances
+	ErrNotConfirmed = errors.New("command not confirmed")
+
+	// ErrCommandFailed is returned when a Run Command invocation fails on an instance
+	ErrCommandFailed = errors.New("command failed")
+
+	// ErrCommandTimeout is returned when a Run Command invocation doesn't finish before the deadline
+	ErrCommandTimeout = errors.New("command did not finish in time")
+
+	// ErrNoTerminal is returned when a prompt is needed but stdin is not a terminal to answer it on
+	ErrNoTerminal = errors.New("no terminal available for selection")
+
+	// ErrSessionLimit is returned when a session is terminated by its idle timeout or maximum duration
+	ErrSessionLimit = errors.New("session limit reached")
+
+	// ErrMFATimeout is returned when an AWS request of the mfa command doesn't complete in time
+	ErrMFATimeout = errors.New("MFA request timed out")
+
+	// ErrEscapeDisconnect is returned when the plugin fails to stop after disconnecting with the escape sequence
+	ErrEscapeDisconnect = errors.New("disconnected with the escape sequence")
 )
 
+// HintError is an error annotated with a human readable remediation hint
+type HintError struct {
+	Err  error  // Underlying error
+	Hint string // Suggested remediation
+}
+
+// Error returns the underlying error message followed by the hint
+func (e *HintError) Error() string {
+	return fmt.Sprintf("%s\n  hint: %s", e.Err.Error(), e.Hint)
+}
+
+// Unwrap returns the underlying error
+func (e *HintError) Unwrap() error {
+	return e.Err
+}
+
 // WrapError wraps an error with file and line information for better debugging
 // If the input error is nil, nil is returned
 func WrapError(err error) error {
@@ -37,3 +96,92 @@ func WrapError(err error) error {
 	chainErr := wraperror.Error(err)
 	return chainErr.Wrap(fmt.Errorf("%s:%d", funcName, line))
 }
+
+// TranslateError annotates common AWS API errors with an actionable hint.
+// Errors that are not recognized, or that already carry a hint, are returned unchanged.
+func TranslateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var hintErr *HintError
+	if errors.As(err, &hintErr) {
+		return err
+	}
+
+	if hint := hintForError(err); hint != "" {
+		return &HintError{Err: err, Hint: hint}
+	}
+
+	return err
+}
+
+// hintForError returns a remediation hint for a recognized error, or an empty string
+func hintForError(err error) string {
+	if errors.Is(err, ErrNoInstances) {
+		return "make sure the SSM agent is running on your instances, they have an instance profile " +
+			"with AmazonSSMManagedInstanceCore, and you selected the right region"
+	}
+
+	if errors.Is(err, ErrNoMatchingInstances) {
+		return "tag keys and values are case-sensitive, run gossm ls without the filters to see the available instances"
+	}
+
+	if errors.Is(err, ErrNoContainers) {
+		return "enable ECS Exec on the service or task (--enable-execute-command) and make sure the task role " +
+			"allows the ssmmessages actions"
+	}
+
+	if errors.Is(err, ErrNotConfirmed) {
+		return "narrow the targets, e.g. with --filter, or pass --yes if the command should run on all of them"
+	}
+
+	if errors.Is(err, ErrCommandTimeout) {
+		return "the command keeps running on the instances, raise --timeout or check on it later with gossm cmd-status"
+	}
+
+	if errors.Is(err, ErrMFATimeout) {
+		return "check your network connection to AWS, or allow more time with --timeout"
+	}
+
+	if errors.Is(err, ErrAgentOffline) {
+		return "check that the instance is running and its SSM agent can reach the SSM endpoints, or pick another instance"
+	}
+
+	if errors.Is(err, ErrAmbiguousHost) {
+		return "VPCs with overlapping IP ranges can reuse an address, pass --instance-id to pick the instance"
+	}
+
+	if errors.Is(err, ErrPluginFailed) {
+		return "check that outbound HTTPS to ssmmessages.<region>.amazonaws.com is allowed, including through any proxy"
+	}
+
+	if errors.Is(err, ErrConnectTimeout) {
+		return "check that the instance can reach the SSM endpoints and that outbound HTTPS to ssmmessages is allowed"
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+
+	switch apiErr.ErrorCode() {
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation":
+		var opErr *smithy.OperationError
+		if errors.As(err, &opErr) {
+			return fmt.Sprintf("your IAM role likely lacks %s:%s",
+				strings.ToLower(opErr.Service()), opErr.Operation())
+		}
+		return "your IAM role likely lacks the permission required for this operation"
+	case "ExpiredToken", "ExpiredTokenException", "RequestExpired":
+		return "your AWS credentials have expired, refresh them (for example with gossm mfa or aws sso login)"
+	case "InvalidClientTokenId", "UnrecognizedClientException", "AuthFailure":
+		return "your AWS credentials are invalid, check the selected profile and credentials file"
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return "AWS kept throttling the API calls after retrying, wait a moment or narrow discovery with --filter or a single --region"
+	case "InvalidInstanceId", "TargetNotConnected":
+		return "the instance is not connected to SSM, check that the SSM agent is running and online"
+	}
+
+	return ""
+}
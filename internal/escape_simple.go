@@ -7,26 +7,127 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	"golang.org/x/term"
 )
 
+// DefaultEscapeChar is the escape character used unless configured otherwise, as in ssh
+const DefaultEscapeChar = '~'
+
+// ProcessOptions configures how an interactive process is attached to the terminal
+type ProcessOptions struct {
+	EscapeChar byte // Escape character, or 0 to disable escape sequences
+
+	// Session details shown by the session-info escape
+	SessionID string
+	Target    string
+	Region    string
+
+	// Transcript, if set, receives a copy of the process output, and of the input sent to
+	// the process when TranscriptInput is set
+	Transcript      io.Writer
+	TranscriptInput bool
+
+	// ConnectTimeout terminates the process if the session isn't established in time, 0 waits indefinitely
+	ConnectTimeout time.Duration
+
+	// stderr captures the process's stderr to explain failures, set by CallProcess
+	stderr *pluginStderr
+
+	// IdleTimeout terminates an interactive session without input or output for this long,
+	// MaxDuration one that has been open for this long; 0 disables them
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+
+	// QuietSession drops the plugin's session start and exit messages and the newlines
+	// added to align them
+	QuietSession bool
+}
+
+// stdout returns the writer for the process output, teeing it to the transcript if set
+func (o ProcessOptions) stdout() io.Writer {
+	var w io.Writer = os.Stdout
+	if o.Transcript != nil {
+		w = io.MultiWriter(os.Stdout, o.Transcript)
+	}
+	if o.QuietSession {
+		w = &quietSessionWriter{Writer: w}
+	}
+	return w
+}
+
+// readyEvent returns the function that emits the session_ready event once the session is
+// established, or nil without a session or with events disabled
+func (o ProcessOptions) readyEvent() func() {
+	if o.SessionID == "" || !EventsEnabled() {
+		return nil
+	}
+	return func() { EmitEvent(EventSessionReady, o.SessionID, o.Target, o.Region) }
+}
+
+// teeWriteCloser writes to several writers and closes the underlying process input
+type teeWriteCloser struct {
+	io.Writer
+	io.Closer
+}
+
+// stdin returns the writer for the process input, teeing it to the transcript if requested
+func (o ProcessOptions) stdin(pipe io.WriteCloser) io.WriteCloser {
+	if o.Transcript == nil || !o.TranscriptInput {
+		return pipe
+	}
+	return teeWriteCloser{Writer: io.MultiWriter(pipe, o.Transcript), Closer: pipe}
+}
+
+// printEscapeHelp writes the list of supported escape sequences, as ssh does for ~?
+func printEscapeHelp(w io.Writer, escapeChar byte) {
+	fmt.Fprintf(w, "\r\nSupported escape sequences:\r\n")
+	fmt.Fprintf(w, " %c.  - terminate session\r\n", escapeChar)
+	fmt.Fprintf(w, " %c#  - show session information\r\n", escapeChar)
+	fmt.Fprintf(w, " %c?  - this message\r\n", escapeChar)
+	fmt.Fprintf(w, "(Note that escapes are only recognized immediately after newline.)\r\n")
+}
+
+// printSessionInfo writes the details of the current session
+func printSessionInfo(w io.Writer, opts ProcessOptions) {
+	fmt.Fprintf(w, "\r\n%s session: %s, target: %s, region: %s\r\n",
+		color.GreenString("[gossm]"), opts.SessionID, opts.Target, opts.Region)
+}
+
+// ParseEscapeChar parses an escape character setting like ssh's -e option:
+// a single character, "^" followed by a character for a control character, or "none"
+func ParseEscapeChar(value string) (byte, error) {
+	switch {
+	case value == "none":
+		return 0, nil
+	case len(value) == 1:
+		return value[0], nil
+	case len(value) == 2 && value[0] == '^':
+		return value[1] & 0x1f, nil
+	default:
+		return 0, fmt.Errorf("invalid escape character '%s' (use a single character, ^X or none)", value)
+	}
+}
+
 // CallProcessWithSimpleEscape executes a process with simple escape sequence support
 // This version passes stdin directly to avoid echo issues
-func CallProcessWithSimpleEscape(process string, args ...string) error {
+func CallProcessWithSimpleEscape(opts ProcessOptions, process string, args ...string) error {
 	// Check if stdin is a terminal
 	if !term.IsTerminal(int(os.Stdin.Fd())) {
 		// Not a terminal, fall back to direct process execution
-		return CallProcessDirect(process, args...)
+		return callProcessDirect(opts, process, args...)
 	}
 
 	// Create command with direct stdin/stdout/stderr
+	connect := newConnectWatcher(opts.ConnectTimeout)
+	connect.onReady = opts.readyEvent()
+	limits := newSessionLimits(opts)
 	cmd := exec.Command(process, args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	opts.stderr.attach(cmd)
+	output := attachTerminalOutput(cmd, limits.wrap(connect.wrap(opts.stdout())))
+	defer output.close()
 	
 	// Create a pipe for stdin so we can monitor it
 	stdinPipe, err := cmd.StdinPipe()
@@ -38,13 +139,16 @@ func CallProcessWithSimpleEscape(process string, args ...string) error {
 	if err := cmd.Start(); err != nil {
 		return WrapError(err)
 	}
+	output.start()
+	connect.start(cmd.Process, os.Stderr, time.After)
+	limits.start(cmd.Process, os.Stderr)
 
 	// Set terminal to raw mode to capture escape sequences
 	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
 	if err != nil {
 		// If we can't set raw mode, just pass through directly
 		cmd.Stdin = os.Stdin
-		return cmd.Wait()
+		return limits.stop(connect.stop(cmd.Wait()))
 	}
 	
 	// Ensure we restore terminal state on exit
@@ -54,10 +158,12 @@ func CallProcessWithSimpleEscape(process string, args ...string) error {
 	
 	// Add a small delay then print newline to fix prompt alignment
 	// The SSM plugin prints "Starting session..." without a newline
-	go func() {
-		time.Sleep(100 * time.Millisecond)
-		fmt.Fprintf(os.Stderr, "\r\n")
-	}()
+	if !opts.QuietSession {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintf(os.Stderr, "\r\n")
+		}()
+	}
 
 	// Set up contexts and channels
 	ctx, cancel := context.WithCancel(context.Background())
@@ -68,12 +174,12 @@ func CallProcessWithSimpleEscape(process string, args ...string) error {
 	escapeDetected := make(chan bool, 1)
 	
 	go func() {
-		stdinErr <- copyWithEscapeDetection(ctx, stdinPipe, os.Stdin, escapeDetected)
+		stdinErr <- copyWithEscapeDetection(ctx, limits.wrapInput(opts.stdin(stdinPipe)), os.Stdin, os.Stderr, opts, escapeDetected)
 	}()
 
 	// Set up signal handling
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, forwardedSignals...)
 	defer signal.Stop(sigs)
 
 	// Wait for process completion, escape sequence, or signal
@@ -84,15 +190,20 @@ func CallProcessWithSimpleEscape(process string, args ...string) error {
 
 	select {
 	case err := <-processDone:
-		// Process exited normally
+		// Process exited normally, or was terminated by the connect timeout or a session limit
 		cancel()
+		output.close()
 		// Add newline before the "Exiting session" message for proper alignment
-		fmt.Fprintf(os.Stderr, "\r\n")
-		return err
+		if !opts.QuietSession {
+			fmt.Fprintf(os.Stderr, "\r\n")
+		}
+		return limits.stop(connect.stop(err))
 		
 	case <-escapeDetected:
 		// Escape sequence detected
 		cancel()
+		connect.stop(nil)
+		limits.stop(nil)
 		stdinPipe.Close()
 		
 		// Restore terminal before printing
@@ -101,118 +212,162 @@ func CallProcessWithSimpleEscape(process string, args ...string) error {
 		fmt.Fprintf(os.Stderr, "\r\n%s\r\n", 
 			color.YellowString("Escape sequence detected. Terminating session..."))
 		
-		// Terminate the process gracefully
-		return terminateGracefully(cmd)
+		// Terminate the process gracefully, marking any error so the session isn't reconnected
+		if err := terminateGracefully(cmd.Process, os.Stderr, time.After); err != nil {
+			return fmt.Errorf("%w: %w", ErrEscapeDisconnect, err)
+		}
+		return nil
 		
 	case sig := <-sigs:
 		// Signal received
 		cancel()
 		stdinPipe.Close()
-		cmd.Process.Signal(sig)
+		forwardSignal(cmd.Process, sig)
 		<-processDone
-		return nil
+		limits.stop(nil)
+		return connect.stop(nil)
 		
 	case err := <-stdinErr:
 		// Stdin copy error (likely process died)
 		cancel()
 		<-processDone
-		return err
+		return limits.stop(connect.stop(err))
 	}
 }
 
-// copyWithEscapeDetection copies stdin to the process while detecting escape sequences
-func copyWithEscapeDetection(ctx context.Context, dst io.WriteCloser, src io.Reader, escapeDetected chan<- bool) error {
+// copyWithEscapeDetection copies stdin to the process while detecting escape sequences.
+// Output of escape commands such as help is written to info.
+// Input is read in chunks; an escape character at the end of a chunk is held back until the
+// next byte arrives, and flushed to the process if input ends first, so nothing is dropped.
+func copyWithEscapeDetection(ctx context.Context, dst io.WriteCloser, src io.Reader, info io.Writer, opts ProcessOptions, escapeDetected chan<- bool) error {
 	defer dst.Close()
-	
+
+	escapeChar := opts.EscapeChar
 	lastWasNewline := true
-	tildeSeen := false
-	buf := make([]byte, 1)
-	
+	escapePending := false
+	buf := make([]byte, 4096)
+	out := make([]byte, 0, len(buf)+1)
+
+	// flushPending sends an escape character that turned out not to start a sequence
+	flushPending := func() {
+		if escapePending {
+			dst.Write([]byte{escapeChar})
+			escapePending = false
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
+			flushPending()
 			return nil
 		default:
-			n, err := src.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					return nil
-				}
-				return err
-			}
-			
-			if n == 0 {
-				continue
-			}
-			
-			b := buf[0]
-			
-			// Check for escape sequence only at start of line
-			if lastWasNewline && b == '~' {
-				tildeSeen = true
-				lastWasNewline = false
-				continue // Don't send the tilde yet
-			} else if tildeSeen {
-				if b == '.' {
+		}
+
+		n, readErr := src.Read(buf)
+
+		// Process the bytes read before looking at the error, as io.Reader allows both
+		out = out[:0]
+		for _, b := range buf[:n] {
+			switch {
+			case escapePending:
+				escapePending = false
+
+				switch b {
+				case '.':
 					// Escape sequence complete
+					dst.Write(out)
 					escapeDetected <- true
 					return nil
-				} else {
-					// Not an escape sequence, send the tilde and current char
-					// This handles ~/, ~user, ~~, and any other ~ usage
-					dst.Write([]byte{'~', b})
-				}
-				tildeSeen = false
-				if b == '\r' || b == '\n' {
+				case '?', '#':
+					// Local escape commands are not sent to the process
+					if b == '?' {
+						printEscapeHelp(info, escapeChar)
+					} else {
+						printSessionInfo(info, opts)
+					}
+					// Another escape may follow immediately, as in ssh
 					lastWasNewline = true
-				} else {
-					lastWasNewline = false
+					continue
+				default:
+					// Not an escape sequence, send the escape char and current char
+					// This handles ~/, ~user, ~~, and any other ~ usage
+					out = append(out, escapeChar, b)
 				}
-			} else {
+
+			case escapeChar != 0 && lastWasNewline && b == escapeChar:
+				// Check for escape sequence only at start of line; hold the escape char back
+				escapePending = true
+				lastWasNewline = false
+				continue
+
+			default:
 				// Normal character
-				dst.Write([]byte{b})
-				if b == '\r' || b == '\n' {
-					lastWasNewline = true
-				} else {
-					lastWasNewline = false
-				}
+				out = append(out, b)
+			}
+
+			lastWasNewline = b == '\r' || b == '\n'
+		}
+
+		if len(out) > 0 {
+			if _, err := dst.Write(out); err != nil {
+				return err
 			}
 		}
+
+		if readErr != nil {
+			flushPending()
+			if readErr == io.EOF {
+				return nil
+			}
+			return readErr
+		}
 	}
 }
 
-// terminateGracefully attempts to terminate a process gracefully
-func terminateGracefully(cmd *exec.Cmd) error {
-	// Send SIGTERM first
-	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+// gracefulTerminationTimeout is how long a process may take to exit before it is killed
+const gracefulTerminationTimeout = 3 * time.Second
+
+// stoppableProcess is the part of *os.Process used to stop a process, so it can be faked in tests
+type stoppableProcess interface {
+	Signal(sig os.Signal) error
+	Kill() error
+	Wait() (*os.ProcessState, error)
+}
+
+// terminateGracefully attempts to terminate a process gracefully, killing it if it has not
+// exited when after(gracefulTerminationTimeout) fires. Status messages are written to out.
+func terminateGracefully(process stoppableProcess, out io.Writer, after func(time.Duration) <-chan time.Time) error {
+	// Ask the process to exit first (SIGTERM, or a kill on Windows)
+	if err := requestTermination(process); err != nil {
 		// Process may have already exited
 		return nil
 	}
-	
+
 	// Wait for graceful termination with timeout
 	done := make(chan error, 1)
 	go func() {
-		_, err := cmd.Process.Wait()
+		_, err := process.Wait()
 		done <- err
 	}()
-	
+
 	select {
 	case err := <-done:
 		// Process exited gracefully
-		if err != nil && 
-		   err.Error() != "signal: terminated" && 
-		   err.Error() != "signal: broken pipe" &&
-		   !isWaitError(err) {
+		if err != nil &&
+			err.Error() != "signal: terminated" &&
+			err.Error() != "signal: broken pipe" &&
+			!isWaitError(err) {
 			return err
 		}
-		fmt.Fprintf(os.Stderr, "%s\r\n", 
+		fmt.Fprintf(out, "%s\r\n",
 			color.GreenString("Session terminated gracefully"))
 		return nil
-	case <-time.After(3 * time.Second):
+	case <-after(gracefulTerminationTimeout):
 		// Timeout reached, force kill
-		fmt.Fprintf(os.Stderr, "%s\r\n", 
+		fmt.Fprintf(out, "%s\r\n",
 			color.YellowString("Graceful termination timed out, forcing exit..."))
-		if err := cmd.Process.Kill(); err != nil {
+		if err := process.Kill(); err != nil {
 			// Process may have already exited
 			return nil
 		}
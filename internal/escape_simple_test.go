@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// chunkedReader returns its chunks one Read at a time, then io.EOF
+type chunkedReader struct {
+	chunks []string
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+// bufferCloser is a bytes.Buffer that satisfies io.WriteCloser
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error { return nil }
+
+func TestCopyWithEscapeDetection(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		escapeChar byte
+		wantOutput string
+		wantInfo   string
+		wantEscape bool
+	}{
+		{name: "disconnect at start", input: "~.", wantEscape: true},
+		{name: "disconnect after newline", input: "ls\n~.after", wantOutput: "ls\n", wantEscape: true},
+		{name: "disconnect after carriage return", input: "ls\r~.", wantOutput: "ls\r", wantEscape: true},
+		{name: "home directory", input: "~/bin\n", wantOutput: "~/bin\n"},
+		{name: "double tilde", input: "~~", wantOutput: "~~"},
+		{name: "tilde then enter", input: "~\r~.", wantOutput: "~\r", wantEscape: true},
+		{name: "tilde not at line start", input: "cd ~.", wantOutput: "cd ~."},
+		{name: "EOF mid-sequence", input: "ls\n~", wantOutput: "ls\n~"},
+		{name: "help", input: "~?", wantInfo: "Supported escape sequences"},
+		{name: "session info", input: "~#", wantInfo: "session: s-1, target: i-1, region: r-1"},
+		{name: "custom escape char", input: "~.\r%.", escapeChar: '%', wantOutput: "~.\r", wantEscape: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := ProcessOptions{EscapeChar: DefaultEscapeChar, SessionID: "s-1", Target: "i-1", Region: "r-1"}
+			if tt.escapeChar != 0 {
+				opts.EscapeChar = tt.escapeChar
+			}
+
+			var dst bufferCloser
+			var info bytes.Buffer
+			escapeDetected := make(chan bool, 1)
+
+			err := copyWithEscapeDetection(context.Background(), &dst, strings.NewReader(tt.input), &info, opts, escapeDetected)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := dst.String(); got != tt.wantOutput {
+				t.Errorf("output = %q, want %q", got, tt.wantOutput)
+			}
+			if !strings.Contains(info.String(), tt.wantInfo) {
+				t.Errorf("info = %q, want it to contain %q", info.String(), tt.wantInfo)
+			}
+			if got := len(escapeDetected) == 1; got != tt.wantEscape {
+				t.Errorf("escape detected = %v, want %v", got, tt.wantEscape)
+			}
+		})
+	}
+}
+
+func TestParseEscapeChar(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    byte
+		wantErr bool
+	}{
+		{value: "~", want: '~'},
+		{value: "%", want: '%'},
+		{value: "^]", want: 0x1d},
+		{value: "none", want: 0},
+		{value: "", wantErr: true},
+		{value: "ab", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEscapeChar(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseEscapeChar(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseEscapeChar(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCopyWithEscapeDetectionSplitReads(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		wantOutput string
+		wantEscape bool
+	}{
+		{name: "trailing tilde flushed at EOF", chunks: []string{"ls\n", "~"}, wantOutput: "ls\n~"},
+		{name: "lone tilde flushed at EOF", chunks: []string{"~"}, wantOutput: "~"},
+		{name: "disconnect split across reads", chunks: []string{"ls\n~", ".rest"}, wantOutput: "ls\n", wantEscape: true},
+		{name: "disconnect in one read", chunks: []string{"echo\r~.ignored"}, wantOutput: "echo\r", wantEscape: true},
+		{name: "home path split after tilde", chunks: []string{"~", "/tmp\n"}, wantOutput: "~/tmp\n"},
+		{name: "tilde not at line start", chunks: []string{"a~", "."}, wantOutput: "a~."},
+		{name: "double tilde across reads", chunks: []string{"\r~", "~"}, wantOutput: "\r~~"},
+		{name: "help then disconnect", chunks: []string{"~", "?", "~", "."}, wantOutput: "", wantEscape: true},
+		{name: "byte at a time", chunks: []string{"c", "d", "\n", "~", "x"}, wantOutput: "cd\n~x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst bufferCloser
+			var info bytes.Buffer
+			escapeDetected := make(chan bool, 1)
+
+			err := copyWithEscapeDetection(context.Background(), &dst, &chunkedReader{chunks: tt.chunks}, &info,
+				ProcessOptions{EscapeChar: DefaultEscapeChar}, escapeDetected)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := dst.String(); got != tt.wantOutput {
+				t.Errorf("output = %q, want %q", got, tt.wantOutput)
+			}
+			if got := len(escapeDetected) == 1; got != tt.wantEscape {
+				t.Errorf("escape detected = %v, want %v", got, tt.wantEscape)
+			}
+		})
+	}
+}
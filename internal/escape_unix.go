@@ -0,0 +1,21 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// forwardedSignals are the signals relayed to the child process of an interactive session
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// forwardSignal relays a signal received by gossm to the child process
+func forwardSignal(process stoppableProcess, sig os.Signal) error {
+	return process.Signal(sig)
+}
+
+// requestTermination asks the child process to exit, giving it a chance to clean up
+func requestTermination(process stoppableProcess) error {
+	return process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,175 @@
+//go:build !windows
+
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeProcess records how terminateGracefully tries to stop it
+type fakeProcess struct {
+	mu           sync.Mutex
+	exitOnSignal bool
+	signalErr    error
+	signals      []os.Signal
+	killed       bool
+	exited       chan struct{}
+}
+
+func newFakeProcess(exitOnSignal bool) *fakeProcess {
+	return &fakeProcess{exitOnSignal: exitOnSignal, exited: make(chan struct{})}
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.signalErr != nil {
+		return p.signalErr
+	}
+	p.signals = append(p.signals, sig)
+	if p.exitOnSignal {
+		close(p.exited)
+	}
+	return nil
+}
+
+func (p *fakeProcess) Kill() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.killed = true
+	close(p.exited)
+	return nil
+}
+
+func (p *fakeProcess) Wait() (*os.ProcessState, error) {
+	<-p.exited
+	return nil, nil
+}
+
+func TestTerminateGracefully(t *testing.T) {
+	// firedClock times out immediately, neverClock never does
+	firedClock := func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time)
+		close(ch)
+		return ch
+	}
+	neverClock := func(time.Duration) <-chan time.Time { return nil }
+
+	tests := []struct {
+		name        string
+		process     *fakeProcess
+		after       func(time.Duration) <-chan time.Time
+		wantSignals int
+		wantKilled  bool
+	}{
+		{name: "exits on SIGTERM", process: newFakeProcess(true), after: neverClock, wantSignals: 1},
+		{name: "killed after timeout", process: newFakeProcess(false), after: firedClock, wantSignals: 1, wantKilled: true},
+		{
+			name: "already exited",
+			process: func() *fakeProcess {
+				p := newFakeProcess(false)
+				p.signalErr = errors.New("os: process already finished")
+				return p
+			}(),
+			after: firedClock,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := terminateGracefully(tt.process, io.Discard, tt.after); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(tt.process.signals) != tt.wantSignals {
+				t.Errorf("signals = %v, want %d", tt.process.signals, tt.wantSignals)
+			}
+			for _, sig := range tt.process.signals {
+				if sig != syscall.SIGTERM {
+					t.Errorf("signal = %v, want SIGTERM", sig)
+				}
+			}
+			if tt.process.killed != tt.wantKilled {
+				t.Errorf("killed = %v, want %v", tt.process.killed, tt.wantKilled)
+			}
+		})
+	}
+}
+
+func TestConnectWatcherTimeout(t *testing.T) {
+	// Fire the connect timeout only, so the process exits on SIGTERM before it is killed
+	fired := make(chan time.Time)
+	close(fired)
+	after := func(d time.Duration) <-chan time.Time {
+		if d == gracefulTerminationTimeout {
+			return nil
+		}
+		return fired
+	}
+
+	process := newFakeProcess(true)
+	watcher := newConnectWatcher(5 * time.Second)
+	watcher.start(process, io.Discard, after)
+
+	// The watcher terminates the process, which the caller then sees exit
+	process.Wait()
+	err := watcher.stop(errors.New("signal: terminated"))
+
+	if !errors.Is(err, ErrConnectTimeout) {
+		t.Fatalf("stop() = %v, want ErrConnectTimeout", err)
+	}
+	if err.Error() != "failed to establish session within 5s" {
+		t.Errorf("error = %q", err.Error())
+	}
+	if len(process.signals) != 1 || process.signals[0] != syscall.SIGTERM {
+		t.Errorf("signals = %v, want a single SIGTERM", process.signals)
+	}
+}
+
+func TestSessionLimitsIdleTimeout(t *testing.T) {
+	process := newFakeProcess(true)
+	limits := newSessionLimits(ProcessOptions{IdleTimeout: 100 * time.Millisecond})
+	output := limits.wrap(io.Discard)
+	limits.start(process, io.Discard)
+
+	// Output keeps the session alive past the idle timeout
+	for i := 0; i < 10; i++ {
+		output.Write([]byte("tick\n"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	process.mu.Lock()
+	signaled := len(process.signals)
+	process.mu.Unlock()
+	if signaled != 0 {
+		t.Fatalf("idle timeout fired despite output")
+	}
+
+	// Without activity the limit terminates the process
+	process.Wait()
+	err := limits.stop(errors.New("signal: terminated"))
+	if !errors.Is(err, ErrSessionLimit) {
+		t.Fatalf("stop() = %v, want ErrSessionLimit", err)
+	}
+	if err.Error() != "session limit reached: idle for 100ms" {
+		t.Errorf("error = %q", err.Error())
+	}
+}
+
+func TestSessionLimitsMaxDuration(t *testing.T) {
+	process := newFakeProcess(true)
+	limits := newSessionLimits(ProcessOptions{MaxDuration: 50 * time.Millisecond})
+	limits.start(process, io.Discard)
+
+	process.Wait()
+	if err := limits.stop(nil); err == nil || err.Error() != "session limit reached: open for 50ms" {
+		t.Errorf("stop() = %v, want the maximum duration error", err)
+	}
+}
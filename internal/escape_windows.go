@@ -0,0 +1,23 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+)
+
+// forwardedSignals are the signals relayed to the child process of an interactive session.
+// Windows only delivers console interrupts (Ctrl-C / Ctrl-Break) to a process.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// forwardSignal stops the child process. Windows cannot deliver signals to another process,
+// so the child is killed; the SSM session itself is terminated by gossm afterwards.
+func forwardSignal(process stoppableProcess, _ os.Signal) error {
+	return process.Kill()
+}
+
+// requestTermination stops the child process. Windows has no SIGTERM equivalent for
+// console processes, so the child is killed; the SSM session is terminated by gossm afterwards.
+func requestTermination(process stoppableProcess) error {
+	return process.Kill()
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Session lifecycle events written with --events
+const (
+	EventSessionCreated    = "session_created"
+	EventSessionReady      = "session_ready"
+	EventSessionTerminated = "session_terminated"
+)
+
+// Event is a session lifecycle event, written as one JSON object per line
+type Event struct {
+	Event     string    `json:"event"`
+	SessionID string    `json:"session_id"`
+	Target    string    `json:"target,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	Timestamp time.Time `json:"ts"`
+}
+
+var (
+	// eventsMu serializes writes to the event stream and access to eventTargets
+	eventsMu = &sync.Mutex{}
+
+	// eventOutput receives the event stream, nil disables it
+	eventOutput io.Writer
+
+	// eventTargets maps the sessions created by this process to their targets,
+	// so later events can name the target
+	eventTargets = map[string]string{}
+)
+
+// SetEventOutput sets the writer that receives session lifecycle events, nil disables them
+func SetEventOutput(w io.Writer) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventOutput = w
+}
+
+// EventsEnabled reports whether session lifecycle events are written
+func EventsEnabled() bool {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return eventOutput != nil
+}
+
+// EmitEvent writes a session lifecycle event if events are enabled. An empty target is filled
+// in from the session_created event of the session.
+func EmitEvent(name, sessionID, target, region string) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventOutput == nil {
+		return
+	}
+
+	if target == "" {
+		target = eventTargets[sessionID]
+	}
+	switch name {
+	case EventSessionCreated:
+		eventTargets[sessionID] = target
+	case EventSessionTerminated:
+		delete(eventTargets, sessionID)
+	}
+
+	data, err := json.Marshal(Event{
+		Event:     name,
+		SessionID: sessionID,
+		Target:    target,
+		Region:    region,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+
+	// On stderr, keep events on lines of their own between log output and the spinner
+	if eventOutput == io.Writer(os.Stderr) {
+		stderrMu.Lock()
+		defer stderrMu.Unlock()
+		if spinning.Load() {
+			io.WriteString(eventOutput, clearLine)
+		}
+	}
+	eventOutput.Write(append(data, '\n'))
+}
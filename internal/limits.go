@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// sessionLimits terminates a process that has been idle for too long or has run for longer than
+// its maximum duration. Input and output written through its wrappers count as activity.
+type sessionLimits struct {
+	idleTimeout  time.Duration
+	maxDuration  time.Duration
+	lastActivity atomic.Int64 // Unix nanoseconds of the last input or output
+	done         chan struct{}
+	expired      atomic.Pointer[string]
+}
+
+// newSessionLimits returns limits for the process options; zero durations disable them
+func newSessionLimits(opts ProcessOptions) *sessionLimits {
+	l := &sessionLimits{
+		idleTimeout: opts.IdleTimeout,
+		maxDuration: opts.MaxDuration,
+		done:        make(chan struct{}),
+	}
+	l.touch()
+	return l
+}
+
+// enabled reports whether any limit applies
+func (l *sessionLimits) enabled() bool {
+	return l.idleTimeout > 0 || l.maxDuration > 0
+}
+
+// touch records activity on the session
+func (l *sessionLimits) touch() {
+	l.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the session has been without activity
+func (l *sessionLimits) idleFor() time.Duration {
+	return time.Since(time.Unix(0, l.lastActivity.Load()))
+}
+
+// wrap returns a writer that records output as activity
+func (l *sessionLimits) wrap(w io.Writer) io.Writer {
+	if l.idleTimeout <= 0 {
+		return w
+	}
+	return &activityWriter{Writer: w, limits: l}
+}
+
+// wrapInput returns a writer for the process input that records input as activity
+func (l *sessionLimits) wrapInput(w io.WriteCloser) io.WriteCloser {
+	if l.idleTimeout <= 0 {
+		return w
+	}
+	return teeWriteCloser{Writer: &activityWriter{Writer: w, limits: l}, Closer: w}
+}
+
+// start watches the process, terminating it gracefully once a limit is exceeded
+func (l *sessionLimits) start(process stoppableProcess, out io.Writer) {
+	if !l.enabled() {
+		return
+	}
+
+	go func() {
+		var deadline <-chan time.Time
+		if l.maxDuration > 0 {
+			timer := time.NewTimer(l.maxDuration)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		// The idle timer is re-armed for the remaining time whenever it finds recent activity
+		var idle <-chan time.Time
+		var idleTimer *time.Timer
+		if l.idleTimeout > 0 {
+			idleTimer = time.NewTimer(l.idleTimeout)
+			defer idleTimer.Stop()
+			idle = idleTimer.C
+		}
+
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-deadline:
+				l.expire(process, out, fmt.Sprintf("open for %s", l.maxDuration))
+				return
+			case <-idle:
+				if remaining := l.idleTimeout - l.idleFor(); remaining > 0 {
+					idleTimer.Reset(remaining)
+					continue
+				}
+				l.expire(process, out, fmt.Sprintf("idle for %s", l.idleTimeout))
+				return
+			}
+		}
+	}()
+}
+
+// expire records why the session ended and terminates the process
+func (l *sessionLimits) expire(process stoppableProcess, out io.Writer, reason string) {
+	l.expired.Store(&reason)
+	fmt.Fprintf(out, "\r\n%s\r\n", color.YellowString("Session %s, terminating...", reason))
+	terminateGracefully(process, out, time.After)
+}
+
+// stop ends the watch once the process has exited. It returns ErrSessionLimit if a limit
+// terminated the process, and err otherwise.
+func (l *sessionLimits) stop(err error) error {
+	close(l.done)
+	if reason := l.expired.Load(); reason != nil {
+		return fmt.Errorf("%w: %s", ErrSessionLimit, *reason)
+	}
+	return err
+}
+
+// activityWriter passes writes through and records them as session activity
+type activityWriter struct {
+	io.Writer
+	limits *sessionLimits
+}
+
+// Write records activity and writes p
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.limits.touch()
+	return w.Writer.Write(p)
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// LevelTrace is the most detailed log level, used for full proxy commands and API inputs
+const LevelTrace = slog.LevelDebug - 4
+
+var (
+	// logLevel holds the current minimum level for diagnostic output
+	logLevel = new(slog.LevelVar)
+
+	// stderrMu serializes writes to stderr by the logger and the spinner
+	stderrMu = &sync.Mutex{}
+
+	// logger writes diagnostic output to stderr, without color if stderr is redirected
+	logger = slog.New(&consoleHandler{
+		out:   os.Stderr,
+		plain: !term.IsTerminal(int(os.Stderr.Fd())),
+		level: logLevel,
+		mu:    stderrMu,
+	})
+)
+
+// SetLogLevel sets the minimum level for diagnostic output
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// DebugEnabled reports whether debug output is shown
+func DebugEnabled() bool {
+	return logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// LogLevelFromFlags converts the verbosity flags to a log level.
+// Each --verbose raises the detail by one step, --quiet shows only warnings and errors.
+func LogLevelFromFlags(verbosity int, quiet bool) slog.Level {
+	switch {
+	case quiet:
+		return slog.LevelWarn
+	case verbosity >= 2:
+		return LevelTrace
+	case verbosity == 1:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Tracef logs a formatted message at trace level
+func Tracef(format string, args ...interface{}) {
+	logf(LevelTrace, format, args...)
+}
+
+// Debugf logs a formatted message at debug level
+func Debugf(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// Infof logs a formatted message at info level
+func Infof(format string, args ...interface{}) {
+	logf(slog.LevelInfo, format, args...)
+}
+
+// Warnf logs a formatted message at warn level
+func Warnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+// toJSON renders a value as JSON for diagnostic output
+func toJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return string(data)
+}
+
+// logf formats and logs a message if the level is enabled, redacting any session secrets
+func logf(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, RedactSecrets(fmt.Sprintf(format, args...)))
+}
+
+// consoleHandler is a slog.Handler that prints human-readable, colored lines
+type consoleHandler struct {
+	out   io.Writer
+	plain bool // Never color output, in addition to color.NoColor
+	level slog.Leveler
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+// Enabled reports whether the handler handles records at the given level
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes the record as a single colored line
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var line strings.Builder
+	line.WriteString(r.Message)
+
+	// Append attributes as key=value pairs
+	appendAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&line, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Erase a running spinner so the line starts clean, it redraws below on its next frame
+	if spinning.Load() {
+		fmt.Fprint(h.out, clearLine)
+	}
+
+	if h.plain {
+		_, err := fmt.Fprintln(h.out, line.String())
+		return err
+	}
+	_, err := fmt.Fprintln(h.out, colorForLevel(r.Level)("%s", line.String()))
+	return err
+}
+
+// WithAttrs returns a handler that includes the given attributes
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &consoleHandler{
+		out:   h.out,
+		plain: h.plain,
+		level: h.level,
+		attrs: append(append([]slog.Attr{}, h.attrs...), attrs...),
+		mu:    h.mu,
+	}
+}
+
+// WithGroup returns the handler unchanged as groups are not used
+func (h *consoleHandler) WithGroup(_ string) slog.Handler {
+	return h
+}
+
+// colorForLevel returns the color function used to render a level
+func colorForLevel(level slog.Level) func(format string, a ...interface{}) string {
+	switch {
+	case level >= slog.LevelError:
+		return color.RedString
+	case level >= slog.LevelWarn:
+		return color.YellowString
+	case level >= slog.LevelInfo:
+		return color.GreenString
+	case level >= slog.LevelDebug:
+		return color.CyanString
+	default:
+		return color.HiBlackString
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	// pluginOutputTail is how much of the end of a process's stderr is kept to explain a failure
+	pluginOutputTail = 4096
+
+	// stderrWaitDelay is how long to wait for captured stderr after a process exits, as
+	// processes it started, such as a persistent ssh master, can hold the pipe open
+	stderrWaitDelay = 500 * time.Millisecond
+)
+
+// pluginErrors maps messages the SSM plugin prints when it fails to gossm errors with a hint
+var pluginErrors = []struct {
+	message string
+	err     error
+}{
+	{message: "TargetNotConnected", err: ErrAgentOffline},
+	{message: "is not connected", err: ErrAgentOffline},
+	{message: "Cannot perform start session", err: ErrPluginFailed},
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	mu   sync.Mutex
+	data []byte
+	max  int
+}
+
+// Write appends p, dropping the oldest bytes beyond the limit
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the kept bytes
+func (b *tailBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.data)
+}
+
+// pluginStderr captures a process's stderr so failures can be explained, passing it through to
+// stderr. It only captures when stderr is not a terminal, as the process then sees a pipe.
+type pluginStderr struct {
+	tail *tailBuffer
+}
+
+// newPluginStderr returns a capture for the stderr of the next process
+func newPluginStderr() *pluginStderr {
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		return &pluginStderr{}
+	}
+	return &pluginStderr{tail: &tailBuffer{max: pluginOutputTail}}
+}
+
+// attach sets the process's stderr, bounding how long Wait waits for a captured one
+func (s *pluginStderr) attach(cmd *exec.Cmd) {
+	if s == nil || s.tail == nil {
+		cmd.Stderr = os.Stderr
+		return
+	}
+	cmd.Stderr = io.MultiWriter(os.Stderr, s.tail)
+	cmd.WaitDelay = stderrWaitDelay
+}
+
+// explain replaces a process failure with a gossm error if the captured output shows a known
+// plugin error. The original error stays in the chain, so its exit status can still be used.
+func (s *pluginStderr) explain(err error) error {
+	if err == nil || s == nil || s.tail == nil {
+		return err
+	}
+	// The process itself exited, only output of processes it left behind was cut off
+	if errors.Is(err, exec.ErrWaitDelay) {
+		return nil
+	}
+	if pluginErr := matchPluginError(s.tail.Bytes()); pluginErr != nil {
+		return fmt.Errorf("%w: %w", pluginErr, err)
+	}
+	return err
+}
+
+// matchPluginError returns the error for the last known plugin error message in output, or nil
+func matchPluginError(output []byte) error {
+	lines := bytes.Split(bytes.TrimSpace(output), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := bytes.TrimSpace(lines[i])
+		for _, known := range pluginErrors {
+			if bytes.Contains(line, []byte(known.message)) {
+				return fmt.Errorf("%w: %s", known.err, line)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestMatchPluginError(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{
+			name:   "target not connected",
+			output: "\nAn error occurred (TargetNotConnected) when calling the StartSession operation: i-0123 is not connected.\n",
+			want:   ErrAgentOffline,
+		},
+		{
+			name:   "data channel failure",
+			output: "Starting session with SessionId: user-0123\nCannot perform start session: EOF\n",
+			want:   ErrPluginFailed,
+		},
+		{name: "unrelated output", output: "Connection closed by remote host\n", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchPluginError([]byte(tt.output))
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("matchPluginError() = %v, want nil", got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("matchPluginError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginStderrExplainKeepsProcessError(t *testing.T) {
+	s := &pluginStderr{tail: &tailBuffer{max: 40}}
+	s.tail.Write([]byte("lots of earlier output that is dropped\nCannot perform start session: EOF\n"))
+
+	processErr := &exec.ExitError{}
+	err := s.explain(processErr)
+
+	if !errors.Is(err, ErrPluginFailed) {
+		t.Errorf("explain() = %v, want ErrPluginFailed", err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("explain() = %v, want the process error kept in the chain", err)
+	}
+}
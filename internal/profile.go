@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// ListProfiles returns the sorted names of the AWS profiles defined in the shared config and
+// credentials files. Missing files are skipped.
+func ListProfiles(configFile, credentialsFile string) ([]string, error) {
+	var profiles []string
+
+	// The config file names profiles "[profile name]", except for "[default]"
+	sections, err := iniSections(configFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, section := range sections {
+		if name, ok := strings.CutPrefix(section, "profile "); ok {
+			profiles = append(profiles, strings.TrimSpace(name))
+		} else if section == "default" {
+			profiles = append(profiles, section)
+		}
+	}
+
+	// The credentials file names them "[name]"
+	sections, err = iniSections(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	profiles = append(profiles, sections...)
+
+	slices.Sort(profiles)
+	return slices.Compact(profiles), nil
+}
+
+// iniSections returns the section names of an INI file, or nothing if it does not exist
+func iniSections(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var sections []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := strings.CutPrefix(line, "["); ok {
+			if name, ok = strings.CutSuffix(name, "]"); ok && strings.TrimSpace(name) != "" {
+				sections = append(sections, strings.TrimSpace(name))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return sections, nil
+}
+
+// AskProfile prompts the user to select an AWS profile, pre-selecting defaultProfile if listed
+func AskProfile(profiles []string, defaultProfile string) (string, error) {
+	if err := requireTerminal("profile", "--profile"); err != nil {
+		return "", err
+	}
+
+	prompt := &survey.Select{
+		Message: "Choose an AWS profile:",
+		Options: profiles,
+	}
+	if slices.Contains(profiles, defaultProfile) {
+		prompt.Default = defaultProfile
+	}
+
+	var selectedProfile string
+	err := survey.AskOne(prompt, &selectedProfile,
+		survey.WithIcons(func(icons *survey.IconSet) {
+			icons.SelectFocus.Format = "green+hb"
+		}),
+		survey.WithPageSize(20))
+	if err != nil {
+		return "", fmt.Errorf("profile selection failed: %w", err)
+	}
+
+	return selectedProfile, nil
+}
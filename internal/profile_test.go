@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+func TestListProfiles(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+
+	config := `[default]
+region = eu-west-1
+
+[profile production]
+role_arn = arn:aws:iam::123456789012:role/admin
+source_profile = default
+
+[ profile staging ]
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+`
+	credentials := `[default]
+aws_access_key_id = AKIAEXAMPLE
+[legacy]
+aws_access_key_id = AKIAEXAMPLE2
+`
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := ListProfiles(configFile, credentialsFile)
+	if err != nil {
+		t.Fatalf("ListProfiles() error = %v", err)
+	}
+	want := []string{"default", "legacy", "production", "staging"}
+	if !slices.Equal(profiles, want) {
+		t.Errorf("ListProfiles() = %q, want %q", profiles, want)
+	}
+
+	// Missing files are not an error
+	profiles, err = ListProfiles(filepath.Join(dir, "missing"), filepath.Join(dir, "missing"))
+	if err != nil || len(profiles) != 0 {
+		t.Errorf("ListProfiles() of missing files = %q, %v, want no profiles", profiles, err)
+	}
+}
+
+func TestAskProfileWithoutTerminal(t *testing.T) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("stdin is a terminal")
+	}
+
+	_, err := AskProfile([]string{"default", "production"}, "default")
+	if !errors.Is(err, ErrNoTerminal) {
+		t.Fatalf("AskProfile() error = %v, want %v", err, ErrNoTerminal)
+	}
+	want := "no profile specified and no terminal available for selection; pass --profile"
+	if err.Error() != want {
+		t.Errorf("AskProfile() error = %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a new pseudo-terminal, returning the side read by gossm and the terminal side
+// given to the process
+func openPTY() (*os.File, *os.File, error) {
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a pseudo-terminal: %w", err)
+	}
+
+	if err := unix.IoctlSetInt(int(pty.Fd()), unix.TIOCPTYGRANT, 0); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to grant access to the pseudo-terminal: %w", err)
+	}
+	if err := unix.IoctlSetInt(int(pty.Fd()), unix.TIOCPTYUNLK, 0); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to unlock the pseudo-terminal: %w", err)
+	}
+	name := make([]byte, 128)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, pty.Fd(), unix.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))); errno != 0 {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to get the pseudo-terminal name: %w", errno)
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	tty, err := os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to open the pseudo-terminal: %w", err)
+	}
+	return pty, tty, nil
+}
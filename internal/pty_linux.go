@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a new pseudo-terminal, returning the side read by gossm and the terminal side
+// given to the process
+func openPTY() (*os.File, *os.File, error) {
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a pseudo-terminal: %w", err)
+	}
+
+	if err := unix.IoctlSetPointerInt(int(pty.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to unlock the pseudo-terminal: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(int(pty.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to get the pseudo-terminal number: %w", err)
+	}
+
+	tty, err := os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(n), 10), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("failed to open the pseudo-terminal: %w", err)
+	}
+	return pty, tty, nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// resizeSignals are the signals received when the terminal is resized, none are relayed here
+var resizeSignals []os.Signal
+
+// openPTY reports that pseudo-terminals aren't supported on this platform
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+// setPTYSize is never called as no pseudo-terminal is opened
+func setPTYSize(_ *os.File, _, _ int) error {
+	return errors.ErrUnsupported
+}
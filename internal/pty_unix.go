@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package internal
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// resizeSignals are the signals received when the terminal is resized
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
+
+// setPTYSize sets the window size of a pseudo-terminal
+func setPTYSize(pty *os.File, width, height int) error {
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, &unix.Winsize{
+		Row: uint16(height),
+		Col: uint16(width),
+	})
+}
@@ -0,0 +1,65 @@
+//go:build linux || darwin
+
+package internal
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+func TestSetPTYSize(t *testing.T) {
+	pty, tty, err := openPTY()
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+	defer pty.Close()
+	defer tty.Close()
+
+	if err := setPTYSize(pty, 132, 43); err != nil {
+		t.Fatalf("setPTYSize() error = %v", err)
+	}
+	width, height, err := term.GetSize(int(tty.Fd()))
+	if err != nil {
+		t.Fatalf("GetSize() error = %v", err)
+	}
+	if width != 132 || height != 43 {
+		t.Errorf("size = %dx%d, want 132x43", width, height)
+	}
+}
+
+func TestTerminalOutputRelaysProcessOutput(t *testing.T) {
+	pty, tty, err := openPTY()
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+	if _, err := term.MakeRaw(int(tty.Fd())); err != nil {
+		t.Fatalf("MakeRaw() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	output := &terminalOutput{
+		pty:  pty,
+		tty:  tty,
+		out:  &out,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	cmd := exec.Command("printf", "line one\nline two\n")
+	cmd.Stdout = tty
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	output.start()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	output.close()
+
+	// Raw mode leaves newlines untranslated
+	if got, want := out.String(), "line one\nline two\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
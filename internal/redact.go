@@ -0,0 +1,19 @@
+package internal
+
+import (
+	"regexp"
+)
+
+// redactedValue replaces sensitive values in diagnostic output
+const redactedValue = "REDACTED"
+
+// sensitiveFieldPattern matches JSON fields of session and credential output that must never be logged.
+// The quotes may be backslash-escaped, as they are when the JSON is double-quoted for a shell.
+var sensitiveFieldPattern = regexp.MustCompile(
+	`(\\?)"(TokenValue|StreamUrl|SessionId|SecretAccessKey|SessionToken)\\?"(\s*:\s*)\\?"(?:[^"\\]|\\[^"])*\\?"`)
+
+// RedactSecrets masks session tokens, stream URLs, session IDs and credentials in s.
+// Use it on anything that may contain a marshaled StartSessionOutput before printing it.
+func RedactSecrets(s string) string {
+	return sensitiveFieldPattern.ReplaceAllString(s, `${1}"${2}${1}"${3}${1}"`+redactedValue+`${1}"`)
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "plain",
+			in:   `{"SessionId":"gossm-0123","StreamUrl":"wss://ssmmessages.eu-west-1.amazonaws.com/v1/data-channel/gossm-0123?role=publish_subscribe&cell-number=AAEA","TokenValue":"token"}`,
+			want: `{"SessionId":"REDACTED","StreamUrl":"REDACTED","TokenValue":"REDACTED"}`,
+		},
+		{
+			name: "spaced",
+			in:   `{"SecretAccessKey": "secret", "SessionToken" : "token", "Region": "eu-west-1"}`,
+			want: `{"SecretAccessKey": "REDACTED", "SessionToken" : "REDACTED", "Region": "eu-west-1"}`,
+		},
+		{
+			name: "single-quoted",
+			in:   `ProxyCommand=session-manager-plugin '{"SessionId":"gossm-0123","TokenValue":"token"}' eu-west-1 StartSession`,
+			want: `ProxyCommand=session-manager-plugin '{"SessionId":"REDACTED","TokenValue":"REDACTED"}' eu-west-1 StartSession`,
+		},
+		{
+			name: "escaped",
+			in:   `ProxyCommand=session-manager-plugin "{\"SessionId\":\"gossm-0123\",\"StreamUrl\":\"wss://host/?role=publish_subscribe\\u0026cell-number=AAEA\",\"TokenValue\":\"token\"}" eu-west-1`,
+			want: `ProxyCommand=session-manager-plugin "{\"SessionId\":\"REDACTED\",\"StreamUrl\":\"REDACTED\",\"TokenValue\":\"REDACTED\"}" eu-west-1`,
+		},
+		{
+			name: "other fields",
+			in:   `{"Target":"i-0123456789abcdef0","DocumentName":"AWS-StartSSHSession"}`,
+			want: `{"Target":"i-0123456789abcdef0","DocumentName":"AWS-StartSSHSession"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		got := RedactSecrets(tt.in)
+		if got != tt.want {
+			t.Errorf("%s: RedactSecrets() = %s, want %s", tt.name, got, tt.want)
+		}
+		if strings.Contains(got, "token") || strings.Contains(got, "gossm-0123") {
+			t.Errorf("%s: RedactSecrets() leaks a secret: %s", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// maxRegionSuggestionDistance is the largest edit distance for which a region is suggested
+const maxRegionSuggestionDistance = 3
+
+// ValidateRegions checks that every region is a known AWS region, suggesting the closest match
+// for a typo. Regions missing from the built-in list are looked up with the API, falling back to
+// the built-in list if that fails.
+func ValidateRegions(ctx context.Context, cfg aws.Config, regions []string) error {
+	known := defaultAwsRegions
+	var unknown []string
+	for _, region := range regions {
+		if !slices.Contains(known, region) {
+			unknown = append(unknown, region)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	// A mistyped region has no endpoint, so don't retry the lookup
+	noRetry := func(o *ec2.Options) { o.RetryMaxAttempts = 1 }
+	if available, err := getAvailableRegions(ctx, cfg, noRetry); err == nil {
+		known = available
+	} else {
+		Debugf("Failed to list regions, validating against the built-in list: %v", err)
+	}
+
+	for _, region := range unknown {
+		if slices.Contains(known, region) {
+			continue
+		}
+		if suggestion := closestRegion(region, known); suggestion != "" {
+			return fmt.Errorf("unknown region '%s', did you mean %s?", region, suggestion)
+		}
+		return fmt.Errorf("unknown region '%s'", region)
+	}
+	return nil
+}
+
+// closestRegion returns the region with the smallest edit distance to region, or nothing if
+// none is close enough to be a likely typo
+func closestRegion(region string, regions []string) string {
+	closest, best := "", maxRegionSuggestionDistance+1
+	for _, candidate := range regions {
+		if distance := editDistance(region, candidate); distance < best {
+			closest, best = candidate, distance
+		}
+	}
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
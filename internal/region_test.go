@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestClosestRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		want   string
+	}{
+		{region: "us-east1", want: "us-east-1"},
+		{region: "eu-wset-1", want: "eu-west-1"},
+		{region: "ap-southeast-11", want: "ap-southeast-1"},
+		{region: "mars-north-1", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := closestRegion(tt.region, defaultAwsRegions); got != tt.want {
+			t.Errorf("closestRegion(%q) = %q, want %q", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRegionsKnown(t *testing.T) {
+	// Regions in the built-in list are accepted without an API call
+	if err := ValidateRegions(context.Background(), aws.Config{}, []string{"eu-west-1", "us-gov-west-1"}); err != nil {
+		t.Errorf("ValidateRegions() error = %v", err)
+	}
+}
+
+func TestFallbackRegions(t *testing.T) {
+	tests := []struct {
+		region  string
+		want    string
+		notWant string
+	}{
+		{region: "eu-west-1", want: "ap-southeast-6", notWant: "cn-north-1"},
+		{region: "", want: "us-east-1", notWant: "us-gov-west-1"},
+		{region: "cn-north-1", want: "cn-northwest-1", notWant: "eu-west-1"},
+		{region: "us-gov-east-1", want: "us-gov-west-1", notWant: "us-east-1"},
+	}
+
+	for _, tt := range tests {
+		regions := fallbackRegions(tt.region)
+		if !slices.Contains(regions, tt.want) || slices.Contains(regions, tt.notWant) {
+			t.Errorf("fallbackRegions(%q) = %v, want it to include %s and not %s", tt.region, regions, tt.want, tt.notWant)
+		}
+	}
+}
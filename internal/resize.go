@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// terminalOutput relays the output of an interactive process through a pseudo-terminal sized
+// like ours. The SSM plugin reads the window size from its stdout, so without it a process whose
+// output is captured (for a transcript, the quiet filter or the session timers) only sees a pipe
+// and never learns about resizes.
+type terminalOutput struct {
+	pty  *os.File // Side read by gossm
+	tty  *os.File // Side written by the process
+	out  io.Writer
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	started bool
+}
+
+// attachTerminalOutput sets out as the process's stdout. If out isn't the terminal itself, the
+// process writes to a pseudo-terminal copied to out instead; nil is returned if none is used.
+func attachTerminalOutput(cmd *exec.Cmd, out io.Writer) *terminalOutput {
+	cmd.Stdout = out
+	if out == io.Writer(os.Stdout) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil
+	}
+
+	pty, tty, err := openPTY()
+	if err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			Debugf("Terminal resizes won't reach the session: %v", err)
+		}
+		return nil
+	}
+	// Pass the output through unchanged, it already comes from a remote terminal
+	if _, err := term.MakeRaw(int(tty.Fd())); err != nil {
+		Debugf("Terminal resizes won't reach the session: %v", err)
+		pty.Close()
+		tty.Close()
+		return nil
+	}
+
+	t := &terminalOutput{
+		pty:  pty,
+		tty:  tty,
+		out:  out,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	t.resize()
+	cmd.Stdout = tty
+	return t
+}
+
+// start relays the output and terminal resizes once the process has started
+func (t *terminalOutput) start() {
+	if t == nil {
+		return
+	}
+	// The process holds its own copy, the output ends once it exits
+	t.tty.Close()
+	t.started = true
+
+	resizes := make(chan os.Signal, 1)
+	signal.Notify(resizes, resizeSignals...)
+	go func() {
+		defer signal.Stop(resizes)
+		for {
+			select {
+			case <-resizes:
+				t.resize()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(t.done)
+		// Reading fails rather than returning EOF on some systems once the process exits
+		io.Copy(t.out, t.pty)
+	}()
+}
+
+// resize sets the pseudo-terminal to the size of our terminal
+func (t *terminalOutput) resize() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		Debugf("Failed to read the terminal size: %v", err)
+		return
+	}
+	if err := setPTYSize(t.pty, width, height); err != nil {
+		Debugf("Failed to resize the session terminal: %v", err)
+	}
+}
+
+// close waits for the remaining output of the exited process, then releases the pseudo-terminal.
+// It is safe to call more than once, and before start if the process failed to start.
+func (t *terminalOutput) close() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		close(t.stop)
+		t.tty.Close()
+		if t.started {
+			<-t.done
+		}
+		t.pty.Close()
+	})
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	// spinnerInterval is how often the spinner advances to its next frame
+	spinnerInterval = 100 * time.Millisecond
+
+	// clearLine returns the cursor to the start of the line and erases it
+	clearLine = "\r\033[K"
+)
+
+var (
+	// spinnerFrames are the frames the spinner cycles through
+	spinnerFrames = []string{"|", "/", "-", "\\"}
+
+	// spinning reports whether a spinner is shown on stderr
+	spinning atomic.Bool
+)
+
+// StartSpinner shows an animated spinner with the message on stderr until the returned function
+// is called, which erases it. Nothing is shown when stderr is not a terminal, in quiet mode, or
+// when debug output would interleave with it.
+func StartSpinner(message string) func() {
+	if !term.IsTerminal(int(os.Stderr.Fd())) ||
+		!logger.Enabled(context.Background(), slog.LevelInfo) || DebugEnabled() {
+		return func() {}
+	}
+	spinning.Store(true)
+	stop := startSpinner(os.Stderr, stderrMu, message, spinnerInterval)
+	return func() {
+		stop()
+		spinning.Store(false)
+	}
+}
+
+// startSpinner animates the spinner on out until the returned function is called,
+// holding mu while writing
+func startSpinner(out io.Writer, mu *sync.Mutex, message string, interval time.Duration) func() {
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for frame := 0; ; frame++ {
+			mu.Lock()
+			fmt.Fprintf(out, "%s%s %s", clearLine, spinnerFrames[frame%len(spinnerFrames)], message)
+			mu.Unlock()
+
+			select {
+			case <-done:
+				mu.Lock()
+				fmt.Fprint(out, clearLine)
+				mu.Unlock()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshUserPattern matches the POSIX user names that may be safely passed to the install script
+var sshUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`)
+
+// installKeyScript appends a public key to a user's authorized_keys and removes it again after a delay
+const installKeyScript = `set -e
+home=$(getent passwd %[1]s | cut -d: -f6)
+[ -n "$home" ] || { echo "user %[1]s not found" >&2; exit 1; }
+install -d -m 700 -o %[1]s "$home/.ssh"
+echo '%[2]s' >> "$home/.ssh/authorized_keys"
+chown %[1]s "$home/.ssh/authorized_keys"
+chmod 600 "$home/.ssh/authorized_keys"
+nohup sh -c "sleep %[4]d; sed -i '/ %[3]s$/d' \"$home/.ssh/authorized_keys\"" >/dev/null 2>&1 &
+`
+
+// EphemeralKey is a short-lived SSH key pair generated for a single connection
+type EphemeralKey struct {
+	PrivateKeyPEM []byte // OpenSSH formatted private key
+	AuthorizedKey string // Public key in authorized_keys format, including the comment
+	Comment       string // Unique comment identifying the key on the instance
+}
+
+// GenerateEphemeralKey creates a new in-memory ed25519 key pair
+func GenerateEphemeralKey() (*EphemeralKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	comment := fmt.Sprintf("gossm-ephemeral-%d", time.Now().UnixNano())
+
+	// Encode the private key in OpenSSH format for the ssh client
+	block, err := ssh.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey)))
+
+	return &EphemeralKey{
+		PrivateKeyPEM: pem.EncodeToMemory(block),
+		AuthorizedKey: fmt.Sprintf("%s %s", authorizedKey, comment),
+		Comment:       comment,
+	}, nil
+}
+
+// InstallEphemeralKey authorizes the key for user on the instance through a one-shot Run Command.
+// The key is removed again after ttl, which only needs to cover the SSH handshake.
+func InstallEphemeralKey(ctx context.Context, cfg aws.Config, instanceID, user string, key *EphemeralKey, ttl time.Duration) error {
+	if !sshUserPattern.MatchString(user) {
+		return fmt.Errorf("invalid SSH user '%s'", user)
+	}
+
+	script := fmt.Sprintf(installKeyScript, user, key.AuthorizedKey, key.Comment, int(ttl.Seconds()))
+
+	commands, err := SendCommand(ctx, cfg, []*Target{{Name: instanceID}}, script, RunCommandOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to send key install command: %w", err)
+	}
+
+	// Wait for the key to be in place before connecting
+	client := ssm.NewFromConfig(cfg)
+	result, err := waitForCommandInvocation(ctx, client, &ssm.GetCommandInvocationInput{
+		CommandId:  commands[0].CommandId,
+		InstanceId: aws.String(instanceID),
+	})
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(string(result.Status), "success") {
+		return fmt.Errorf("failed to install ephemeral key: %s", strings.TrimSpace(aws.ToString(result.StandardErrorContent)))
+	}
+
+	return nil
+}
@@ -1,18 +1,28 @@
 package internal
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,39 +31,149 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/fatih/color"
+	"golang.org/x/term"
 )
 
 const (
+	// managedInstancePrefix starts the IDs of on-premises instances registered with SSM
+	managedInstancePrefix = "mi-"
+
 	// maxOutputResults is the maximum number of results per API call
 	maxOutputResults = 50
 
 	// shellDocumentName is the SSM document for running shell commands
 	shellDocumentName = "AWS-RunShellScript"
 
+	// shellCommandParameter is the parameter of the shell document that receives the commands
+	shellCommandParameter = "commands"
+
 	// commandTimeout is the timeout for SSM commands in seconds
 	commandTimeout = 60
 
+	// maxCommandInstances is the most instance IDs SSM accepts in a single SendCommand call
+	maxCommandInstances = 50
+
+	// maxCommandTargets is the most targets SSM accepts in a single SendCommand call
+	maxCommandTargets = 5
+
 	// pollInterval is the interval for checking command status
 	pollInterval = 1 * time.Second
+
+	// commandOutputLimit is the most characters of output GetCommandInvocation returns
+	commandOutputLimit = 24000
+
+	// truncatedOutputMarker and truncatedErrorMarker end output the SSM agent truncated
+	truncatedOutputMarker = "---Output truncated---"
+	truncatedErrorMarker  = "---Error truncated----"
+
+	// defaultSSHUser is the SSH user used when no better default is known
+	defaultSSHUser = "root"
+
+	// transcriptWaitDelay is how long to wait for mirrored input after a process exits
+	transcriptWaitDelay = 500 * time.Millisecond
 )
 
+// platformSSHUsers maps operating system names reported by the SSM agent to the login user
+// of their usual AMIs, checked in order
+var platformSSHUsers = []struct {
+	name string
+	user string
+}{
+	{name: "ubuntu", user: "ubuntu"},
+	{name: "debian", user: "admin"},
+	{name: "amazon linux", user: "ec2-user"},
+	{name: "red hat", user: "ec2-user"},
+	{name: "suse", user: "ec2-user"},
+	{name: "sles", user: "ec2-user"},
+	{name: "centos", user: "centos"},
+	{name: "fedora", user: "fedora"},
+	{name: "rocky", user: "rocky"},
+	{name: "almalinux", user: "ec2-user"},
+}
+
+// mfaCodePattern matches a six digit MFA token code
+var mfaCodePattern = regexp.MustCompile(`^[0-9]{6}$`)
+
 // AWS region list - kept for fallback if API fails
 var defaultAwsRegions = []string{
 	"af-south-1",
-	"ap-east-1", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3", "ap-south-1", "ap-southeast-2", "ap-southeast-3",
-	"ca-central-1",
+	"ap-east-1", "ap-east-2", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3", "ap-south-1", "ap-south-2",
+	"ap-southeast-1", "ap-southeast-2", "ap-southeast-3", "ap-southeast-4", "ap-southeast-5", "ap-southeast-6", "ap-southeast-7",
+	"ca-central-1", "ca-west-1",
 	"cn-north-1", "cn-northwest-1",
-	"eu-central-1", "eu-north-1", "eu-south-1", "eu-west-1", "eu-west-2", "eu-west-3",
-	"me-south-1",
+	"eu-central-1", "eu-central-2", "eu-north-1", "eu-south-1", "eu-south-2", "eu-west-1", "eu-west-2", "eu-west-3",
+	"il-central-1",
+	"me-central-1", "me-south-1",
+	"mx-central-1",
 	"sa-east-1",
-	"us-east-1", "us-east-2", "us-gov-east-1", "us-gov-west-2", "us-west-1", "us-west-2",
+	"us-east-1", "us-east-2", "us-gov-east-1", "us-gov-west-1", "us-west-1", "us-west-2",
 }
 
 // Target represents an AWS EC2 instance target
 type Target struct {
-	Name          string // AWS Instance ID
-	PublicDomain  string // Public DNS Name
-	PrivateDomain string // Private DNS Name
+	Name             string            `json:"instanceId"`                 // AWS Instance ID
+	InstanceName     string            `json:"name"`                       // Value of the Name tag
+	PublicDomain     string            `json:"publicDomain"`               // Public DNS Name
+	PrivateDomain    string            `json:"privateDomain"`              // Private DNS Name
+	Region           string            `json:"region"`                     // AWS Region the instance runs in
+	AvailabilityZone string            `json:"availabilityZone,omitempty"` // Availability zone of an EC2 instance
+	SubnetID         string            `json:"subnetId,omitempty"`         // Subnet of an EC2 instance
+	PlatformType     string            `json:"platformType"`               // Operating system family, such as linux or windows
+	PlatformName     string            `json:"platformName"`               // Operating system reported by the SSM agent, such as Ubuntu
+	InstanceType     string            `json:"instanceType"`               // EC2 instance type
+	LaunchTime       time.Time         `json:"launchTime"`                 // Time the instance was launched
+	Tags             map[string]string `json:"tags,omitempty"`             // Tags of an EC2 instance
+}
+
+// TagFilter restricts discovery to instances with a matching tag
+type TagFilter struct {
+	Key   string // Tag key
+	Value string // Tag value
+}
+
+// FindOptions narrows instance discovery
+type FindOptions struct {
+	TagFilters        []TagFilter    // Only include instances whose tags match every filter
+	MaxInstances      int            // Stop discovery after this many instances (0 for no limit)
+	AvailabilityZones []string       // Only include EC2 instances in one of these availability zones
+	SubnetIDs         []string       // Only include EC2 instances in one of these subnets
+	TargetPattern     *regexp.Regexp // Only include instances whose Name tag or ID matches
+	QuietLimit        bool           // Don't warn when discovery stops at MaxInstances
+}
+
+// Description returns the filters in a human readable form, such as "filter Env=prod, az eu-west-1a",
+// or "" if discovery is not filtered. The instance limit is not a filter.
+func (o FindOptions) Description() string {
+	var parts []string
+	for _, tagFilter := range o.TagFilters {
+		parts = append(parts, "filter "+tagFilter.Key+"="+tagFilter.Value)
+	}
+	if len(o.AvailabilityZones) > 0 {
+		parts = append(parts, "az "+strings.Join(o.AvailabilityZones, ","))
+	}
+	if len(o.SubnetIDs) > 0 {
+		parts = append(parts, "subnet "+strings.Join(o.SubnetIDs, ","))
+	}
+	if o.TargetPattern != nil {
+		parts = append(parts, "target-regex "+o.TargetPattern.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// matchesTarget reports whether the target passes the filters applied after discovery: its
+// Name tag or ID must match the pattern and it must run in one of the availability zones and
+// subnets, if any are set. On-premises instances have neither, so they never match those.
+func (o FindOptions) matchesTarget(t *Target) bool {
+	if o.TargetPattern != nil && !o.TargetPattern.MatchString(t.InstanceName) && !o.TargetPattern.MatchString(t.Name) {
+		return false
+	}
+	if len(o.AvailabilityZones) > 0 && !slices.Contains(o.AvailabilityZones, t.AvailabilityZone) {
+		return false
+	}
+	if len(o.SubnetIDs) > 0 && !slices.Contains(o.SubnetIDs, t.SubnetID) {
+		return false
+	}
+	return true
 }
 
 // User represents an SSH user
@@ -72,40 +192,130 @@ type Port struct {
 	Local  string // Local port
 }
 
-// AskUser prompts the user to select an SSH username
-func AskUser() (*User, error) {
+// requireTerminal returns an error naming the flag that avoids the prompt if stdin is not a
+// terminal, as prompts would block or fail in automation such as cron or CI
+func requireTerminal(what, flag string) error {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return fmt.Errorf("no %s specified and %w; pass %s", what, ErrNoTerminal, flag)
+}
+
+// AskUser prompts the user to select an SSH username, with defaultUser used for a blank answer.
+// Without a terminal to ask on, defaultUser is used.
+func AskUser(defaultUser string) (*User, error) {
+	if defaultUser == "" {
+		defaultUser = defaultSSHUser
+	}
+	if requireTerminal("user", "--user") != nil {
+		return &User{Name: defaultUser}, nil
+	}
 	prompt := &survey.Input{
-		Message: "Type your connect ssh user (default: root):",
+		Message: fmt.Sprintf("Type your connect ssh user (default: %s):", defaultUser),
 	}
 	var user string
 	survey.AskOne(prompt, &user)
 	user = strings.TrimSpace(user)
 	if user == "" {
-		user = "root"
+		user = defaultUser
 	}
 	return &User{Name: user}, nil
 }
 
-// AskRegion prompts the user to select an AWS region
-func AskRegion(ctx context.Context, cfg aws.Config) (*Region, error) {
-	// Get regions from AWS API
-	regions, err := getAvailableRegions(ctx, cfg)
+// DefaultSSHUser returns the default login user of the operating system reported by the SSM
+// agent, as set up by its usual AMIs. Unknown systems fall back to root.
+func DefaultSSHUser(platformName string) string {
+	name := strings.ToLower(platformName)
+	for _, platform := range platformSSHUsers {
+		if strings.Contains(name, platform.name) {
+			return platform.user
+		}
+	}
+	return defaultSSHUser
+}
+
+// AskMFACode prompts the user for an MFA token code using masked input
+func AskMFACode() (string, error) {
+	if err := requireTerminal("MFA code", "the code as an argument"); err != nil {
+		return "", err
+	}
+
+	prompt := &survey.Password{
+		Message: "Type your MFA token code:",
+	}
+
+	// Validate that the code is exactly six digits
+	validator := func(ans interface{}) error {
+		code, _ := ans.(string)
+		return ValidateMFACode(strings.TrimSpace(code))
+	}
+
+	// Prompt on stderr so the prompt shows when stdout is captured, as with mfa --export
+	var code string
+	if err := survey.AskOne(prompt, &code, survey.WithValidator(validator),
+		survey.WithStdio(os.Stdin, os.Stderr, os.Stderr)); err != nil {
+		return "", fmt.Errorf("MFA code input failed: %w", err)
+	}
+
+	return strings.TrimSpace(code), nil
+}
+
+// AskMFADevice prompts the user to select one of several MFA devices
+func AskMFADevice(serials []string) (string, error) {
+	if err := requireTerminal("MFA device", "--device"); err != nil {
+		return "", err
+	}
+
+	prompt := &survey.Select{
+		Message: "Choose an MFA device:",
+		Options: serials,
+	}
+
+	var selectedDevice string
+	err := survey.AskOne(prompt, &selectedDevice,
+		survey.WithIcons(func(icons *survey.IconSet) {
+			icons.SelectFocus.Format = "green+hb"
+		}),
+		survey.WithStdio(os.Stdin, os.Stderr, os.Stderr))
+
 	if err != nil {
-		// Fall back to default regions if API call fails
-		regions = make([]string, len(defaultAwsRegions))
-		copy(regions, defaultAwsRegions)
+		return "", fmt.Errorf("MFA device selection failed: %w", err)
 	}
 
-	sort.Strings(regions)
+	return selectedDevice, nil
+}
+
+// ValidateMFACode checks that an MFA token code is exactly six numeric digits
+func ValidateMFACode(code string) error {
+	if !mfaCodePattern.MatchString(code) {
+		return errors.New("MFA code must be 6 digits")
+	}
+	return nil
+}
+
+// AskRegion prompts the user to select an AWS region.
+// If defaultRegion is one of the options it is pre-selected.
+func AskRegion(ctx context.Context, cfg aws.Config, defaultRegion string) (*Region, error) {
+	if err := requireTerminal("region", "--region"); err != nil {
+		return nil, err
+	}
+
+	regions := ListEnabledRegions(ctx, cfg)
 
 	// Prompt user to select a region
 	prompt := &survey.Select{
 		Message: "Choose a region in AWS:",
 		Options: regions,
 	}
+	for _, region := range regions {
+		if region == defaultRegion {
+			prompt.Default = defaultRegion
+			break
+		}
+	}
 
 	var selectedRegion string
-	err = survey.AskOne(prompt, &selectedRegion,
+	err := survey.AskOne(prompt, &selectedRegion,
 		survey.WithIcons(func(icons *survey.IconSet) {
 			icons.SelectFocus.Format = "green+hb"
 		}),
@@ -118,10 +328,95 @@ func AskRegion(ctx context.Context, cfg aws.Config) (*Region, error) {
 	return &Region{Name: selectedRegion}, nil
 }
 
-// getAvailableRegions fetches available AWS regions
-func getAvailableRegions(ctx context.Context, cfg aws.Config) ([]string, error) {
+// AskRegions prompts the user to select one or more AWS regions to search.
+// The defaultRegions that are options are pre-selected.
+func AskRegions(ctx context.Context, cfg aws.Config, defaultRegions []string) ([]*Region, error) {
+	if err := requireTerminal("regions", "a comma-separated list to --region"); err != nil {
+		return nil, err
+	}
+
+	regions := ListEnabledRegions(ctx, cfg)
+
+	prompt := &survey.MultiSelect{
+		Message: "Choose regions in AWS:",
+		Options: regions,
+	}
+	var defaults []string
+	for _, region := range defaultRegions {
+		if slices.Contains(regions, region) {
+			defaults = append(defaults, region)
+		}
+	}
+	if len(defaults) > 0 {
+		prompt.Default = defaults
+	}
+
+	var selectedRegions []string
+	err := survey.AskOne(prompt, &selectedRegions, survey.WithPageSize(20), survey.WithValidator(survey.MinItems(1)))
+	if err != nil {
+		return nil, fmt.Errorf("region selection failed: %w", err)
+	}
+
+	selected := make([]*Region, 0, len(selectedRegions))
+	for _, region := range selectedRegions {
+		selected = append(selected, &Region{Name: region})
+	}
+	return selected, nil
+}
+
+// ListEnabledRegions returns the regions enabled for the account, falling back to the built-in
+// list of the partition of the configured region if they can't be fetched, e.g. without
+// ec2:DescribeRegions permission
+func ListEnabledRegions(ctx context.Context, cfg aws.Config) []string {
 	client := ec2.NewFromConfig(cfg)
 
+	// Without AllRegions, only regions enabled for the account are returned
+	output, err := client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		Debugf("Failed to list the regions enabled for the account, showing the built-in list instead, which may include disabled regions: %v", err)
+		return fallbackRegions(cfg.Region)
+	}
+
+	regions := make([]string, 0, len(output.Regions))
+	for _, region := range output.Regions {
+		if region.RegionName != nil {
+			regions = append(regions, *region.RegionName)
+		}
+	}
+	sort.Strings(regions)
+
+	return regions
+}
+
+// fallbackRegions returns the built-in regions of the partition region belongs to, as regions of
+// other partitions can't be reached with the same credentials
+func fallbackRegions(region string) []string {
+	partition := regionPartition(region)
+
+	var regions []string
+	for _, candidate := range defaultAwsRegions {
+		if regionPartition(candidate) == partition {
+			regions = append(regions, candidate)
+		}
+	}
+	return regions
+}
+
+// regionPartition returns the prefix of the AWS partition a region belongs to: "cn-" for China,
+// "us-gov-" for GovCloud, or "" for the standard partition
+func regionPartition(region string) string {
+	for _, prefix := range []string{"cn-", "us-gov-"} {
+		if strings.HasPrefix(region, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// getAvailableRegions fetches available AWS regions
+func getAvailableRegions(ctx context.Context, cfg aws.Config, optFns ...func(*ec2.Options)) ([]string, error) {
+	client := ec2.NewFromConfig(cfg, optFns...)
+
 	output, err := client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{
 		AllRegions: aws.Bool(true),
 	})
@@ -140,13 +435,7 @@ func getAvailableRegions(ctx context.Context, cfg aws.Config) ([]string, error)
 }
 
 // AskTarget prompts the user to select a single EC2 instance
-func AskTarget(ctx context.Context, cfg aws.Config) (*Target, error) {
-	// Get available instances
-	instances, err := FindInstances(ctx, cfg)
-	if err != nil {
-		return nil, err
-	}
-
+func AskTarget(instances map[string]*Target) (*Target, error) {
 	// Create a list of instance options
 	options := make([]string, 0, len(instances))
 	for k := range instances {
@@ -155,7 +444,11 @@ func AskTarget(ctx context.Context, cfg aws.Config) (*Target, error) {
 	sort.Strings(options)
 
 	if len(options) == 0 {
-		return nil, errors.New("no EC2 instances found")
+		return nil, ErrNoInstances
+	}
+
+	if err := requireTerminal("target", "--target or --instance-id"); err != nil {
+		return nil, err
 	}
 
 	// Prompt user to select an instance
@@ -165,7 +458,7 @@ func AskTarget(ctx context.Context, cfg aws.Config) (*Target, error) {
 	}
 
 	var selectedKey string
-	err = survey.AskOne(prompt, &selectedKey,
+	err := survey.AskOne(prompt, &selectedKey,
 		survey.WithIcons(func(icons *survey.IconSet) {
 			icons.SelectFocus.Format = "green+hb"
 		}),
@@ -178,23 +471,24 @@ func AskTarget(ctx context.Context, cfg aws.Config) (*Target, error) {
 	return instances[selectedKey], nil
 }
 
-// AskMultiTarget prompts the user to select multiple EC2 instances
-func AskMultiTarget(ctx context.Context, cfg aws.Config) ([]*Target, error) {
-	// Get available instances
-	instances, err := FindInstances(ctx, cfg)
-	if err != nil {
-		return nil, err
+// AskMultiTarget prompts the user to select multiple EC2 instances. With groupBy set, the
+// instances are grouped by the value of that tag, and each group can be selected as a whole.
+func AskMultiTarget(instances map[string]*Target, groupBy string) ([]*Target, error) {
+	if len(instances) == 0 {
+		return nil, ErrNoInstances
 	}
 
 	// Create a list of instance options
-	options := make([]string, 0, len(instances))
-	for k := range instances {
-		options = append(options, k)
+	var options []string
+	var groups map[string][]string
+	if groupBy != "" {
+		options, groups = groupedTargetOptions(instances, groupBy)
+	} else {
+		options = slices.Sorted(maps.Keys(instances))
 	}
-	sort.Strings(options)
 
-	if len(options) == 0 {
-		return nil, errors.New("no EC2 instances found")
+	if err := requireTerminal("target", "--target or --targets-file"); err != nil {
+		return nil, err
 	}
 
 	// Prompt user to select multiple instances
@@ -208,17 +502,96 @@ func AskMultiTarget(ctx context.Context, cfg aws.Config) ([]*Target, error) {
 		return nil, fmt.Errorf("target selection failed: %w", err)
 	}
 
-	// Create list of selected targets
+	// Create list of selected targets, expanding selected groups
 	targets := make([]*Target, 0, len(selectedKeys))
 	for _, k := range selectedKeys {
-		targets = append(targets, instances[k])
+		keys, ok := groups[k]
+		if !ok {
+			keys = []string{k}
+		}
+		for _, key := range keys {
+			if !slices.Contains(targets, instances[key]) {
+				targets = append(targets, instances[key])
+			}
+		}
 	}
 
 	return targets, nil
 }
 
+// groupedTargetOptions returns the picker options with the instances grouped by the value of
+// the tag, instances without it last. Each group starts with an option selecting the whole
+// group, which maps to the options of its instances.
+func groupedTargetOptions(instances map[string]*Target, tag string) ([]string, map[string][]string) {
+	members := make(map[string][]string)
+	for _, key := range slices.Sorted(maps.Keys(instances)) {
+		value := instances[key].Tags[tag]
+		members[value] = append(members[value], key)
+	}
+
+	values := slices.Sorted(maps.Keys(members))
+	if values[0] == "" {
+		values = append(values[1:], "")
+	}
+
+	var options []string
+	groups := make(map[string][]string, len(values))
+	for _, value := range values {
+		count := fmt.Sprintf("%d instances", len(members[value]))
+		if len(members[value]) == 1 {
+			count = "1 instance"
+		}
+		header := fmt.Sprintf("[all %s=%s] (%s)", tag, value, count)
+		if value == "" {
+			header = fmt.Sprintf("[all without %s] (%s)", tag, count)
+		}
+		groups[header] = members[value]
+		options = append(options, header)
+		options = append(options, members[value]...)
+	}
+	return options, groups
+}
+
+// ConfirmTargetCount asks the user to type the number of targets to confirm running a command
+// on all of them, so a broad selection can't be confirmed by reflex
+func ConfirmTargetCount(command string, count int) error {
+	prompt := &survey.Input{
+		Message: fmt.Sprintf("Run '%s' on %d instances? Type %d to confirm:", command, count, count),
+	}
+
+	var answer string
+	if err := survey.AskOne(prompt, &answer); err != nil {
+		return fmt.Errorf("confirmation failed: %w", err)
+	}
+	if strings.TrimSpace(answer) != strconv.Itoa(count) {
+		return ErrNotConfirmed
+	}
+
+	return nil
+}
+
+// ConfirmTerminateSessions asks the user to confirm terminating the listed sessions
+func ConfirmTerminateSessions(count int) error {
+	confirmed := false
+	prompt := &survey.Confirm{
+		Message: fmt.Sprintf("Terminate %d session(s)?", count),
+	}
+	if err := survey.AskOne(prompt, &confirmed); err != nil {
+		return fmt.Errorf("confirmation failed: %w", err)
+	}
+	if !confirmed {
+		return ErrNotConfirmed
+	}
+
+	return nil
+}
+
 // AskPorts prompts the user for port forwarding configuration
 func AskPorts() (*Port, error) {
+	if err := requireTerminal("port", "--remote"); err != nil {
+		return nil, err
+	}
+
 	port := &Port{}
 
 	// Prepare prompts for remote and local ports
@@ -257,17 +630,38 @@ func AskPorts() (*Port, error) {
 	return port, nil
 }
 
-// FindInstances returns all running EC2 instances that have SSM agent
-func FindInstances(ctx context.Context, cfg aws.Config) (map[string]*Target, error) {
-	client := ec2.NewFromConfig(cfg)
+// FindInstances returns all running EC2 instances that have SSM agent, and the on-premises
+// instances registered with SSM
+func FindInstances(ctx context.Context, cfg aws.Config, opts FindOptions) (map[string]*Target, error) {
+	return findInstances(ctx, ec2.NewFromConfig(cfg), ssm.NewFromConfig(cfg), cfg.Region, opts)
+}
+
+// findInstances implements FindInstances with the given clients for the region
+func findInstances(ctx context.Context, client ec2DescribeAPI, ssmClient ssmDescribeAPI, region string, opts FindOptions) (map[string]*Target, error) {
 	table := make(map[string]*Target)
 
-	// Find instance IDs with connected SSM agent
-	instanceIDs, err := FindInstanceIdsWithConnectedSSM(ctx, cfg)
+	// Find instances with connected SSM agent
+	infos, managed, err := findConnectedInstances(ctx, ssmClient, region, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	// Only the SSM agent knows the operating system name
+	instanceIDs := make([]string, 0, len(infos))
+	platformNames := make(map[string]string, len(infos))
+	for _, info := range infos {
+		instanceIDs = append(instanceIDs, aws.ToString(info.InstanceId))
+		platformNames[aws.ToString(info.InstanceId)] = aws.ToString(info.PlatformName)
+	}
+
+	// Add on-premises managed instances, which DescribeInstances doesn't know
+	for _, info := range managed {
+		target := managedInstanceTarget(info, region)
+		if opts.matchesTarget(target) {
+			table[target.label()] = target
+		}
+	}
+
 	// Process instances in batches (AWS API limit is 200 filters per call)
 	for len(instanceIDs) > 0 {
 		batchSize := len(instanceIDs)
@@ -279,12 +673,20 @@ func FindInstances(ctx context.Context, cfg aws.Config) (map[string]*Target, err
 		batch := instanceIDs[:batchSize]
 		instanceIDs = instanceIDs[batchSize:]
 
-		// Describe the instances
+		// Describe the instances, applying any tag filters server-side
+		filters := []ec2types.Filter{
+			{Name: aws.String("instance-state-name"), Values: []string{"running"}},
+			{Name: aws.String("instance-id"), Values: batch},
+		}
+		for _, tagFilter := range opts.TagFilters {
+			filters = append(filters, ec2types.Filter{
+				Name:   aws.String("tag:" + tagFilter.Key),
+				Values: []string{tagFilter.Value},
+			})
+		}
+
 		output, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
-			Filters: []ec2types.Filter{
-				{Name: aws.String("instance-state-name"), Values: []string{"running"}},
-				{Name: aws.String("instance-id"), Values: batch},
-			},
+			Filters: filters,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to describe instances: %w", err)
@@ -293,22 +695,32 @@ func FindInstances(ctx context.Context, cfg aws.Config) (map[string]*Target, err
 		// Process instance details
 		for _, reservation := range output.Reservations {
 			for _, instance := range reservation.Instances {
-				// Find instance name from tags
-				name := ""
+				tags := make(map[string]string, len(instance.Tags))
 				for _, tag := range instance.Tags {
-					if aws.ToString(tag.Key) == "Name" {
-						name = aws.ToString(tag.Value)
-						break
-					}
+					tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 				}
 
 				// Add to table of instances
-				displayName := fmt.Sprintf("%s\t(%s)", name, *instance.InstanceId)
-				table[displayName] = &Target{
+				target := &Target{
 					Name:          aws.ToString(instance.InstanceId),
+					InstanceName:  tags["Name"],
 					PublicDomain:  aws.ToString(instance.PublicDnsName),
 					PrivateDomain: aws.ToString(instance.PrivateDnsName),
+					Region:        region,
+					SubnetID:      aws.ToString(instance.SubnetId),
+					PlatformType:  instancePlatformType(instance),
+					PlatformName:  platformNames[aws.ToString(instance.InstanceId)],
+					InstanceType:  string(instance.InstanceType),
+					LaunchTime:    aws.ToTime(instance.LaunchTime),
+					Tags:          tags,
 				}
+				if instance.Placement != nil {
+					target.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+				}
+				if !opts.matchesTarget(target) {
+					continue
+				}
+				table[target.label()] = target
 			}
 		}
 	}
@@ -316,72 +728,198 @@ func FindInstances(ctx context.Context, cfg aws.Config) (map[string]*Target, err
 	return table, nil
 }
 
-// FindInstanceIdsWithConnectedSSM returns instance IDs that have SSM agent connected
-func FindInstanceIdsWithConnectedSSM(ctx context.Context, cfg aws.Config) ([]string, error) {
-	client := ssm.NewFromConfig(cfg)
-	instanceIDs := []string{}
+// label returns the option shown for the target in the instance picker
+func (t *Target) label() string {
+	launched := ""
+	if !t.LaunchTime.IsZero() {
+		launched = ", launched " + t.LaunchTime.Local().Format(time.DateOnly)
+	}
+	details := strings.TrimSpace(t.PlatformType + " " + t.InstanceType)
+	if t.AvailabilityZone != "" {
+		details += ", " + t.AvailabilityZone
+	}
+	return fmt.Sprintf("%s\t(%s)\t[%s%s]", t.InstanceName, t.Name, details, launched)
+}
 
-	// Initial query for instances with SSM
-	output, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
-		MaxResults: aws.Int32(maxOutputResults),
-	})
+// instancePlatformType returns the operating system family of an EC2 instance
+func instancePlatformType(instance ec2types.Instance) string {
+	if instance.Platform == ec2types.PlatformValuesWindows {
+		return "windows"
+	}
+	return "linux"
+}
+
+// FindInstancesInRegions returns running EC2 instances with SSM agent across several regions.
+// Regions are searched concurrently and each option label is prefixed with its region.
+func FindInstancesInRegions(ctx context.Context, cfg aws.Config, regions []string, opts FindOptions) (map[string]*Target, error) {
+	table := make(map[string]*Target)
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	errs := make([]error, len(regions))
+
+	// Search each region in parallel
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			regionCfg := cfg.Copy()
+			regionCfg.Region = region
+
+			instances, err := FindInstances(ctx, regionCfg, opts)
+			if err != nil {
+				errs[i] = fmt.Errorf("region %s: %w", region, err)
+				return
+			}
+
+			// Merge results, prefixing labels with the region
+			mu.Lock()
+			defer mu.Unlock()
+			for k, target := range instances {
+				table[fmt.Sprintf("[%s] %s", region, k)] = target
+			}
+		}(i, region)
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// FindInstanceIdsWithConnectedSSM returns the IDs of EC2 instances that have SSM agent connected,
+// along with the on-premises managed instances (mi-* IDs), which have no EC2 counterpart.
+// Tag filters and the online status are applied server-side, and discovery stops early at the
+// instance limit, so huge fleets are never enumerated in full.
+func FindInstanceIdsWithConnectedSSM(ctx context.Context, cfg aws.Config, opts FindOptions) ([]string, []ssmtypes.InstanceInformation, error) {
+	infos, managed, err := findConnectedInstances(ctx, ssm.NewFromConfig(cfg), cfg.Region, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to describe instance information: %w", err)
+		return nil, nil, err
 	}
 
-	// Process first page of results
-	for _, info := range output.InstanceInformationList {
-		if info.InstanceId != nil {
-			instanceIDs = append(instanceIDs, *info.InstanceId)
+	instanceIDs := make([]string, 0, len(infos))
+	for _, info := range infos {
+		instanceIDs = append(instanceIDs, aws.ToString(info.InstanceId))
+	}
+
+	return instanceIDs, managed, nil
+}
+
+// findConnectedInstances returns the SSM information of the EC2 instances that have SSM agent
+// connected, and separately that of the on-premises managed instances
+func findConnectedInstances(ctx context.Context, client ssmDescribeAPI, region string, opts FindOptions) ([]ssmtypes.InstanceInformation, []ssmtypes.InstanceInformation, error) {
+	infos, truncated, err := describeInstanceInformation(ctx, client, instanceInformationFilters(opts), opts.MaxInstances)
+	if err != nil {
+		return nil, nil, err
+	}
+	if truncated && !opts.QuietLimit {
+		Warnf("Showing the first %d instances in %s (narrow discovery with --filter or raise --max-instances)", opts.MaxInstances, region)
+	}
+
+	var instances, managed []ssmtypes.InstanceInformation
+	for _, info := range infos {
+		switch id := aws.ToString(info.InstanceId); {
+		case strings.HasPrefix(id, managedInstancePrefix):
+			managed = append(managed, info)
+		case id != "":
+			instances = append(instances, info)
 		}
 	}
 
-	// Process any additional pages of results
-	nextToken := output.NextToken
-	for nextToken != nil && *nextToken != "" {
-		nextOutput, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
-			NextToken:  nextToken,
-			MaxResults: aws.Int32(maxOutputResults),
+	return instances, managed, nil
+}
+
+// instanceInformationFilters returns the DescribeInstanceInformation filters selecting online
+// managed nodes whose tags match every tag filter
+func instanceInformationFilters(opts FindOptions) []ssmtypes.InstanceInformationStringFilter {
+	filters := []ssmtypes.InstanceInformationStringFilter{
+		{Key: aws.String("PingStatus"), Values: []string{string(ssmtypes.PingStatusOnline)}},
+	}
+	for _, tagFilter := range opts.TagFilters {
+		filters = append(filters, ssmtypes.InstanceInformationStringFilter{
+			Key:    aws.String("tag:" + tagFilter.Key),
+			Values: []string{tagFilter.Value},
 		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to describe additional instance information: %w", err)
+	}
+	return filters
+}
+
+// describeInstanceInformation returns the SSM information of the managed nodes matching the filters.
+// With a limit above 0, pagination stops once that many nodes are found and truncated reports
+// whether more were left.
+func describeInstanceInformation(ctx context.Context, client ssmDescribeAPI, filters []ssmtypes.InstanceInformationStringFilter, limit int) (infos []ssmtypes.InstanceInformation, truncated bool, err error) {
+	paginator := ssm.NewDescribeInstanceInformationPaginator(client, &ssm.DescribeInstanceInformationInput{
+		Filters:    filters,
+		MaxResults: aws.Int32(maxOutputResults),
+	})
+	for paginator.HasMorePages() {
+		if limit > 0 && len(infos) >= limit {
+			return infos[:limit], true, nil
 		}
 
-		for _, info := range nextOutput.InstanceInformationList {
-			if info.InstanceId != nil {
-				instanceIDs = append(instanceIDs, *info.InstanceId)
-			}
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to describe instance information: %w", err)
 		}
+		infos = append(infos, page.InstanceInformationList...)
+	}
 
-		nextToken = nextOutput.NextToken
+	if limit > 0 && len(infos) > limit {
+		return infos[:limit], true, nil
 	}
+	return infos, false, nil
+}
 
-	return instanceIDs, nil
+// managedInstanceTarget builds the target of an on-premises managed instance from its SSM information
+func managedInstanceTarget(info ssmtypes.InstanceInformation, region string) *Target {
+	name := aws.ToString(info.Name)
+	if name == "" {
+		name = aws.ToString(info.ComputerName)
+	}
+
+	return &Target{
+		Name:          aws.ToString(info.InstanceId),
+		InstanceName:  name,
+		PrivateDomain: aws.ToString(info.IPAddress),
+		Region:        region,
+		PlatformType:  strings.ToLower(string(info.PlatformType)),
+		PlatformName:  aws.ToString(info.PlatformName),
+		LaunchTime:    aws.ToTime(info.RegistrationDate),
+	}
 }
 
-// FindInstanceIdByIp finds an EC2 instance ID by IP address
-func FindInstanceIdByIp(ctx context.Context, cfg aws.Config, ip string) (string, error) {
-	client := ec2.NewFromConfig(cfg)
+// GetAgentPingStatus returns the ping status of the SSM agent on a managed instance.
+// An instance that isn't registered with SSM returns ErrAgentOffline.
+func GetAgentPingStatus(ctx context.Context, cfg aws.Config, instanceID string) (ssmtypes.PingStatus, error) {
+	client := ssm.NewFromConfig(cfg)
 
-	// Function to find an instance with matching IP
-	findInstanceWithIP := func(output *ec2.DescribeInstancesOutput) string {
-		for _, reservation := range output.Reservations {
-			for _, instance := range reservation.Instances {
-				// Skip instances without IP addresses
-				if instance.PublicIpAddress == nil && instance.PrivateIpAddress == nil {
-					continue
-				}
+	output, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+		Filters: []ssmtypes.InstanceInformationStringFilter{
+			{Key: aws.String("InstanceIds"), Values: []string{instanceID}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe instance information: %w", err)
+	}
 
-				// Check if public or private IP matches
-				if ip == aws.ToString(instance.PublicIpAddress) ||
-					ip == aws.ToString(instance.PrivateIpAddress) {
-					return *instance.InstanceId
-				}
-			}
-		}
-		return ""
+	if len(output.InstanceInformationList) == 0 {
+		return "", fmt.Errorf("%w: %s is not registered with SSM", ErrAgentOffline, instanceID)
 	}
 
+	return output.InstanceInformationList[0].PingStatus, nil
+}
+
+// FindInstanceIdsByIp finds the IDs of all running EC2 instances with the IP address.
+// Several instances match when VPCs reuse private IP ranges; none match returns an empty list.
+func FindInstanceIdsByIp(ctx context.Context, cfg aws.Config, ip string) ([]string, error) {
+	return findInstanceIdsByIp(ctx, ec2.NewFromConfig(cfg), ip)
+}
+
+// findInstanceIdsByIp implements FindInstanceIdsByIp with the given client
+func findInstanceIdsByIp(ctx context.Context, client ec2DescribeAPI, ip string) ([]string, error) {
 	// Initial query for running instances
 	output, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
 		MaxResults: aws.Int32(maxOutputResults),
@@ -390,16 +928,13 @@ func FindInstanceIdByIp(ctx context.Context, cfg aws.Config, ip string) (string,
 		},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to describe instances: %w", err)
+		return nil, fmt.Errorf("failed to describe instances: %w", err)
 	}
 
 	// Check first page of results
-	instanceID := findInstanceWithIP(output)
-	if instanceID != "" {
-		return instanceID, nil
-	}
+	instanceIDs := findInstancesWithIP(output, ip)
 
-	// Process any additional pages of results
+	// Process any additional pages of results, as a match may be repeated on a later page
 	nextToken := output.NextToken
 	for nextToken != nil && *nextToken != "" {
 		nextOutput, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
@@ -410,18 +945,51 @@ func FindInstanceIdByIp(ctx context.Context, cfg aws.Config, ip string) (string,
 			},
 		})
 		if err != nil {
-			return "", fmt.Errorf("failed to describe additional instances: %w", err)
+			return nil, fmt.Errorf("failed to describe additional instances: %w", err)
 		}
 
-		instanceID = findInstanceWithIP(nextOutput)
-		if instanceID != "" {
-			return instanceID, nil
+		instanceIDs = append(instanceIDs, findInstancesWithIP(nextOutput, ip)...)
+		nextToken = nextOutput.NextToken
+	}
+
+	return instanceIDs, nil
+}
+
+// findInstancesWithIP returns the IDs of the instances in output that have the IP address
+func findInstancesWithIP(output *ec2.DescribeInstancesOutput, ip string) []string {
+	var instanceIDs []string
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			if instanceHasIP(instance, ip) {
+				instanceIDs = append(instanceIDs, aws.ToString(instance.InstanceId))
+			}
 		}
+	}
+	return instanceIDs
+}
 
-		nextToken = nextOutput.NextToken
+// instanceHasIP reports whether the IP address belongs to the instance, as its primary public or
+// private address, or as any private address or associated public address of one of its ENIs
+func instanceHasIP(instance ec2types.Instance, ip string) bool {
+	if ip == aws.ToString(instance.PublicIpAddress) || ip == aws.ToString(instance.PrivateIpAddress) {
+		return true
+	}
+
+	for _, eni := range instance.NetworkInterfaces {
+		if eni.Association != nil && ip == aws.ToString(eni.Association.PublicIp) {
+			return true
+		}
+		for _, address := range eni.PrivateIpAddresses {
+			if ip == aws.ToString(address.PrivateIpAddress) {
+				return true
+			}
+			if address.Association != nil && ip == aws.ToString(address.Association.PublicIp) {
+				return true
+			}
+		}
 	}
 
-	return "", fmt.Errorf("no instance found with IP address: %s", ip)
+	return false
 }
 
 // FindDomainByInstanceId finds DNS names for an EC2 instance by ID
@@ -487,6 +1055,10 @@ func FindDomainByInstanceId(ctx context.Context, cfg aws.Config, instanceID stri
 
 // AskHost prompts the user for a host address
 func AskHost() (string, error) {
+	if err := requireTerminal("host", "--host or --to"); err != nil {
+		return "", err
+	}
+
 	prompt := &survey.Input{
 		Message: "Type your host address you want to forward to:",
 	}
@@ -504,69 +1076,356 @@ func AskHost() (string, error) {
 
 // CreateStartSession creates an SSM session
 func CreateStartSession(ctx context.Context, cfg aws.Config, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
-	client := ssm.NewFromConfig(cfg)
-	return client.StartSession(ctx, input)
+	return createStartSession(ctx, ssm.NewFromConfig(cfg), cfg.Region, input)
+}
+
+// createStartSession implements CreateStartSession with the given client for the region
+func createStartSession(ctx context.Context, client ssmSessionAPI, region string, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+	Tracef("StartSession input: %s", toJSON(input))
+	output, err := client.StartSession(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	EmitEvent(EventSessionCreated, aws.ToString(output.SessionId), aws.ToString(input.Target), region)
+	return output, nil
+}
+
+// CallSessionPlugin runs the SSM plugin for a session created from input, attached to the
+// terminal, until the session ends
+func CallSessionPlugin(opts ProcessOptions, pluginPath string, session *ssm.StartSessionOutput, input *ssm.StartSessionInput, region, profile string) error {
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	paramsJSON, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session parameters: %w", err)
+	}
+
+	return CallProcess(opts, pluginPath, string(sessionJSON), region, "StartSession", profile, string(paramsJSON))
 }
 
 // DeleteStartSession terminates an SSM session
 func DeleteStartSession(ctx context.Context, cfg aws.Config, input *ssm.TerminateSessionInput) error {
-	client := ssm.NewFromConfig(cfg)
+	return deleteStartSession(ctx, ssm.NewFromConfig(cfg), cfg.Region, input)
+}
 
-	fmt.Printf("%s %s\n",
-		color.YellowString("Delete Session"),
-		color.YellowString(aws.ToString(input.SessionId)))
+// deleteStartSession implements DeleteStartSession with the given client for the region
+func deleteStartSession(ctx context.Context, client ssmSessionAPI, region string, input *ssm.TerminateSessionInput) error {
+	// Log to stderr so stdout stays clean when proxying ssh traffic
+	Infof("Delete Session %s", aws.ToString(input.SessionId))
 
 	_, err := client.TerminateSession(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to terminate session: %w", err)
 	}
 
+	EmitEvent(EventSessionTerminated, aws.ToString(input.SessionId), "", region)
 	return nil
 }
 
-// SendCommand sends a command to EC2 instances via SSM
-func SendCommand(ctx context.Context, cfg aws.Config, targets []*Target, command string) (*ssm.SendCommandOutput, error) {
+// ListActiveSessions returns the active SSM sessions owned by the given principal ARN
+func ListActiveSessions(ctx context.Context, cfg aws.Config, owner string) ([]ssmtypes.Session, error) {
+	return describeActiveSessions(ctx, cfg, ssmtypes.SessionFilter{Key: ssmtypes.SessionFilterKeyOwner, Value: aws.String(owner)})
+}
+
+// FindActiveSession returns the active SSM session with the given ID, whoever owns it
+func FindActiveSession(ctx context.Context, cfg aws.Config, sessionID string) (ssmtypes.Session, error) {
+	sessions, err := describeActiveSessions(ctx, cfg, ssmtypes.SessionFilter{Key: ssmtypes.SessionFilterKeySessionId, Value: aws.String(sessionID)})
+	if err != nil {
+		return ssmtypes.Session{}, err
+	}
+	if len(sessions) == 0 {
+		return ssmtypes.Session{}, fmt.Errorf("session %s is not active in %s", sessionID, cfg.Region)
+	}
+	return sessions[0], nil
+}
+
+// describeActiveSessions returns the active SSM sessions matching the filter
+func describeActiveSessions(ctx context.Context, cfg aws.Config, filter ssmtypes.SessionFilter) ([]ssmtypes.Session, error) {
 	client := ssm.NewFromConfig(cfg)
 
+	paginator := ssm.NewDescribeSessionsPaginator(client, &ssm.DescribeSessionsInput{
+		State:   ssmtypes.SessionStateActive,
+		Filters: []ssmtypes.SessionFilter{filter},
+	})
+
+	var sessions []ssmtypes.Session
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe sessions: %w", err)
+		}
+		sessions = append(sessions, page.Sessions...)
+	}
+
+	return sessions, nil
+}
+
+// RunCommandOptions selects the Run Command document and where its output goes
+type RunCommandOptions struct {
+	DocumentName       string // Document to run, or empty for AWS-RunShellScript
+	CommandParameter   string // Document parameter receiving the command, or empty for "commands"
+	CloudWatchLogGroup string // Log group name, or empty for the default /aws/ssm/<document> group
+	CloudWatchDisabled bool   // Don't send output to CloudWatch Logs
+	OutputS3Bucket     string // S3 bucket receiving the full output, or empty to keep it in SSM only
+}
+
+// Document returns the name of the document to run
+func (o RunCommandOptions) Document() string {
+	return cmp.Or(o.DocumentName, shellDocumentName)
+}
+
+// LogGroup returns the CloudWatch log group that receives the output, or "" if disabled
+func (o RunCommandOptions) LogGroup() string {
+	if o.CloudWatchDisabled {
+		return ""
+	}
+	return cmp.Or(o.CloudWatchLogGroup, "/aws/ssm/"+o.Document())
+}
+
+// CloudWatchConsoleURL returns the CloudWatch console page of a log group
+func CloudWatchConsoleURL(region, logGroup string) string {
+	domain := "console.aws.amazon.com"
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		domain = "console.amazonaws.cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		domain = "console.amazonaws-us-gov.com"
+	}
+
+	// The console expects the group name URL-encoded twice, with % written as $
+	encoded := strings.ReplaceAll(url.QueryEscape(url.QueryEscape(logGroup)), "%", "$")
+	return fmt.Sprintf("https://%s.%s/cloudwatch/home?region=%s#logsV2:log-groups/log-group/%s",
+		region, domain, region, encoded)
+}
+
+// SendCommand sends a command to EC2 instances via SSM, running the shell document unless opts
+// selects another, and sending its output to CloudWatch Logs unless disabled. SSM accepts at
+// most 50 instances per call, so larger selections are sent in batches, returning one command
+// per batch. If a batch fails, the commands already sent are returned along with the error.
+func SendCommand(ctx context.Context, cfg aws.Config, targets []*Target, command string, opts RunCommandOptions) ([]ssmtypes.Command, error) {
+	return sendCommand(ctx, ssm.NewFromConfig(cfg), targets, command, opts)
+}
+
+// sendCommand implements SendCommand with the given client
+func sendCommand(ctx context.Context, client ssmCommandAPI, targets []*Target, command string, opts RunCommandOptions) ([]ssmtypes.Command, error) {
 	// Extract instance IDs from targets
 	instanceIDs := make([]string, 0, len(targets))
 	for _, target := range targets {
 		instanceIDs = append(instanceIDs, target.Name)
 	}
 
-	// Create command input
+	var commands []ssmtypes.Command
+	for batch := range slices.Chunk(instanceIDs, maxCommandInstances) {
+		input := sendCommandInput(batch, command, opts)
+		Tracef("SendCommand input: %s", toJSON(input))
+
+		output, err := client.SendCommand(ctx, input)
+		if err != nil {
+			return commands, fmt.Errorf("failed to send command to %d of %d instances: %w",
+				len(instanceIDs)-len(commands)*maxCommandInstances, len(instanceIDs), err)
+		}
+		commands = append(commands, *output.Command)
+	}
+	return commands, nil
+}
+
+// ParseSSMTargets parses Run Command targets in the form Key=Value[,Value...], for example
+// tag:Env=prod or resource-groups:Name=web. Instances must match every target.
+func ParseSSMTargets(specs []string) ([]ssmtypes.Target, error) {
+	if len(specs) > maxCommandTargets {
+		return nil, fmt.Errorf("too many SSM targets: %d, at most %d are supported", len(specs), maxCommandTargets)
+	}
+
+	targets := make([]ssmtypes.Target, 0, len(specs))
+	for _, spec := range specs {
+		key, values, ok := strings.Cut(spec, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.TrimSpace(values) == "" {
+			return nil, fmt.Errorf("invalid SSM target '%s': expected Key=Value, e.g. tag:Env=prod", spec)
+		}
+
+		target := ssmtypes.Target{Key: aws.String(key)}
+		for _, value := range strings.Split(values, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				target.Values = append(target.Values, value)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// SendCommandToSSMTargets sends a command to the instances matching the targets, which SSM
+// resolves itself, so no instances need to be discovered first
+func SendCommandToSSMTargets(ctx context.Context, cfg aws.Config, targets []ssmtypes.Target, command string, opts RunCommandOptions) (ssmtypes.Command, error) {
+	client := ssm.NewFromConfig(cfg)
+
+	input := sendCommandInput(nil, command, opts)
+	input.Targets = targets
+	Tracef("SendCommand input: %s", toJSON(input))
+
+	output, err := client.SendCommand(ctx, input)
+	if err != nil {
+		return ssmtypes.Command{}, fmt.Errorf("failed to send command: %w", err)
+	}
+	return *output.Command, nil
+}
+
+// WaitForCommandInstances waits until SSM resolved the targets of a command to instances and
+// returns their IDs, or an error if no instance matched
+func WaitForCommandInstances(ctx context.Context, cfg aws.Config, commandID string) ([]string, error) {
+	client := ssm.NewFromConfig(cfg)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			output, err := client.ListCommands(ctx, &ssm.ListCommandsInput{CommandId: aws.String(commandID)})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get command %s: %w", commandID, err)
+			}
+			if len(output.Commands) == 0 {
+				continue
+			}
+
+			command := output.Commands[0]
+			if command.TargetCount == 0 {
+				// Targets are still being resolved while the command is pending
+				if command.Status == ssmtypes.CommandStatusPending || command.Status == ssmtypes.CommandStatusInProgress {
+					continue
+				}
+				return nil, fmt.Errorf("no instances matched the targets of command %s", commandID)
+			}
+
+			instanceIDs, err := ListCommandInstances(ctx, cfg, commandID)
+			if err == nil && len(instanceIDs) >= int(command.TargetCount) {
+				return instanceIDs, nil
+			}
+		}
+	}
+}
+
+// sendCommandInput returns the SendCommand input running the command on the instances
+func sendCommandInput(instanceIDs []string, command string, opts RunCommandOptions) *ssm.SendCommandInput {
 	input := &ssm.SendCommandInput{
-		DocumentName:   aws.String(shellDocumentName),
+		DocumentName:   aws.String(opts.Document()),
 		InstanceIds:    instanceIDs,
 		TimeoutSeconds: aws.Int32(commandTimeout),
-		CloudWatchOutputConfig: &ssmtypes.CloudWatchOutputConfig{
-			CloudWatchOutputEnabled: true,
-		},
 		Parameters: map[string][]string{
-			"commands": {command},
+			cmp.Or(opts.CommandParameter, shellCommandParameter): {command},
 		},
 	}
+	// Leave the CloudWatch config out entirely when disabled, so no CloudWatch permission is needed
+	if !opts.CloudWatchDisabled {
+		input.CloudWatchOutputConfig = &ssmtypes.CloudWatchOutputConfig{CloudWatchOutputEnabled: true}
+		if opts.CloudWatchLogGroup != "" {
+			input.CloudWatchOutputConfig.CloudWatchLogGroupName = aws.String(opts.CloudWatchLogGroup)
+		}
+	}
+	if opts.OutputS3Bucket != "" {
+		input.OutputS3BucketName = aws.String(opts.OutputS3Bucket)
+	}
+	return input
+}
+
+// ListCommandInstances returns the IDs of the instances a command was sent to
+func ListCommandInstances(ctx context.Context, cfg aws.Config, commandID string) ([]string, error) {
+	client := ssm.NewFromConfig(cfg)
 
-	return client.SendCommand(ctx, input)
+	var instanceIDs []string
+	paginator := ssm.NewListCommandInvocationsPaginator(client, &ssm.ListCommandInvocationsInput{
+		CommandId: aws.String(commandID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list command invocations: %w", err)
+		}
+		for _, invocation := range page.CommandInvocations {
+			instanceIDs = append(instanceIDs, aws.ToString(invocation.InstanceId))
+		}
+	}
+
+	if len(instanceIDs) == 0 {
+		return nil, fmt.Errorf("command %s not found in %s", commandID, cfg.Region)
+	}
+	return instanceIDs, nil
 }
 
-// PrintCommandInvocation watches and displays command invocation results
-func PrintCommandInvocation(ctx context.Context, cfg aws.Config, inputs []*ssm.GetCommandInvocationInput) {
+// CommandOutputOptions configures how Run Command results are collected
+type CommandOutputOptions struct {
+	Concurrency int    // Maximum number of invocations polled at once
+	OutputDir   string // Directory receiving <instance-id>.stdout and .stderr files, if set
+	FilesOnly   bool   // Only write results to OutputDir, printing where they went
+}
+
+// PrintCommandInvocation watches and displays command invocation results, polling at most
+// opts.Concurrency invocations at a time to stay within API rate limits. It returns
+// ErrCommandFailed if an invocation failed, and ErrCommandTimeout if ctx expired before
+// every invocation finished.
+func PrintCommandInvocation(ctx context.Context, cfg aws.Config, inputs []*ssm.GetCommandInvocationInput, opts CommandOutputOptions) error {
 	client := ssm.NewFromConfig(cfg)
-	wg := &sync.WaitGroup{}
 
-	// Process each command invocation in parallel
+	instanceIDs := make([]string, 0, len(inputs))
 	for _, input := range inputs {
+		instanceIDs = append(instanceIDs, aws.ToString(input.InstanceId))
+	}
+	board := newStatusBoard(instanceIDs)
+	defer board.close()
+
+	// Hand the invocations to a bounded pool of workers
+	queue := make(chan *ssm.GetCommandInvocationInput)
+	wg := &sync.WaitGroup{}
+	var succeeded, failed atomic.Int32
+	for range min(opts.Concurrency, len(inputs)) {
 		wg.Add(1)
-		go monitorCommandInvocation(ctx, client, input, wg)
+		go func() {
+			defer wg.Done()
+			for input := range queue {
+				switch monitorCommandInvocation(ctx, client, input, board, opts) {
+				case statusDone:
+					succeeded.Add(1)
+				case statusFailed:
+					failed.Add(1)
+				}
+			}
+		}()
 	}
 
+	for _, input := range inputs {
+		select {
+		case queue <- input:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+
 	wg.Wait()
+
+	var errs []error
+	if n := failed.Load(); n > 0 {
+		errs = append(errs, fmt.Errorf("%w on %d of %d instances", ErrCommandFailed, n, len(inputs)))
+	}
+	if unfinished := len(inputs) - int(succeeded.Load()+failed.Load()); unfinished > 0 {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			errs = append(errs, fmt.Errorf("%w: still running on %d of %d instances", ErrCommandTimeout, unfinished, len(inputs)))
+		} else {
+			errs = append(errs, ctx.Err())
+		}
+	}
+	return errors.Join(errs...)
 }
 
-// monitorCommandInvocation monitors a single command invocation
-func monitorCommandInvocation(ctx context.Context, client *ssm.Client, input *ssm.GetCommandInvocationInput, wg *sync.WaitGroup) {
-	defer wg.Done()
+// monitorCommandInvocation monitors a single command invocation until it completes or ctx is
+// done, returning statusDone or statusFailed once it completed
+func monitorCommandInvocation(ctx context.Context, client ssmInvocationAPI, input *ssm.GetCommandInvocationInput, board *statusBoard, opts CommandOutputOptions) string {
+	instanceID := aws.ToString(input.InstanceId)
 
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
@@ -574,81 +1433,266 @@ func monitorCommandInvocation(ctx context.Context, client *ssm.Client, input *ss
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return statusRunning
 		case <-ticker.C:
 			output, err := client.GetCommandInvocation(ctx, input)
 			if err != nil {
-				color.Red("Failed to get command invocation: %v", err)
-				return
+				// The invocation may not be registered yet right after sending
+				var notFound *ssmtypes.InvocationDoesNotExist
+				if errors.As(err, &notFound) {
+					continue
+				}
+				board.set(instanceID, statusFailed)
+				board.printResult("%s\n", color.RedString("Failed to get command invocation for %s: %v", instanceID, err))
+				return statusFailed
 			}
 
 			// Check command status
 			status := strings.ToLower(string(output.Status))
 			switch status {
+			case "pending", "delayed":
+				continue
+			case "inprogress":
+				board.set(instanceID, statusRunning)
+				continue
+			}
+
+			// Success only means the command ran, a non-zero exit code is still a failure
+			succeeded := status == "success" && output.ResponseCode == 0
+			exitStatus := commandExitStatus(output)
+			result := statusFailed
+			if succeeded {
+				result = statusDone
+			}
+			board.set(instanceID, result)
+
+			// Save the output as soon as the instance completes
+			if opts.OutputDir != "" {
+				path, err := writeCommandOutput(opts.OutputDir, output)
+				if err != nil {
+					board.printResult("%s\n", color.RedString("%v", err))
+				} else if opts.FilesOnly {
+					printCommandResult(board, instanceID, succeeded, exitStatus, "output written to "+path+".{stdout,stderr}")
+					printTruncationNotice(board, output)
+					return result
+				}
+			}
+
+			if succeeded {
+				printCommandResult(board, instanceID, true, exitStatus, aws.ToString(output.StandardOutputContent))
+			} else {
+				// A script that exits non-zero may only have written to stdout
+				message := cmp.Or(aws.ToString(output.StandardErrorContent), aws.ToString(output.StandardOutputContent))
+				printCommandResult(board, instanceID, false, exitStatus, message)
+			}
+			printTruncationNotice(board, output)
+			return result
+		}
+	}
+}
+
+// commandExitStatus describes how an invocation ended: its exit code, or its status if the
+// command never ran to completion, which SSM reports as response code -1
+func commandExitStatus(output *ssm.GetCommandInvocationOutput) string {
+	if output.ResponseCode < 0 {
+		return string(output.Status)
+	}
+	return fmt.Sprintf("exit %d", output.ResponseCode)
+}
+
+// printCommandResult prints the result of a completed invocation
+func printCommandResult(board *statusBoard, instanceID string, succeeded bool, exitStatus, message string) {
+	if succeeded {
+		board.printResult("[%s][%s][%s] %s\n",
+			color.GreenString("success"),
+			color.YellowString(instanceID),
+			exitStatus,
+			color.GreenString(message))
+		return
+	}
+	board.printResult("[%s][%s][%s] %s\n",
+		color.RedString("error"),
+		color.YellowString(instanceID),
+		color.RedString(exitStatus),
+		color.RedString(message))
+}
+
+// printTruncationNotice warns that SSM cut off the output of an invocation, if it did
+func printTruncationNotice(board *statusBoard, output *ssm.GetCommandInvocationOutput) {
+	if notice := truncationNotice(output); notice != "" {
+		board.printResult("[%s][%s] %s\n",
+			color.YellowString("warning"),
+			color.YellowString(aws.ToString(output.InstanceId)),
+			color.YellowString(notice))
+	}
+}
+
+// truncationNotice explains where to find the full output of an invocation whose output SSM
+// truncated, or returns "" if the output is complete
+func truncationNotice(output *ssm.GetCommandInvocationOutput) string {
+	if !outputTruncated(aws.ToString(output.StandardOutputContent)) &&
+		!outputTruncated(aws.ToString(output.StandardErrorContent)) {
+		return ""
+	}
+
+	switch cloudWatch := output.CloudWatchOutputConfig; {
+	case aws.ToString(output.StandardOutputUrl) != "":
+		return "[output truncated, full output in " + aws.ToString(output.StandardOutputUrl) + "]"
+	case cloudWatch != nil && cloudWatch.CloudWatchOutputEnabled:
+		logGroup := cmp.Or(aws.ToString(cloudWatch.CloudWatchLogGroupName), "/aws/ssm/"+aws.ToString(output.DocumentName))
+		return "[output truncated, full output in CloudWatch log group " + logGroup + "]"
+	default:
+		return "[output truncated — configure --output-s3-bucket for full output]"
+	}
+}
+
+// outputTruncated reports whether SSM truncated the output, which it does at
+// commandOutputLimit characters, marking it if the agent truncated it first
+func outputTruncated(content string) bool {
+	return utf8.RuneCountInString(content) >= commandOutputLimit ||
+		strings.HasSuffix(strings.TrimRight(content, "\n"), truncatedOutputMarker) ||
+		strings.HasSuffix(strings.TrimRight(content, "\n"), truncatedErrorMarker)
+}
+
+// writeCommandOutput writes the standard output and error of an invocation to
+// <instance-id>.stdout and <instance-id>.stderr in dir, returning the path without extension
+func writeCommandOutput(dir string, output *ssm.GetCommandInvocationOutput) (string, error) {
+	path := filepath.Join(dir, aws.ToString(output.InstanceId))
+	files := map[string]string{
+		path + ".stdout": aws.ToString(output.StandardOutputContent),
+		path + ".stderr": aws.ToString(output.StandardErrorContent),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+			return "", fmt.Errorf("failed to save command output: %w", err)
+		}
+	}
+	return path, nil
+}
+
+// waitForCommandInvocation polls a command invocation until it reaches a terminal state
+func waitForCommandInvocation(ctx context.Context, client ssmInvocationAPI, input *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			output, err := client.GetCommandInvocation(ctx, input)
+			if err != nil {
+				// The invocation may not be registered yet right after sending
+				var notFound *ssmtypes.InvocationDoesNotExist
+				if errors.As(err, &notFound) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to get command invocation: %w", err)
+			}
+
+			switch strings.ToLower(string(output.Status)) {
 			case "pending", "inprogress", "delayed":
-				// Still running, continue polling
 				continue
-			case "success":
-				fmt.Printf("[%s][%s] %s\n",
-					color.GreenString("success"),
-					color.YellowString(*output.InstanceId),
-					color.GreenString(*output.StandardOutputContent))
-				return
 			default:
-				fmt.Printf("[%s][%s] %s\n",
-					color.RedString("error"),
-					color.YellowString(*output.InstanceId),
-					color.RedString(*output.StandardErrorContent))
-				return
+				return output, nil
 			}
 		}
 	}
 }
 
-// GenerateSSHExecCommand generates an SSH command string
-func GenerateSSHExecCommand(exec, identity, user, domain string) string {
-	var newExec string
+// GenerateSSHExecCommand generates the arguments of an SSH command. Without exec, it connects to
+// user@domain, or to domain alone if it has no user or already names one. The identity file is
+// added unless the arguments already pass one.
+func GenerateSSHExecCommand(exec, identity, user, domain string) (string, error) {
+	newExec := exec
 
 	// Create base command
 	if exec == "" {
-		newExec = fmt.Sprintf("%s@%s", user, domain)
-	} else {
-		newExec = exec
+		switch {
+		case domain == "":
+			return "", errors.New("no host to connect to")
+		case user == "" || strings.Contains(domain, "@"):
+			newExec = domain
+		default:
+			newExec = fmt.Sprintf("%s@%s", user, domain)
+		}
 	}
 
-	// Check if command already includes identity flag
-	hasIdentityFlag := strings.Contains(newExec, " -i ")
-
 	// Add identity flag if needed
-	if !hasIdentityFlag && identity != "" {
+	if identity != "" && !hasSSHIdentity(newExec) {
 		newExec = fmt.Sprintf("-i %s %s", identity, newExec)
 	}
 
-	return newExec
+	return newExec, nil
+}
+
+// hasSSHIdentity reports whether ssh arguments pass an identity file with -i, looking only at the
+// options before the destination, as later arguments belong to the remote command
+func hasSSHIdentity(args string) bool {
+	fields := strings.Fields(args)
+	if operands := OperandIndexes(fields, SSHOptionsWithArgs); len(operands) > 0 {
+		fields = fields[:operands[0]]
+	}
+	return HasShortOption(fields, SSHOptionsWithArgs, 'i')
 }
 
-// PrintReady displays information about the command to be run
+// PrintReady displays information about the command to be run, unless sessions are quiet
 func PrintReady(cmd, region, target string) {
+	if quietSession {
+		return
+	}
 	fmt.Printf("[%s] region: %s, target: %s\n",
 		color.GreenString(cmd),
 		color.YellowString(region),
 		color.YellowString(target))
 }
 
+// quietSession suppresses the banners printed around sessions
+var quietSession bool
+
+// SetQuietSession suppresses the banners printed around sessions, for scripts and tmux
+func SetQuietSession(quiet bool) {
+	quietSession = quiet
+}
+
 // CallProcess executes an external process with escape sequence support for interactive sessions
-func CallProcess(process string, args ...string) error {
+func CallProcess(opts ProcessOptions, process string, args ...string) error {
+	// Capture the plugin's error output to explain failures
+	opts.stderr = newPluginStderr()
+
+	// Without an escape character or session limits there is nothing to watch on stdin,
+	// so pass it straight through
+	if opts.EscapeChar == 0 && opts.IdleTimeout <= 0 && opts.MaxDuration <= 0 {
+		return opts.stderr.explain(callProcessDirect(opts, process, args...))
+	}
+
 	// Use simple escape sequence handler for interactive sessions
-	return CallProcessWithSimpleEscape(process, args...)
+	return opts.stderr.explain(CallProcessWithSimpleEscape(opts, process, args...))
 }
 
 // CallProcessDirect executes an external process without escape sequence handling
 func CallProcessDirect(process string, args ...string) error {
+	return callProcessDirect(ProcessOptions{}, process, args...)
+}
+
+// callProcessDirect executes an external process without escape sequence handling,
+// recording a transcript if opts has one
+func callProcessDirect(opts ProcessOptions, process string, args ...string) error {
 	// Create command
+	connect := newConnectWatcher(opts.ConnectTimeout)
+	connect.onReady = opts.readyEvent()
 	cmd := exec.Command(process, args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	opts.stderr.attach(cmd)
+	cmd.Stdout = connect.wrap(opts.stdout())
 	cmd.Stdin = os.Stdin
 
+	// Mirror input through a reader; as it can block on stdin after the process exits,
+	// bound how long Wait waits for it
+	if opts.Transcript != nil && opts.TranscriptInput {
+		cmd.Stdin = io.TeeReader(os.Stdin, opts.Transcript)
+		cmd.WaitDelay = transcriptWaitDelay
+	}
+
 	// Set up signal handling
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT)
@@ -668,7 +1712,16 @@ func CallProcessDirect(process string, args ...string) error {
 	defer close(done)
 
 	// Run process
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
+		return WrapError(err)
+	}
+	connect.start(cmd.Process, os.Stderr, time.After)
+
+	err := connect.stop(cmd.Wait())
+	if errors.Is(err, ErrConnectTimeout) {
+		return err
+	}
+	if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
 		return WrapError(err)
 	}
 
@@ -0,0 +1,398 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"golang.org/x/term"
+)
+
+// multiENIInstances is a DescribeInstances page with a single-ENI instance, an instance with a
+// secondary ENI, secondary private addresses and an Elastic IP on the secondary ENI, and an
+// instance in another VPC that reuses the first instance's private address
+func multiENIInstances() *ec2.DescribeInstancesOutput {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []ec2types.Reservation{
+			{
+				Instances: []ec2types.Instance{
+					{
+						InstanceId:       aws.String("i-0aaaaaaaaaaaaaaa1"),
+						PrivateIpAddress: aws.String("10.0.0.10"),
+						PublicIpAddress:  aws.String("203.0.113.10"),
+					},
+				},
+			},
+			{
+				Instances: []ec2types.Instance{
+					{
+						InstanceId:       aws.String("i-0bbbbbbbbbbbbbbb2"),
+						PrivateIpAddress: aws.String("10.0.1.20"),
+						NetworkInterfaces: []ec2types.InstanceNetworkInterface{
+							{
+								PrivateIpAddresses: []ec2types.InstancePrivateIpAddress{
+									{PrivateIpAddress: aws.String("10.0.1.20"), Primary: aws.Bool(true)},
+									{PrivateIpAddress: aws.String("10.0.1.21")},
+								},
+							},
+							{
+								Association: &ec2types.InstanceNetworkInterfaceAssociation{
+									PublicIp: aws.String("198.51.100.30"),
+								},
+								PrivateIpAddresses: []ec2types.InstancePrivateIpAddress{
+									{
+										PrivateIpAddress: aws.String("10.0.2.30"),
+										Primary:          aws.Bool(true),
+										Association: &ec2types.InstanceNetworkInterfaceAssociation{
+											PublicIp: aws.String("198.51.100.30"),
+										},
+									},
+									{
+										PrivateIpAddress: aws.String("10.0.2.31"),
+										Association: &ec2types.InstanceNetworkInterfaceAssociation{
+											PublicIp: aws.String("198.51.100.31"),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Instances: []ec2types.Instance{
+					{
+						InstanceId:       aws.String("i-0ccccccccccccccc3"),
+						PrivateIpAddress: aws.String("10.0.0.10"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFindInstancesWithIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want []string
+	}{
+		{name: "primary public address", ip: "203.0.113.10", want: []string{"i-0aaaaaaaaaaaaaaa1"}},
+		{name: "secondary private address", ip: "10.0.1.21", want: []string{"i-0bbbbbbbbbbbbbbb2"}},
+		{name: "secondary ENI private address", ip: "10.0.2.30", want: []string{"i-0bbbbbbbbbbbbbbb2"}},
+		{name: "Elastic IP on secondary ENI", ip: "198.51.100.30", want: []string{"i-0bbbbbbbbbbbbbbb2"}},
+		{name: "Elastic IP on secondary address", ip: "198.51.100.31", want: []string{"i-0bbbbbbbbbbbbbbb2"}},
+		{name: "reused private address", ip: "10.0.0.10", want: []string{"i-0aaaaaaaaaaaaaaa1", "i-0ccccccccccccccc3"}},
+		{name: "unknown address", ip: "10.0.9.9", want: nil},
+	}
+
+	output := multiENIInstances()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findInstancesWithIP(output, tt.ip); !slices.Equal(got, tt.want) {
+				t.Errorf("findInstancesWithIP(%q) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultSSHUser(t *testing.T) {
+	tests := []struct {
+		platformName string
+		want         string
+	}{
+		{platformName: "Ubuntu", want: "ubuntu"},
+		{platformName: "Amazon Linux", want: "ec2-user"},
+		{platformName: "Debian GNU/Linux", want: "admin"},
+		{platformName: "Red Hat Enterprise Linux", want: "ec2-user"},
+		{platformName: "CentOS Linux", want: "centos"},
+		{platformName: "Microsoft Windows Server 2022 Datacenter", want: "root"},
+		{platformName: "", want: "root"},
+	}
+
+	for _, tt := range tests {
+		if got := DefaultSSHUser(tt.platformName); got != tt.want {
+			t.Errorf("DefaultSSHUser(%q) = %q, want %q", tt.platformName, got, tt.want)
+		}
+	}
+}
+
+func TestWriteCommandOutput(t *testing.T) {
+	dir := t.TempDir()
+	path, err := writeCommandOutput(dir, &ssm.GetCommandInvocationOutput{
+		InstanceId:            aws.String("i-0aaaaaaaaaaaaaaa1"),
+		StandardOutputContent: aws.String("ok\n"),
+	})
+	if err != nil {
+		t.Fatalf("writeCommandOutput() error = %v", err)
+	}
+
+	for ext, want := range map[string]string{".stdout": "ok\n", ".stderr": ""} {
+		data, err := os.ReadFile(filepath.Join(dir, "i-0aaaaaaaaaaaaaaa1"+ext))
+		if err != nil || string(data) != want {
+			t.Errorf("%s%s = %q, %v, want %q", path, ext, data, err, want)
+		}
+	}
+}
+
+func TestCloudWatchConsoleURL(t *testing.T) {
+	tests := []struct {
+		region string
+		want   string
+	}{
+		{region: "eu-west-1", want: "https://eu-west-1.console.aws.amazon.com/cloudwatch/home?region=eu-west-1#logsV2:log-groups/log-group/$252Faws$252Fssm$252FAWS-RunShellScript"},
+		{region: "us-gov-west-1", want: "https://us-gov-west-1.console.amazonaws-us-gov.com/cloudwatch/home?region=us-gov-west-1#logsV2:log-groups/log-group/$252Faws$252Fssm$252FAWS-RunShellScript"},
+	}
+
+	logGroup := RunCommandOptions{}.LogGroup()
+	for _, tt := range tests {
+		if got := CloudWatchConsoleURL(tt.region, logGroup); got != tt.want {
+			t.Errorf("CloudWatchConsoleURL(%q) = %q, want %q", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestSendCommandInputCloudWatch(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RunCommandOptions
+		want *ssmtypes.CloudWatchOutputConfig
+	}{
+		{name: "default", want: &ssmtypes.CloudWatchOutputConfig{CloudWatchOutputEnabled: true}},
+		{
+			name: "log group",
+			opts: RunCommandOptions{CloudWatchLogGroup: "/gossm/patching"},
+			want: &ssmtypes.CloudWatchOutputConfig{CloudWatchOutputEnabled: true, CloudWatchLogGroupName: aws.String("/gossm/patching")},
+		},
+		{name: "disabled", opts: RunCommandOptions{CloudWatchDisabled: true}, want: nil},
+	}
+
+	for _, tt := range tests {
+		got := sendCommandInput([]string{"i-1"}, "uptime", tt.opts).CloudWatchOutputConfig
+		if (got == nil) != (tt.want == nil) ||
+			got != nil && (got.CloudWatchOutputEnabled != tt.want.CloudWatchOutputEnabled ||
+				aws.ToString(got.CloudWatchLogGroupName) != aws.ToString(tt.want.CloudWatchLogGroupName)) {
+			t.Errorf("%s: CloudWatchOutputConfig = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAskTargetWithoutTerminal(t *testing.T) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("stdin is a terminal")
+	}
+
+	_, err := AskTarget(map[string]*Target{"web": {Name: "i-0aaaaaaaaaaaaaaa1"}})
+	if !errors.Is(err, ErrNoTerminal) {
+		t.Fatalf("AskTarget() error = %v, want %v", err, ErrNoTerminal)
+	}
+	want := "no target specified and no terminal available for selection; pass --target or --instance-id"
+	if err.Error() != want {
+		t.Errorf("AskTarget() error = %q, want %q", err, want)
+	}
+}
+
+func TestGroupedTargetOptions(t *testing.T) {
+	instances := map[string]*Target{
+		"web-1": {Name: "i-1", Tags: map[string]string{"Role": "web"}},
+		"web-2": {Name: "i-2", Tags: map[string]string{"Role": "web"}},
+		"db-1":  {Name: "i-3", Tags: map[string]string{"Role": "db"}},
+		"other": {Name: "i-4"},
+	}
+
+	options, groups := groupedTargetOptions(instances, "Role")
+	want := []string{
+		"[all Role=db] (1 instance)", "db-1",
+		"[all Role=web] (2 instances)", "web-1", "web-2",
+		"[all without Role] (1 instance)", "other",
+	}
+	if !slices.Equal(options, want) {
+		t.Errorf("options = %q, want %q", options, want)
+	}
+	if got := groups["[all Role=web] (2 instances)"]; !slices.Equal(got, []string{"web-1", "web-2"}) {
+		t.Errorf("web group = %q, want web-1 and web-2", got)
+	}
+}
+
+func TestGenerateSSHExecCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		exec     string
+		identity string
+		user     string
+		domain   string
+		want     string
+		wantErr  bool
+	}{
+		{name: "user and domain", user: "ec2-user", domain: "ip-10-0-0-10.ec2.internal", want: "ec2-user@ip-10-0-0-10.ec2.internal"},
+		{name: "identity added", identity: "key.pem", user: "ubuntu", domain: "i-1", want: "-i key.pem ubuntu@i-1"},
+		{name: "no user", domain: "i-1", want: "i-1"},
+		{name: "domain with user", user: "root", domain: "admin@i-1", want: "admin@i-1"},
+		{name: "empty domain", user: "root", wantErr: true},
+		{name: "exec kept", exec: "-p 2222 ec2-user@i-1", want: "-p 2222 ec2-user@i-1"},
+		{name: "exec without identity", exec: "ec2-user@i-1", identity: "key.pem", want: "-i key.pem ec2-user@i-1"},
+		{name: "identity at start", exec: "-i other.pem ec2-user@i-1", identity: "key.pem", want: "-i other.pem ec2-user@i-1"},
+		{name: "identity after tab", exec: "-v\t-i other.pem ec2-user@i-1", identity: "key.pem", want: "-v\t-i other.pem ec2-user@i-1"},
+		{name: "identity combined", exec: "-vi other.pem ec2-user@i-1", identity: "key.pem", want: "-vi other.pem ec2-user@i-1"},
+		{name: "identity attached", exec: "-iother.pem ec2-user@i-1", identity: "key.pem", want: "-iother.pem ec2-user@i-1"},
+		{name: "-i in option argument", exec: "-o IdentitiesOnly=yes -l -i ec2-user@i-1", identity: "key.pem", want: "-i key.pem -o IdentitiesOnly=yes -l -i ec2-user@i-1"},
+		{name: "-i in remote command", exec: "ec2-user@i-1 ls -i", identity: "key.pem", want: "-i key.pem ec2-user@i-1 ls -i"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GenerateSSHExecCommand(tt.exec, tt.identity, tt.user, tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateSSHExecCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GenerateSSHExecCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSSMTargets(t *testing.T) {
+	targets, err := ParseSSMTargets([]string{"tag:Env=prod", "tag:Role=web, worker"})
+	if err != nil {
+		t.Fatalf("ParseSSMTargets() error = %v", err)
+	}
+	if len(targets) != 2 || aws.ToString(targets[0].Key) != "tag:Env" || !slices.Equal(targets[1].Values, []string{"web", "worker"}) {
+		t.Errorf("ParseSSMTargets() = %+v, want tag:Env=prod and tag:Role=web,worker", targets)
+	}
+
+	for _, specs := range [][]string{{"tag:Env"}, {"=prod"}, {"tag:Env="}, {"a=1", "b=1", "c=1", "d=1", "e=1", "f=1"}} {
+		if _, err := ParseSSMTargets(specs); err == nil {
+			t.Errorf("ParseSSMTargets(%q) succeeded, want an error", specs)
+		}
+	}
+}
+
+func TestCommandExitStatus(t *testing.T) {
+	tests := []struct {
+		output *ssm.GetCommandInvocationOutput
+		want   string
+	}{
+		{output: &ssm.GetCommandInvocationOutput{Status: ssmtypes.CommandInvocationStatusSuccess}, want: "exit 0"},
+		{output: &ssm.GetCommandInvocationOutput{Status: ssmtypes.CommandInvocationStatusFailed, ResponseCode: 2}, want: "exit 2"},
+		{output: &ssm.GetCommandInvocationOutput{Status: ssmtypes.CommandInvocationStatusTimedOut, ResponseCode: -1}, want: "TimedOut"},
+	}
+
+	for _, tt := range tests {
+		if got := commandExitStatus(tt.output); got != tt.want {
+			t.Errorf("commandExitStatus(%s, %d) = %q, want %q", tt.output.Status, tt.output.ResponseCode, got, tt.want)
+		}
+	}
+}
+
+func TestTruncationNotice(t *testing.T) {
+	full := strings.Repeat("x", commandOutputLimit)
+	tests := []struct {
+		name   string
+		output *ssm.GetCommandInvocationOutput
+		want   string
+	}{
+		{
+			name:   "complete",
+			output: &ssm.GetCommandInvocationOutput{StandardOutputContent: aws.String("ok\n")},
+			want:   "",
+		},
+		{
+			name:   "at limit",
+			output: &ssm.GetCommandInvocationOutput{StandardOutputContent: aws.String(full)},
+			want:   "[output truncated — configure --output-s3-bucket for full output]",
+		},
+		{
+			name: "agent marker in stderr",
+			output: &ssm.GetCommandInvocationOutput{
+				StandardErrorContent: aws.String("boom\n" + truncatedErrorMarker),
+				DocumentName:         aws.String("AWS-RunShellScript"),
+				CloudWatchOutputConfig: &ssmtypes.CloudWatchOutputConfig{
+					CloudWatchOutputEnabled: true,
+				},
+			},
+			want: "[output truncated, full output in CloudWatch log group /aws/ssm/AWS-RunShellScript]",
+		},
+		{
+			name: "s3",
+			output: &ssm.GetCommandInvocationOutput{
+				StandardOutputContent: aws.String(full),
+				StandardOutputUrl:     aws.String("https://s3.amazonaws.com/bucket/stdout"),
+			},
+			want: "[output truncated, full output in https://s3.amazonaws.com/bucket/stdout]",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := truncationNotice(tt.output); got != tt.want {
+			t.Errorf("%s: truncationNotice() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindOptionsDescription(t *testing.T) {
+	tests := []struct {
+		opts FindOptions
+		want string
+	}{
+		{opts: FindOptions{MaxInstances: 10}, want: ""},
+		{opts: FindOptions{TagFilters: []TagFilter{{Key: "Env", Value: "prod"}}}, want: "filter Env=prod"},
+		{
+			opts: FindOptions{
+				TagFilters:        []TagFilter{{Key: "Env", Value: "prod"}, {Key: "Role", Value: "web"}},
+				AvailabilityZones: []string{"eu-west-1a", "eu-west-1b"},
+				SubnetIDs:         []string{"subnet-1"},
+				TargetPattern:     regexp.MustCompile("^web-"),
+			},
+			want: "filter Env=prod, filter Role=web, az eu-west-1a,eu-west-1b, subnet subnet-1, target-regex ^web-",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opts.Description(); got != tt.want {
+			t.Errorf("Description() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestMatchesTarget(t *testing.T) {
+	opts := FindOptions{AvailabilityZones: []string{"eu-west-1a", "eu-west-1b"}, SubnetIDs: []string{"subnet-1"}}
+	tests := []struct {
+		target *Target
+		want   bool
+	}{
+		{target: &Target{AvailabilityZone: "eu-west-1a", SubnetID: "subnet-1"}, want: true},
+		{target: &Target{AvailabilityZone: "eu-west-1c", SubnetID: "subnet-1"}, want: false},
+		{target: &Target{AvailabilityZone: "eu-west-1b", SubnetID: "subnet-2"}, want: false},
+		{target: &Target{Name: "mi-0aaaaaaaaaaaaaaa1"}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := opts.matchesTarget(tt.target); got != tt.want {
+			t.Errorf("matchesTarget(%s/%s) = %v, want %v", tt.target.AvailabilityZone, tt.target.SubnetID, got, tt.want)
+		}
+	}
+	if !(FindOptions{}).matchesTarget(&Target{}) {
+		t.Error("matchesTarget() without filters = false, want true")
+	}
+
+	pattern := FindOptions{TargetPattern: regexp.MustCompile(`^prod-web-\d+$|^i-0aaa`)}
+	for target, want := range map[*Target]bool{
+		{InstanceName: "prod-web-12", Name: "i-0bbbbbbbbbbbbbbb2"}:   true,
+		{InstanceName: "prod-web-a", Name: "i-0bbbbbbbbbbbbbbb2"}:    false,
+		{InstanceName: "staging-web-1", Name: "i-0aaaaaaaaaaaaaaa1"}: true,
+	} {
+		if got := pattern.matchesTarget(target); got != want {
+			t.Errorf("matchesTarget(%s, %s) = %v, want %v", target.InstanceName, target.Name, got, want)
+		}
+	}
+}
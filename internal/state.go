@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// maxRecentTargets is how many recently connected targets are remembered
+const maxRecentTargets = 20
+
+// State holds values gossm remembers between runs
+type State struct {
+	Regions  map[string]string        `json:"regions,omitempty"`  // Last selected region per AWS profile
+	Users    map[string]string        `json:"users,omitempty"`    // Last SSH user that logged in, per instance ID
+	Sessions map[string]SessionRecord `json:"sessions,omitempty"` // Sessions started by gossm that were not terminated, by ID
+	Recent   []RecentTarget           `json:"recent,omitempty"`   // Targets most recently connected to, newest first
+}
+
+// SessionRecord describes an SSM session started by gossm
+type SessionRecord struct {
+	Profile string    `json:"profile"`
+	Region  string    `json:"region"`
+	Target  string    `json:"target"`
+	Started time.Time `json:"started"`
+}
+
+// RecentTarget describes a target gossm connected to
+type RecentTarget struct {
+	Target  string    `json:"target"`         // Instance ID
+	Name    string    `json:"name,omitempty"` // Value of the Name tag
+	Profile string    `json:"profile"`
+	Region  string    `json:"region"`
+	Used    time.Time `json:"used"`
+}
+
+// LoadState reads the state file, returning an empty state if it does not exist
+func LoadState(path string) (*State, error) {
+	state := &State{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return state, nil
+}
+
+// Save writes the state file. It is written to a temporary file first and renamed over the
+// state file, so a crash never leaves a truncated state file behind.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateState loads the state file, applies update and saves it, holding a lock on the state
+// file throughout so gossm processes finishing at the same time don't lose each other's changes.
+// A state file that can't be read is replaced.
+func UpdateState(path string, update func(state *State)) error {
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock state file: %w", err)
+	}
+	defer unlock()
+
+	state, err := LoadState(path)
+	if err != nil {
+		Warnf("Ignoring state file: %v", err)
+		state = &State{}
+	}
+
+	update(state)
+	return state.Save(path)
+}
+
+// SetRegion remembers the region selected for a profile
+func (s *State) SetRegion(profile, region string) {
+	if s.Regions == nil {
+		s.Regions = make(map[string]string)
+	}
+	s.Regions[profile] = region
+}
+
+// SetUser remembers the SSH user that logged in to an instance
+func (s *State) SetUser(instanceID, user string) {
+	if s.Users == nil {
+		s.Users = make(map[string]string)
+	}
+	s.Users[instanceID] = user
+}
+
+// AddSession records a session started by gossm
+func (s *State) AddSession(sessionID string, record SessionRecord) {
+	if s.Sessions == nil {
+		s.Sessions = make(map[string]SessionRecord)
+	}
+	s.Sessions[sessionID] = record
+}
+
+// RemoveSession forgets a session once it has been terminated
+func (s *State) RemoveSession(sessionID string) {
+	delete(s.Sessions, sessionID)
+}
+
+// AddRecent records a connection to a target, moving it to the top of the recent targets
+func (s *State) AddRecent(recent RecentTarget) {
+	s.Recent = slices.DeleteFunc(s.Recent, func(r RecentTarget) bool {
+		return r.Target == recent.Target && r.Profile == recent.Profile
+	})
+	s.Recent = slices.Insert(s.Recent, 0, recent)
+	if len(s.Recent) > maxRecentTargets {
+		s.Recent = s.Recent[:maxRecentTargets]
+	}
+}
+
+// RecentTargets returns the targets most recently connected to with a profile, newest first
+func (s *State) RecentTargets(profile string) []RecentTarget {
+	var recent []RecentTarget
+	for _, r := range s.Recent {
+		if r.Profile == profile {
+			recent = append(recent, r)
+		}
+	}
+	return recent
+}
+
+// AskRecentTarget prompts the user to select one of the numbered recent targets, returning its index
+func AskRecentTarget(recent []RecentTarget) (int, error) {
+	if err := requireTerminal("recent target", "--recent=<number>"); err != nil {
+		return 0, err
+	}
+
+	options := make([]string, len(recent))
+	for i, r := range recent {
+		options[i] = fmt.Sprintf("%d) %s", i+1, r)
+	}
+
+	var selected int
+	err := survey.AskOne(&survey.Select{
+		Message: "Choose a recent target:",
+		Options: options,
+	}, &selected,
+		survey.WithIcons(func(icons *survey.IconSet) {
+			icons.SelectFocus.Format = "green+hb"
+		}),
+		survey.WithPageSize(20))
+	if err != nil {
+		return 0, fmt.Errorf("target selection failed: %w", err)
+	}
+
+	return selected, nil
+}
+
+// String describes the target by name, instance ID and region
+func (r RecentTarget) String() string {
+	if r.Name == "" {
+		return fmt.Sprintf("%s (%s)", r.Target, r.Region)
+	}
+	return fmt.Sprintf("%s (%s, %s)", r.Name, r.Target, r.Region)
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAddRecent(t *testing.T) {
+	state := &State{}
+	state.AddRecent(RecentTarget{Target: "i-1", Profile: "dev"})
+	state.AddRecent(RecentTarget{Target: "i-2", Profile: "dev"})
+	state.AddRecent(RecentTarget{Target: "i-1", Profile: "prod"})
+	state.AddRecent(RecentTarget{Target: "i-1", Profile: "dev", Region: "eu-west-1"})
+
+	recent := state.RecentTargets("dev")
+	if len(recent) != 2 || recent[0].Target != "i-1" || recent[0].Region != "eu-west-1" || recent[1].Target != "i-2" {
+		t.Errorf("RecentTargets(dev) = %+v, want i-1 (eu-west-1) then i-2", recent)
+	}
+	if recent := state.RecentTargets("prod"); len(recent) != 1 {
+		t.Errorf("RecentTargets(prod) = %+v, want a single target", recent)
+	}
+
+	for i := range maxRecentTargets + 5 {
+		state.AddRecent(RecentTarget{Target: fmt.Sprintf("i-%d", i+10), Profile: "dev"})
+	}
+	if len(state.Recent) != maxRecentTargets {
+		t.Errorf("len(Recent) = %d, want %d", len(state.Recent), maxRecentTargets)
+	}
+}
+
+func TestUpdateStateConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	// Every update must survive, whichever order the writers run in
+	wg := &sync.WaitGroup{}
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := UpdateState(path, func(state *State) {
+				state.SetUser(fmt.Sprintf("i-%d", i), "ec2-user")
+			}); err != nil {
+				t.Errorf("UpdateState() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(state.Users) != 20 {
+		t.Errorf("state has %d users, want 20", len(state.Users))
+	}
+
+	// Only the state file and its lock are left
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("state directory holds %d files, want the state file and its lock", len(entries))
+	}
+}
+
+func TestUpdateStateReplacesCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"users":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateState(path, func(state *State) { state.SetUser("i-1", "ubuntu") }); err != nil {
+		t.Fatalf("UpdateState() error = %v", err)
+	}
+	state, err := LoadState(path)
+	if err != nil || state.Users["i-1"] != "ubuntu" {
+		t.Errorf("LoadState() = %+v, %v; want user ubuntu for i-1", state, err)
+	}
+}
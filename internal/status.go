@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+// Invocation states shown on the status board
+const (
+	statusPending = "pending"
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// statusBoard shows a live status line per instance on stderr below the results printed to
+// stdout. It is only drawn when both are the same terminal, as it redraws itself in place.
+type statusBoard struct {
+	out       io.Writer
+	mu        *sync.Mutex
+	live      bool
+	instances []string
+	states    map[string]string
+	drawn     int // Number of lines currently drawn
+}
+
+// newStatusBoard returns a board with every instance pending, drawn if the terminal allows it
+func newStatusBoard(instanceIDs []string) *statusBoard {
+	live := term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stderr.Fd())) &&
+		logger.Enabled(context.Background(), slog.LevelInfo) && !DebugEnabled()
+
+	// A board taller than the terminal can't be redrawn in place
+	if _, rows, err := term.GetSize(int(os.Stderr.Fd())); err != nil || len(instanceIDs) >= rows {
+		live = false
+	}
+
+	b := &statusBoard{
+		out:       os.Stderr,
+		mu:        stderrMu,
+		live:      live,
+		instances: instanceIDs,
+		states:    make(map[string]string, len(instanceIDs)),
+	}
+	for _, id := range instanceIDs {
+		b.states[id] = statusPending
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw()
+	return b
+}
+
+// set updates the state of an instance
+func (b *statusBoard) set(instanceID, state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.states[instanceID] == state {
+		return
+	}
+	b.states[instanceID] = state
+	b.clear()
+	b.draw()
+}
+
+// printResult prints a result line to stdout above the board
+func (b *statusBoard) printResult(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	fmt.Printf(format, args...)
+	b.draw()
+}
+
+// close erases the board
+func (b *statusBoard) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+}
+
+// clear erases the drawn board, leaving the cursor where it started
+func (b *statusBoard) clear() {
+	if b.drawn > 0 {
+		fmt.Fprintf(b.out, "\033[%dA\r\033[J", b.drawn)
+		b.drawn = 0
+	}
+}
+
+// draw writes a line per instance
+func (b *statusBoard) draw() {
+	if !b.live {
+		return
+	}
+	for _, id := range b.instances {
+		fmt.Fprintf(b.out, "  %s %s\n", color.YellowString(id), colorForStatus(b.states[id]))
+	}
+	b.drawn = len(b.instances)
+}
+
+// colorForStatus renders an invocation state
+func colorForStatus(state string) string {
+	switch state {
+	case statusDone:
+		return color.GreenString(state)
+	case statusFailed:
+		return color.RedString(state)
+	case statusRunning:
+		return color.CyanString(state)
+	default:
+		return color.HiBlackString(state)
+	}
+}
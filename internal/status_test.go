@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestStatusBoardRedraw(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	var out bytes.Buffer
+	b := &statusBoard{
+		out:       &out,
+		mu:        &sync.Mutex{},
+		live:      true,
+		instances: []string{"i-1", "i-2"},
+		states:    map[string]string{"i-1": statusPending, "i-2": statusPending},
+	}
+	b.draw()
+
+	b.set("i-2", statusRunning)
+	b.set("i-2", statusRunning) // Unchanged states don't redraw
+	b.close()
+
+	want := "  i-1 pending\n  i-2 pending\n" +
+		"\033[2A\r\033[J" + "  i-1 pending\n  i-2 running\n" +
+		"\033[2A\r\033[J"
+	if got := out.String(); got != want {
+		t.Errorf("board output = %q, want %q", got, want)
+	}
+}
+
+func TestStatusBoardNotLive(t *testing.T) {
+	var out bytes.Buffer
+	b := &statusBoard{out: &out, mu: &sync.Mutex{}, instances: []string{"i-1"}, states: map[string]string{}}
+	b.draw()
+	b.set("i-1", statusDone)
+	b.close()
+
+	if out.Len() != 0 {
+		t.Errorf("board output = %q, want nothing when not live", out.String())
+	}
+}
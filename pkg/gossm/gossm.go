@@ -0,0 +1,103 @@
+// Package gossm lets other Go programs start interactive AWS Systems Manager sessions the way
+// the gossm CLI does, without shelling out to it.
+//
+//	pluginPath, err := gossm.InstallPlugin(dir)
+//	...
+//	err = gossm.StartSession(ctx, cfg, "i-1234567890abcdef0", gossm.Options{
+//		PluginPath: pluginPath,
+//		EscapeChar: gossm.DefaultEscapeChar,
+//	})
+package gossm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+
+	"github.com/ottramst/gossm/internal"
+)
+
+// DefaultEscapeChar is the escape character the gossm CLI uses, as in ssh
+const DefaultEscapeChar = internal.DefaultEscapeChar
+
+// Options configures a session started with StartSession
+type Options struct {
+	PluginPath   string              // Path of the session-manager-plugin executable (required), see InstallPlugin
+	Profile      string              // AWS profile name passed to the plugin
+	DocumentName string              // SSM session document, the account's standard shell document if empty
+	Parameters   map[string][]string // Parameters of the session document
+	EscapeChar   byte                // Escape character for ~. style sequences, or 0 to disable them
+
+	// ConnectTimeout ends the session if it isn't established in time, IdleTimeout after this long
+	// without input or output, and MaxDuration once it has been open this long; 0 disables them
+	ConnectTimeout time.Duration
+	IdleTimeout    time.Duration
+	MaxDuration    time.Duration
+}
+
+// StartSession starts an SSM session with the target instance, runs the plugin attached to the
+// terminal until the session ends, and terminates the session. ctx bounds the API calls; the
+// session is terminated even if ctx is canceled.
+func StartSession(ctx context.Context, cfg aws.Config, target string, opts Options) error {
+	if target == "" {
+		return errors.New("no target to start a session with")
+	}
+	if opts.PluginPath == "" {
+		return errors.New("no SSM plugin path, install it with InstallPlugin")
+	}
+
+	input := &ssm.StartSessionInput{
+		Target:     aws.String(target),
+		Parameters: opts.Parameters,
+	}
+	if opts.DocumentName != "" {
+		input.DocumentName = aws.String(opts.DocumentName)
+	}
+
+	session, err := internal.CreateStartSession(ctx, cfg, input)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	process := internal.ProcessOptions{
+		EscapeChar:     opts.EscapeChar,
+		SessionID:      aws.ToString(session.SessionId),
+		Target:         target,
+		Region:         cfg.Region,
+		ConnectTimeout: opts.ConnectTimeout,
+		IdleTimeout:    opts.IdleTimeout,
+		MaxDuration:    opts.MaxDuration,
+	}
+	err = internal.CallSessionPlugin(process, opts.PluginPath, session, input, cfg.Region, opts.Profile)
+
+	terminateErr := internal.DeleteStartSession(context.WithoutCancel(ctx), cfg, &ssm.TerminateSessionInput{
+		SessionId: session.SessionId,
+	})
+	return errors.Join(err, terminateErr)
+}
+
+// InstallPlugin installs the SSM plugin in dir, downloading the latest version or falling back
+// to the one embedded in gossm, and returns its path. An installed plugin is kept if up to date.
+// Downloads and version information are kept in dir as well, nothing is written outside it.
+func InstallPlugin(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create plugin directory: %w", err)
+	}
+
+	plugin, err := internal.GetSsmPlugin(dir, "", internal.PluginSourceAuto)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, internal.GetSsmPluginName())
+	if err := internal.WriteSsmPlugin(path, plugin); err != nil {
+		return "", fmt.Errorf("failed to install SSM plugin: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,59 @@
+package gossm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestStartSessionValidatesArguments(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		opts   Options
+	}{
+		{name: "no target", opts: Options{PluginPath: "/usr/local/bin/session-manager-plugin"}},
+		{name: "no plugin", target: "i-1234567890abcdef0"},
+	}
+
+	for _, tt := range tests {
+		if err := StartSession(context.Background(), aws.Config{}, tt.target, tt.opts); err == nil {
+			t.Errorf("%s: StartSession() succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestInstallPluginOnlyWritesToDir(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skipf("no embedded plugin to fall back to on %s", runtime.GOOS)
+	}
+
+	// Fail the download right away, so the embedded plugin is installed without network access
+	home, tmp := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("HTTPS_PROXY", "http://127.0.0.1:1")
+
+	dir := filepath.Join(t.TempDir(), "plugins")
+	path, err := InstallPlugin(dir)
+	if err != nil {
+		t.Fatalf("InstallPlugin() error = %v", err)
+	}
+	if filepath.Dir(path) != dir {
+		t.Errorf("InstallPlugin() = %s, want a path in %s", path, dir)
+	}
+
+	for _, outside := range []string{home, tmp} {
+		entries, err := os.ReadDir(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) > 0 {
+			t.Errorf("InstallPlugin() wrote %s in %s, outside %s", entries[0].Name(), outside, dir)
+		}
+	}
+}